@@ -0,0 +1,52 @@
+// DaVinci Script
+
+package main
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/cache"
+	"os"
+)
+
+const cacheUsage = `Usage: davi cache <command>
+
+Scripts run from a file are parsed once and the parsed program is cached,
+keyed by the script's source and the davi version. The cache is in
+$DAVI_CACHE if it's set, or in davi under the user's cache directory. Set
+DAVI_CACHE=off to turn it off.
+
+Commands:
+  clear   remove all cached programs
+  dir     print the cache directory
+`
+
+// runCache implements the "davi cache" command.
+func runCache(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, cacheUsage)
+		return exitUsageError
+	}
+	switch args[0] {
+	case "-h", "--help":
+		fmt.Print(cacheUsage)
+		return exitOK
+	case "clear", "dir":
+	default:
+		fmt.Fprintf(os.Stderr, "davi cache: unknown command %s\n\n%s", args[0], cacheUsage)
+		return exitUsageError
+	}
+	c := cache.Default(version)
+	if c == nil {
+		fmt.Fprintln(os.Stderr, "davi cache: the cache is off")
+		return exitUsageError
+	}
+	if args[0] == "dir" {
+		fmt.Println(c.Dir)
+		return exitOK
+	}
+	if err := c.Clear(); err != nil {
+		fmt.Fprintf(os.Stderr, "davi cache: %s\n", err)
+		return exitRuntimeError
+	}
+	return exitOK
+}
@@ -0,0 +1,125 @@
+// DaVinci Script
+
+// Package cache keeps parsed programs on disk, so running a script that
+// hasn't changed since its last run doesn't lex and parse it again.
+//
+// Programs are stored in a directory as files named by a hash of their
+// source and the davi version, so editing a script or upgrading davi never
+// picks up a stale program. The directory is $DAVI_CACHE if it's set, or
+// "davi" in the user's cache directory. Setting DAVI_CACHE to "off" turns
+// the cache off.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/DavinciScript/Davi/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// suffix is the file extension of cached programs.
+const suffix = ".ast"
+
+// Cache is a directory of parsed programs.
+type Cache struct {
+	Dir     string // directory the programs are stored in
+	Version string // davi version, part of the key of each program
+}
+
+// Default returns the cache described in the package comment for the
+// given davi version, or nil if the cache is off or there's no user cache
+// directory.
+func Default(version string) *Cache {
+	dir := os.Getenv("DAVI_CACHE")
+	switch dir {
+	case "off":
+		return nil
+	case "":
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(userDir, "davi")
+	}
+	return &Cache{dir, version}
+}
+
+// path returns the name of the file the program for source is stored in.
+func (c *Cache) path(source []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.Version))
+	h.Write([]byte{0})
+	h.Write(source)
+	return filepath.Join(c.Dir, hex.EncodeToString(h.Sum(nil))+suffix)
+}
+
+// Parse parses source from the named file like parser.ParseFile, returning
+// the cached program if there is one, and otherwise adding the program to
+// the cache. The cache is only an optimization, so errors reading or
+// writing it are ignored. A nil Cache just parses source.
+func (c *Cache) Parse(file string, source []byte) (*parser.Program, error) {
+	if c == nil {
+		return parser.ParseFile(file, source)
+	}
+	path := c.path(source)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if prog, err := parser.Decode(file, data); err == nil {
+			return prog, nil
+		}
+	}
+	prog, err := parser.ParseFile(file, source)
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, parser.Encode(prog))
+	return prog, nil
+}
+
+// store writes data to path through a temporary file, so a program that's
+// being run at the same time never sees a partly written file.
+func (c *Cache) store(path string, data []byte) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.Dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Clear removes the cached programs, and the cache directory if that
+// leaves it empty. Other files in the directory are left alone.
+func (c *Cache) Clear() error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, suffix) || strings.HasPrefix(name, "tmp-") {
+			if err := os.Remove(filepath.Join(c.Dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+	// Fails harmlessly if the directory has other files in it
+	os.Remove(c.Dir)
+	return nil
+}
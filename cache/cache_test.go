@@ -0,0 +1,102 @@
+// DaVinci Script
+
+package cache
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// scripts returns the sources of the test and example scripts, without
+// their <?davi ?> tags.
+func scripts(t *testing.T) map[string][]byte {
+	names, err := filepath.Glob("../tests/*.davi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples, err := filepath.Glob("../examples/*.davi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources := make(map[string][]byte)
+	for _, name := range append(names, examples...) {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source = bytes.Replace(source, []byte("<?davi"), nil, 1)
+		source = bytes.Replace(source, []byte("?>"), nil, 1)
+		sources[name] = source
+	}
+	return sources
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for name, source := range scripts(t) {
+		prog, err := parser.ParseFile(name, source)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		decoded, err := parser.Decode(name, parser.Encode(prog))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, prog) {
+			t.Errorf("%s: decoded program differs from the parsed program", name)
+		}
+	}
+
+	data := parser.Encode(&parser.Program{})
+	for _, bad := range [][]byte{nil, []byte("davi"), data[:len(data)-1], append(data, 0)} {
+		if _, err := parser.Decode("", bad); err == nil {
+			t.Errorf("decoding %q: expected an error", bad)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := &Cache{filepath.Join(t.TempDir(), "cache"), "1.0"}
+	source := []byte(`function f($x) { return $x + 1; } echo(f(1))`)
+	if _, err := c.Parse("a.davi", source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(source)); err != nil {
+		t.Fatalf("program wasn't cached: %v", err)
+	}
+	// The cached program gets the name of the file it's parsed from
+	prog, err := parser.ParseFile("b.davi", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.Parse("b.davi", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cached, prog) {
+		t.Errorf("cached program differs from the parsed program")
+	}
+
+	other := &Cache{c.Dir, "2.0"}
+	if other.path(source) == c.path(source) {
+		t.Errorf("versions share a cache key")
+	}
+
+	if _, err := c.Parse("", []byte(`echo(`)); err == nil {
+		t.Errorf("expected a parse error")
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.Dir); !os.IsNotExist(err) {
+		t.Errorf("cache directory wasn't removed: %v", err)
+	}
+	if err := c.Clear(); err != nil {
+		t.Errorf("clearing an empty cache: %v", err)
+	}
+}
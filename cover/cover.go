@@ -0,0 +1,134 @@
+// DaVinci Script
+
+// Package cover writes the code coverage recorded by an
+// interpreter.Coverage as reports: an lcov tracefile for coverage tools and
+// CI services, and an HTML page showing each file's source annotated with
+// what ran.
+//
+// Coverage is reported by line: a line's count is the most times any
+// statement starting on it was executed. Each if and while statement has
+// two branches, for its condition being true and false.
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"io"
+)
+
+// Line is the coverage of a source line with statements on it.
+type Line struct {
+	Number   int
+	Count    int
+	Branches []Branch
+}
+
+// Branch is the coverage of an if or while condition on a line.
+type Branch struct {
+	True, False int
+	Executed    bool // false if the statement never ran
+}
+
+// Lines returns the coverage of the lines of file that have statements
+// starting on them, in order.
+func Lines(c *interpreter.Coverage, file string) []Line {
+	lines := []Line{}
+	for _, s := range c.Statements(file) {
+		number := s.Statement.Position().Line
+		if len(lines) == 0 || lines[len(lines)-1].Number != number {
+			lines = append(lines, Line{Number: number})
+		}
+		line := &lines[len(lines)-1]
+		if s.Count > line.Count {
+			line.Count = s.Count
+		}
+		if s.Branches() {
+			line.Branches = append(line.Branches, Branch{s.True, s.False, s.Count > 0})
+		}
+	}
+	return lines
+}
+
+// Summary is the coverage totals of a file or set of files.
+type Summary struct {
+	Lines, LinesHit       int
+	Branches, BranchesHit int
+}
+
+// Summarize returns the totals of lines.
+func Summarize(lines []Line) Summary {
+	var s Summary
+	for _, line := range lines {
+		s.Lines++
+		if line.Count > 0 {
+			s.LinesHit++
+		}
+		for _, b := range line.Branches {
+			s.Branches += 2
+			if b.True > 0 {
+				s.BranchesHit++
+			}
+			if b.False > 0 {
+				s.BranchesHit++
+			}
+		}
+	}
+	return s
+}
+
+// Add adds the totals of t to s.
+func (s *Summary) Add(t Summary) {
+	s.Lines += t.Lines
+	s.LinesHit += t.LinesHit
+	s.Branches += t.Branches
+	s.BranchesHit += t.BranchesHit
+}
+
+// Percent returns the percentage of lines hit, or 100 if there are none.
+func (s Summary) Percent() float64 {
+	return percent(s.LinesHit, s.Lines)
+}
+
+// BranchPercent returns the percentage of branches hit, or 100 if there
+// are none.
+func (s Summary) BranchPercent() float64 {
+	return percent(s.BranchesHit, s.Branches)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// WriteLCOV writes the coverage to w as an lcov tracefile, with a record
+// for each file giving the count of each line (DA) and branch (BRDA).
+func WriteLCOV(w io.Writer, c *interpreter.Coverage) error {
+	bw := bufio.NewWriter(w)
+	for _, file := range c.Files() {
+		lines := Lines(c, file)
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", file)
+		block := 0
+		for _, line := range lines {
+			for _, b := range line.Branches {
+				if b.Executed {
+					fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\n", line.Number, block, b.True)
+					fmt.Fprintf(bw, "BRDA:%d,%d,1,%d\n", line.Number, block, b.False)
+				} else {
+					fmt.Fprintf(bw, "BRDA:%d,%d,0,-\n", line.Number, block)
+					fmt.Fprintf(bw, "BRDA:%d,%d,1,-\n", line.Number, block)
+				}
+				block++
+			}
+		}
+		summary := Summarize(lines)
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", summary.Branches, summary.BranchesHit)
+		for _, line := range lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line.Number, line.Count)
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", summary.Lines, summary.LinesHit)
+	}
+	return bw.Flush()
+}
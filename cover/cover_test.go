@@ -0,0 +1,79 @@
+// DaVinci Script
+
+package cover
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+const source = `function sign($n) {
+    if ($n < 0) { return -1; }
+    if ($n == 0) {
+        return 0;
+    }
+    return 1;
+}
+sign(-5); sign(3);
+`
+
+func coverage(t *testing.T) *interpreter.Coverage {
+	prog, err := parser.ParseFile("s.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := interpreter.NewCoverage()
+	if _, err := interpreter.Execute(prog, &interpreter.Config{Coverage: c}); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLCOV(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteLCOV(&out, coverage(t)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `TN:
+SF:s.davi
+BRDA:2,0,0,1
+BRDA:2,0,1,1
+BRDA:3,1,0,0
+BRDA:3,1,1,1
+BRF:4
+BRH:3
+DA:1,1
+DA:2,2
+DA:3,1
+DA:4,0
+DA:6,1
+DA:8,1
+LF:6
+LH:5
+end_of_record
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestHTML(t *testing.T) {
+	var out bytes.Buffer
+	read := func(file string) ([]byte, error) { return []byte(source), nil }
+	if err := WriteHTML(&out, coverage(t), read); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<td><a href="#file0">s.davi</a></td><td>83.3% (5/6)</td><td>75.0% (3/4)</td>`,
+		`<tr class="partial"><td class="number">3</td><td class="count">1</td><td class="code">    if ($n == 0) {</td><td class="branches">true 0, false 1</td></tr>`,
+		`<tr class="miss"><td class="number">4</td><td class="count">0</td><td class="code">        return 0;</td>`,
+		`<tr class=""><td class="number">5</td><td class="count"></td><td class="code">    }</td>`,
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report doesn't contain %s:\n%s", s, out.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// DaVinci Script
+
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// htmlFile is a file in the HTML report.
+type htmlFile struct {
+	Name    string
+	ID      string
+	Summary Summary
+	Lines   []htmlLine
+}
+
+// htmlLine is a source line in the HTML report.
+type htmlLine struct {
+	Number   int
+	Source   string
+	Class    string // "hit", "miss", "partial", or "" for no statements
+	Count    string
+	Branches string
+}
+
+// WriteHTML writes the coverage to w as an HTML page that lists the files
+// with their coverage and shows the source of each, with the lines that
+// ran in green, the lines that didn't in red, and lines whose conditions
+// only went one way in yellow. source returns the source of a file.
+func WriteHTML(w io.Writer, c *interpreter.Coverage, source func(file string) ([]byte, error)) error {
+	var files []htmlFile
+	var total Summary
+	for i, name := range c.Files() {
+		src, err := source(name)
+		if err != nil {
+			return err
+		}
+		lines := Lines(c, name)
+		summary := Summarize(lines)
+		total.Add(summary)
+		files = append(files, htmlFile{name, fmt.Sprintf("file%d", i), summary, annotate(src, lines)})
+	}
+	return htmlTemplate.Execute(w, struct {
+		Files []htmlFile
+		Total Summary
+	}{files, total})
+}
+
+// annotate returns the lines of src annotated with their coverage.
+func annotate(src []byte, lines []Line) []htmlLine {
+	coverage := make(map[int]Line)
+	for _, line := range lines {
+		coverage[line.Number] = line
+	}
+	sourceLines := strings.Split(string(bytes.TrimSuffix(src, []byte("\n"))), "\n")
+	annotated := make([]htmlLine, len(sourceLines))
+	for i, text := range sourceLines {
+		l := htmlLine{Number: i + 1, Source: text}
+		if line, ok := coverage[i+1]; ok {
+			l.Count = fmt.Sprint(line.Count)
+			l.Class = "hit"
+			if line.Count == 0 {
+				l.Class = "miss"
+			}
+			branches := []string{}
+			for _, b := range line.Branches {
+				branches = append(branches, fmt.Sprintf("true %d, false %d", b.True, b.False))
+				if b.Executed && (b.True == 0 || b.False == 0) {
+					l.Class = "partial"
+				}
+			}
+			l.Branches = strings.Join(branches, "; ")
+		}
+		annotated[i] = l
+	}
+	return annotated
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Davi coverage</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table.files td { padding: 0.1em 1em 0.1em 0; }
+table.source { border-collapse: collapse; font-family: monospace; white-space: pre; }
+table.source td { padding: 0 0.5em; }
+td.number, td.count { color: #888; text-align: right; }
+td.branches { color: #888; }
+tr.hit td.code { background: #dfd; }
+tr.miss td.code { background: #fdd; }
+tr.partial td.code { background: #ffc; }
+</style>
+</head>
+<body>
+<h1>Coverage</h1>
+<table class="files">
+<tr><th>File</th><th>Lines</th><th>Branches</th></tr>
+{{range .Files}}<tr><td><a href="#{{.ID}}">{{.Name}}</a></td><td>{{printf "%.1f%%" .Summary.Percent}} ({{.Summary.LinesHit}}/{{.Summary.Lines}})</td><td>{{printf "%.1f%%" .Summary.BranchPercent}} ({{.Summary.BranchesHit}}/{{.Summary.Branches}})</td></tr>
+{{end}}<tr><td>Total</td><td>{{printf "%.1f%%" .Total.Percent}} ({{.Total.LinesHit}}/{{.Total.Lines}})</td><td>{{printf "%.1f%%" .Total.BranchPercent}} ({{.Total.BranchesHit}}/{{.Total.Branches}})</td></tr>
+</table>
+{{range .Files}}
+<h2 id="{{.ID}}">{{.Name}}</h2>
+<table class="source">
+{{range .Lines}}<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="count">{{.Count}}</td><td class="code">{{.Source}}</td><td class="branches">{{.Branches}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
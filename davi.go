@@ -5,86 +5,388 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/DavinciScript/Davi/cache"
 	"github.com/DavinciScript/Davi/interpreter"
 	"github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/lsp"
 	"github.com/DavinciScript/Davi/parser"
+	"github.com/DavinciScript/Davi/repl"
+	"github.com/DavinciScript/Davi/trace"
+	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 )
 
-func main() {
+// version is the davi release, overridden at build time with
+// -ldflags "-X main.version=...".
+var version = "0.0.2"
+
+// Exit codes
+const (
+	exitOK           = 0
+	exitRuntimeError = 1
+	exitUsageError   = 2
+	exitParseError   = 3
+)
+
+const usage = `Usage:
+  davi [run] <file> [--] [args...]   run a script
+  davi [run] -e <code> [args...]     run code given on the command line
+  davi [run] - [args...]             run a script read from standard input
+  davi repl                          start the interactive REPL
+  davi fmt [options] [path...]       format Davi source (see davi fmt --help)
+  davi lint [options] [path...]      check Davi source for likely mistakes
+  davi test [options] [path...]      run the tests in _test.davi files
+  davi debug [--dap] <file> [args...]
+                                     debug a script (see davi debug --help)
+  davi lsp                           start a language server on stdin/stdout
+  davi tokens [--comments] [file]    print the tokens of a script as JSON
+  davi ast [file]                    print the syntax tree of a script as JSON
+  davi cache clear                   remove the cache of parsed scripts
+  davi --generate-docs               generate the builtin function docs
+
+Options:
+  -e, --eval <code>   run code instead of a file
+  -h, --help          show this help
+  -v, --version       show the davi version
+
+Sandbox options (before the script):
+  --sandbox           run the script without access to files, the network,
+                      exit() or an HTTP server, except as allowed below
+  --allow-read <dir>  let the sandboxed script read files under dir
+  --allow-host <host> let the sandboxed script fetch URLs from host
+                      (as example.com or example.com:8080)
+  --allow-exit        let the sandboxed script call exit()
+  --allow-listen      let the sandboxed script serve HTTP
+The --allow-read and --allow-host options can be repeated.
+
+Profiling options (before the script):
+  --profile <file>    write a pprof profile of where the script spent its
+                      time and ops to file (see go tool pprof), and print
+                      the functions and lines that took longest to stderr
+  --profile-top <n>   print n functions and lines (default 10)
 
-	filename := os.Args[1]
+Tracing options (before the script):
+  --trace <file>      log each statement run, each function call and
+                      return (with arguments and return values) and each
+                      builtin call to file as JSON lines
+  --trace-source <pattern>
+                      only log events in files matching the glob pattern
+  --trace-function <name>
+                      only log events in or calling the named function
+The --trace-source and --trace-function options can be repeated.
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: davi <filename>")
-		os.Exit(1)
+Arguments after the script (or after --) are available to it via args().
+
+Exit codes:
+  0  success (or the code passed to exit())
+  1  runtime error
+  2  usage error or unreadable script
+  3  parse error
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsageError
 	}
 
-	if filename == "--generate-docs" {
+	switch args[0] {
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return exitOK
+	case "-v", "--version", "version":
+		fmt.Println("davi", version)
+		return exitOK
+	case "fmt":
+		return runFmt(args[1:])
+	case "lint":
+		return runLint(args[1:])
+	case "test":
+		return runTests(args[1:])
+	case "debug":
+		return runDebug(args[1:])
+	case "tokens":
+		return runTokens(args[1:])
+	case "ast":
+		return runAST(args[1:])
+	case "cache":
+		return runCache(args[1:])
+	case "lsp":
+		err := lsp.Serve(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitRuntimeError
+		}
+		return exitOK
+	case "repl":
+		err := repl.Run(&interpreter.Config{}, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitRuntimeError
+		}
+		return exitOK
+	case "--generate-docs":
 		interpreter.GenerateDocs()
-		//exec.Command("docs/npm run docs:build").Run()
-		os.Exit(0)
+		return exitOK
+	case "run":
+		args = args[1:]
 	}
+	return runScript(args)
+}
 
-	input, err := ioutil.ReadFile(filename)
-	if err != nil {
-		fmt.Printf("Error reading file. Please check the file path and try again.\n")
-		os.Exit(1)
+// runOptions are the options given to davi run before the script.
+type runOptions struct {
+	permissions *interpreter.Permissions // nil without --sandbox
+	profile     string                   // file to write a pprof profile to
+	profileTop  int                      // rows in each table of the profile summary
+	trace       string                   // file to write a JSON lines trace to
+	traceFilter trace.Filter
+}
+
+// runScript handles the run options at the start of args, then runs the
+// script given by the rest of args.
+func runScript(args []string) int {
+	options, args, code := parseRunOptions(args)
+	if code != exitOK {
+		return code
 	}
+	return runSource(args, options)
+}
 
-	// Replace <?davi with empty string
-	input = bytes.Replace(input, []byte("<?davi"), []byte(""), 1)
+// parseRunOptions parses the sandbox, profiling and tracing options at the
+// start of args, returning the options, the remaining args, and an exit code other
+// than exitOK on a usage error.
+func parseRunOptions(args []string) (*runOptions, []string, int) {
+	options := &runOptions{profileTop: 10}
+	sandbox := false
+	allowed := &interpreter.Permissions{}
+	for len(args) > 0 {
+		switch arg := args[0]; arg {
+		case "--sandbox":
+			sandbox = true
+		case "--allow-read", "--allow-host", "--profile", "--profile-top",
+			"--trace", "--trace-source", "--trace-function":
+			if len(args) < 2 {
+				return nil, nil, usageError("%s requires an argument", arg)
+			}
+			switch arg {
+			case "--allow-read":
+				allowed.FileRoots = append(allowed.FileRoots, args[1])
+			case "--allow-host":
+				allowed.Hosts = append(allowed.Hosts, args[1])
+			case "--profile":
+				options.profile = args[1]
+			case "--profile-top":
+				n, err := strconv.Atoi(args[1])
+				if err != nil || n < 1 {
+					return nil, nil, usageError("--profile-top requires a positive number, not %q", args[1])
+				}
+				options.profileTop = n
+			case "--trace":
+				options.trace = args[1]
+			case "--trace-source":
+				options.traceFilter.Files = append(options.traceFilter.Files, args[1])
+			case "--trace-function":
+				options.traceFilter.Functions = append(options.traceFilter.Functions, args[1])
+			}
+			args = args[1:]
+		case "--allow-exit":
+			allowed.Exit = true
+		case "--allow-listen":
+			allowed.Listen = true
+		default:
+			if options.trace == "" && (options.traceFilter.Files != nil || options.traceFilter.Functions != nil) {
+				return nil, nil, usageError("the --trace-source and --trace-function options require --trace")
+			}
+			if !sandbox && (allowed.Exit || allowed.Listen || allowed.FileRoots != nil || allowed.Hosts != nil) {
+				return nil, nil, usageError("the --allow options require --sandbox")
+			}
+			if sandbox {
+				options.permissions = allowed
+			}
+			return options, args, exitOK
+		}
+		args = args[1:]
+	}
+	return nil, nil, usageError("no script given")
+}
 
-	// Replace ?> with empty string
-	input = bytes.Replace(input, []byte("?>"), []byte(""), 1)
+// runSource loads the script named by the first of args (a filename, "-e
+// code" or "-" for stdin) and runs it with the rest of args as its
+// arguments.
+func runSource(args []string, options *runOptions) int {
+	if len(args) == 0 {
+		return usageError("no script given")
+	}
 
-	// Trim
-	input = bytes.TrimSpace(input)
+	var input []byte
+	var file string
+	var programs *cache.Cache
+	switch arg := args[0]; {
+	case arg == "-e" || arg == "--eval":
+		if len(args) < 2 {
+			return usageError("%s requires an argument", arg)
+		}
+		input = []byte(args[1])
+		args = args[2:]
+	case arg == "-":
+		var err error
+		input, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+			return exitUsageError
+		}
+		args = args[1:]
+	case arg == "--":
+		return runSource(args[1:], options)
+	case strings.HasPrefix(arg, "-"):
+		return usageError("unknown option %s", arg)
+	default:
+		var err error
+		input, err = ioutil.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s. Please check the file path and try again.\n", arg)
+			return exitUsageError
+		}
+		file = arg
+		programs = cache.Default(version)
+		args = args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
 
-	//fmt.Print(string(input))
-	//os.Exit(1)
+	// Keep the script's line numbers so error positions match the file
+	input = parser.StripTags(input)
 
-	prog, err := parser.ParseProgram(input)
+	prog, err := programs.Parse(file, input)
 	if err != nil {
 		errorMessage := fmt.Sprintf("%s", err)
 		if e, ok := err.(parser.Error); ok {
-			showErrorSource(input, e.Position, len(errorMessage))
+			showErrorSource(os.Stderr, input, e.Position, e.End, len(errorMessage))
 		}
-		fmt.Println(errorMessage)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, errorMessage)
+		return exitParseError
 	}
 
-	_, err = interpreter.Execute(prog, &interpreter.Config{})
+	config := &interpreter.Config{Args: args, Permissions: options.permissions}
+	if options.profile != "" {
+		finish := startProfile(config, options)
+		defer finish()
+	}
+	if options.trace != "" {
+		finish, err := startTrace(config, options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating trace %s: %s\n", options.trace, err)
+			return exitUsageError
+		}
+		defer finish()
+	}
+	_, err = interpreter.Execute(prog, config)
 	if err != nil {
 		errorMessage := fmt.Sprintf("%s", err)
 		if e, ok := err.(interpreter.Error); ok {
-			showErrorSource(input, e.Position(), len(errorMessage))
+			start, end := errorSpan(e)
+			showErrorSource(os.Stderr, input, start, end, len(errorMessage))
 		}
-		fmt.Println(errorMessage)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, errorMessage)
+		return exitRuntimeError
 	}
+	return exitOK
+}
 
+func usageError(format string, a ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "davi: "+format+"\n\n", a...)
+	fmt.Fprint(os.Stderr, usage)
+	return exitUsageError
 }
 
-// Show the source line and position of a parser or interpreter error
-func showErrorSource(source []byte, pos lexer.Position, dividerLen int) {
+// errorSpan returns the range of source to show for a runtime error: the
+// expression it's about, or just the token at its position if that's not
+// known.
+func errorSpan(e interpreter.Error) (start, end lexer.Position) {
+	if e.End().Offset > e.Start().Offset {
+		return e.Start(), e.End()
+	}
+	return e.Position(), lexer.Position{}
+}
+
+// showErrorSource shows the source line of a parser or interpreter error,
+// underlining the source from start to end, or to the end of the line if
+// end is on a later line. If end isn't after start, the token at start is
+// underlined.
+func showErrorSource(w io.Writer, source []byte, start, end lexer.Position, dividerLen int) {
+	lines := bytes.Split(source, []byte{'\n'})
+	if start.Line < 1 || start.Line > len(lines) {
+		return
+	}
+	if end.Offset <= start.Offset {
+		end = tokenEnd(source, start)
+	}
 
 	divider := strings.Repeat("-", dividerLen)
 
 	if divider != "" {
-		fmt.Println(divider)
+		fmt.Fprintln(w, divider)
 	}
 
-	lines := bytes.Split(source, []byte{'\n'})
-	errorLine := string(lines[pos.Line-1])
-	numTabs := strings.Count(errorLine[:pos.Column-1], "\t")
+	errorLine := []rune(string(lines[start.Line-1]))
+	from := start.Column - 1
+	if from > len(errorLine) {
+		from = len(errorLine)
+	}
+	to := len(errorLine)
+	if end.Line == start.Line && end.Column-1 < to {
+		to = end.Column - 1
+	}
+	underline := width(errorLine[from:to])
+	if underline < 1 {
+		underline = 1
+	}
 
-	fmt.Println(strings.Replace(errorLine, "\t", "    ", -1))
-	fmt.Println(strings.Repeat(" ", pos.Column-1) + strings.Repeat("   ", numTabs) + "^")
+	fmt.Fprintln(w, strings.Replace(string(errorLine), "\t", "    ", -1))
+	fmt.Fprintln(w, strings.Repeat(" ", width(errorLine[:from]))+strings.Repeat("^", underline))
 
 	if divider != "" {
-		fmt.Println(divider)
+		fmt.Fprintln(w, divider)
+	}
+}
+
+// width returns the width of runes when shown with tabs as four spaces.
+func width(runes []rune) int {
+	n := 0
+	for _, r := range runes {
+		if r == '\t' {
+			n += 4
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// tokenEnd returns the position just after the token at pos in source, or
+// pos if no token starts there.
+func tokenEnd(source []byte, pos lexer.Position) lexer.Position {
+	if pos.Offset < 0 || pos.Offset >= len(source) {
+		return pos
+	}
+	l := lexer.NewLexer(source[pos.Offset:])
+	start, tok, _ := l.Next()
+	if tok == lexer.EOF || start.Offset != 0 {
+		return pos
+	}
+	end := l.End()
+	column := end.Column
+	if end.Line == 1 {
+		column += pos.Column - 1
 	}
+	return lexer.Position{Line: pos.Line + end.Line - 1, Column: column, Offset: pos.Offset + end.Offset}
 }
@@ -0,0 +1,458 @@
+// DaVinci Script
+
+package debug
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The subset of the Debug Adapter Protocol that the adapter uses. See
+// https://microsoft.github.io/debug-adapter-protocol/specification
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type launchArguments struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+}
+
+type setBreakpointsArguments struct {
+	Source      dapSource `json:"source"`
+	Breakpoints []struct {
+		Line      int    `json:"line"`
+		Condition string `json:"condition"`
+	} `json:"breakpoints"`
+}
+
+type breakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type stackFrame struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Source dapSource `json:"source"`
+	Line   int       `json:"line"`
+	Column int       `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// threadID is the ID of the only thread reported: the interpreter stops all
+// goroutines while stopped.
+const threadID = 1
+
+// adapter holds the state of one debug session.
+type adapter struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	mu  sync.Mutex // guards out and seq, as events come from the program
+	seq int
+
+	source  dapSource
+	prog    *parser.Program
+	config  interpreter.Config
+	session *Session
+	started bool
+	paused  bool   // guarded by mu
+	resumed func() // resumes the program after the response is sent
+}
+
+// ServeDAP runs a debug adapter that reads Debug Adapter Protocol requests
+// from in and writes responses and events to out, until the client
+// disconnects or in is closed. The program to debug is given by the launch
+// request's "program" and "args" arguments, and its output is sent to the
+// client as output events.
+func ServeDAP(in io.Reader, out io.Writer) error {
+	a := &adapter{in: bufio.NewReader(in), out: out}
+	for {
+		body, err := a.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req dapRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("invalid message: %s", err)
+		}
+		result, err := a.handle(req)
+		if err != nil {
+			a.write(dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+		} else {
+			a.write(dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: result})
+		}
+		if a.resumed != nil {
+			a.resumed()
+			a.resumed = nil
+		}
+		switch req.Command {
+		case "initialize":
+			a.event("initialized", nil)
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// read reads the body of the next message.
+func (a *adapter) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := a.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(a.in, body)
+	return body, err
+}
+
+// write sends a response or event, filling in its sequence number.
+func (a *adapter) write(message interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	switch m := message.(type) {
+	case dapResponse:
+		m.Seq = a.seq
+		message = m
+	case dapEvent:
+		m.Seq = a.seq
+		message = m
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(a.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (a *adapter) event(name string, body interface{}) {
+	a.write(dapEvent{Type: "event", Event: name, Body: body})
+}
+
+// handle handles a request and returns the body of its response.
+func (a *adapter) handle(req dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+		}, nil
+	case "launch":
+		var args launchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		return nil, a.launch(args)
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if a.session == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+		return a.setBreakpoints(args), nil
+	case "configurationDone":
+		if a.session == nil {
+			return nil, fmt.Errorf("no program launched")
+		}
+		if !a.started {
+			a.started = true
+			go a.run()
+		}
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "main"}},
+		}, nil
+	case "continue":
+		return map[string]bool{"allThreadsContinued": true}, a.resume(a.session.Continue)
+	case "next":
+		return nil, a.resume(a.session.StepOver)
+	case "stepIn":
+		return nil, a.resume(a.session.StepIn)
+	case "stepOut":
+		return nil, a.resume(a.session.StepOut)
+	case "pause":
+		if a.session != nil {
+			a.session.Pause()
+		}
+		return nil, nil
+	case "stackTrace", "scopes", "variables", "evaluate":
+		return a.inspect(req)
+	case "disconnect":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// launch loads the program to debug. It starts running when the client
+// sends configurationDone, after setting breakpoints.
+func (a *adapter) launch(args launchArguments) error {
+	if args.Program == "" {
+		return fmt.Errorf(`launch requires a "program" argument`)
+	}
+	input, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %s", args.Program, err)
+	}
+	path, err := filepath.Abs(args.Program)
+	if err != nil {
+		path = args.Program
+	}
+	prog, err := parser.ParseFile(path, parser.StripTags(input))
+	if err != nil {
+		return err
+	}
+	a.source = dapSource{filepath.Base(path), path}
+	a.prog = prog
+	a.session = NewSession(args.StopOnEntry, a.stopped)
+	a.config = interpreter.Config{
+		Args:     args.Args,
+		Stdout:   outputWriter{a, "stdout"},
+		Exit:     a.exit,
+		Debugger: a.session.Hook,
+	}
+	return nil
+}
+
+// run runs the program, on its own goroutine.
+func (a *adapter) run() {
+	_, err := interpreter.Execute(a.prog, &a.config)
+	if err != nil {
+		a.output("stderr", err.Error()+"\n")
+		a.finish(1)
+		return
+	}
+	a.finish(0)
+}
+
+// exit is the program's exit() builtin. It stops the program's goroutine
+// instead of the adapter's process.
+func (a *adapter) exit(code int) {
+	a.finish(code)
+	runtime.Goexit()
+}
+
+func (a *adapter) finish(code int) {
+	a.event("exited", map[string]int{"exitCode": code})
+	a.event("terminated", nil)
+}
+
+// stopped is called on the program's goroutine when it stops.
+func (a *adapter) stopped(reason string, state *interpreter.DebugState) {
+	a.mu.Lock()
+	a.paused = true
+	a.mu.Unlock()
+	a.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+}
+
+// resume arranges for the stopped program to be resumed with one of the
+// session's resume methods once the response has been sent, so the client
+// never sees the next stopped event first.
+func (a *adapter) resume(f func()) error {
+	if !a.isStopped() {
+		return fmt.Errorf("program isn't stopped")
+	}
+	a.mu.Lock()
+	a.paused = false
+	a.mu.Unlock()
+	a.resumed = f
+	return nil
+}
+
+func (a *adapter) isStopped() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
+
+func (a *adapter) setBreakpoints(args setBreakpointsArguments) interface{} {
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		path = args.Source.Path
+	}
+	breakpoints := []breakpoint{}
+	if path != a.source.Path {
+		for _, b := range args.Breakpoints {
+			breakpoints = append(breakpoints, breakpoint{false, b.Line, "not the launched program"})
+		}
+		return map[string]interface{}{"breakpoints": breakpoints}
+	}
+	a.session.ClearBreakpoints()
+	for _, b := range args.Breakpoints {
+		if b.Condition != "" {
+			if _, err := parser.ParseExpression([]byte(b.Condition)); err != nil {
+				breakpoints = append(breakpoints, breakpoint{false, b.Line, err.Error()})
+				continue
+			}
+		}
+		a.session.SetBreakpoint(path, b.Line, b.Condition)
+		breakpoints = append(breakpoints, breakpoint{true, b.Line, ""})
+	}
+	return map[string]interface{}{"breakpoints": breakpoints}
+}
+
+// Variable references: frame n's locals are 2n+1 and its globals 2n+2.
+func localsReference(frame int) int  { return 2*frame + 1 }
+func globalsReference(frame int) int { return 2*frame + 2 }
+
+// inspect handles the requests which look at the stopped program.
+func (a *adapter) inspect(req dapRequest) (interface{}, error) {
+	if !a.isStopped() {
+		return nil, fmt.Errorf("program isn't stopped")
+	}
+	var args struct {
+		FrameID            int    `json:"frameId"`
+		VariablesReference int    `json:"variablesReference"`
+		Expression         string `json:"expression"`
+	}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+	}
+	var result interface{}
+	var err error
+	a.session.Do(func(state *interpreter.DebugState) {
+		switch req.Command {
+		case "stackTrace":
+			frames := []stackFrame{}
+			for i, f := range state.Stack() {
+				frames = append(frames, stackFrame{i, f.Function, a.source, f.Position.Line, f.Position.Column})
+			}
+			result = map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+		case "scopes":
+			scopes := []scope{}
+			for _, s := range state.Scopes(args.FrameID) {
+				ref := localsReference(args.FrameID)
+				if s.Name == "Globals" {
+					ref = globalsReference(args.FrameID)
+				}
+				scopes = append(scopes, scope{s.Name, ref, false})
+			}
+			result = map[string]interface{}{"scopes": scopes}
+		case "variables":
+			frame := (args.VariablesReference - 1) / 2
+			scopes := state.Scopes(frame)
+			vars := scopes[len(scopes)-1].Vars
+			if args.VariablesReference == localsReference(frame) && len(scopes) > 1 {
+				vars = scopes[0].Vars
+			}
+			result = map[string]interface{}{"variables": variables(vars)}
+		case "evaluate":
+			var v interpreter.Value
+			v, err = state.Evaluate(args.Expression)
+			if err == nil {
+				result = map[string]interface{}{
+					"result":             interpreter.ToString(v, true),
+					"variablesReference": 0,
+				}
+			}
+		}
+	})
+	return result, err
+}
+
+func variables(vars map[string]interpreter.Value) []variable {
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := []variable{}
+	for _, name := range names {
+		result = append(result, variable{"$" + name, interpreter.ToString(vars[name], true), 0})
+	}
+	return result
+}
+
+func (a *adapter) output(category, text string) {
+	a.event("output", map[string]string{"category": category, "output": text})
+}
+
+// outputWriter sends the program's output to the client.
+type outputWriter struct {
+	a        *adapter
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.a.output(w.category, string(p))
+	return len(p), nil
+}
@@ -0,0 +1,218 @@
+// DaVinci Script
+
+// Package debug implements a step debugger for Davi programs, with line and
+// conditional breakpoints and stepping in, over and out of calls. It can be
+// driven from a terminal or by an editor via the Debug Adapter Protocol.
+package debug
+
+import (
+	"github.com/DavinciScript/Davi/interpreter"
+	"sync"
+)
+
+// Reasons the program stopped, passed to the Session's stop function.
+const (
+	ReasonEntry      = "entry"
+	ReasonBreakpoint = "breakpoint"
+	ReasonStep       = "step"
+	ReasonPause      = "pause"
+)
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// command is sent to the stopped interpreter's goroutine. If resume is true
+// the program continues after f is called.
+type command struct {
+	f      func(state *interpreter.DebugState)
+	resume bool
+}
+
+// Location is a line of a source file, as named in the positions of the
+// program (see parser.ParseFile).
+type Location struct {
+	File string
+	Line int
+}
+
+// Session decides when a program stops and passes commands to it while it's
+// stopped. Set the interpreter's Config.Debugger to Session.Hook; the other
+// methods are called from another goroutine.
+type Session struct {
+	mu          sync.Mutex
+	breakpoints map[Location]string // condition, "" for always
+	entry       bool
+	pause       bool
+	step        stepMode
+	stepDepth   int
+	lastLine    int
+	lastDepth   int
+
+	stopped  func(reason string, state *interpreter.DebugState)
+	commands chan command
+}
+
+// NewSession returns a Session which calls stopped (on the interpreter's
+// goroutine) each time the program stops, then waits for a command. If
+// stopOnEntry is true the program stops before its first statement.
+func NewSession(stopOnEntry bool, stopped func(reason string, state *interpreter.DebugState)) *Session {
+	return &Session{
+		breakpoints: map[Location]string{},
+		entry:       stopOnEntry,
+		stopped:     stopped,
+		commands:    make(chan command),
+	}
+}
+
+// Hook is the interpreter's Config.Debugger. It stops the program if a
+// breakpoint or step says so, and runs commands until told to resume.
+func (s *Session) Hook(state *interpreter.DebugState) {
+	reason := s.check(state)
+	if reason == "" {
+		return
+	}
+	s.stopped(reason, state)
+	for cmd := range s.commands {
+		if cmd.f != nil {
+			cmd.f(state)
+		}
+		if cmd.resume {
+			return
+		}
+	}
+}
+
+// check returns the reason to stop before the statement at state, or "" to
+// keep running.
+func (s *Session) check(state *interpreter.DebugState) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	line := state.Position.Line
+	depth := state.Depth()
+	// Only stop once for several statements on the same line
+	newLine := line != s.lastLine || depth != s.lastDepth
+	s.lastLine, s.lastDepth = line, depth
+
+	reason := ""
+	switch {
+	case s.entry:
+		reason = ReasonEntry
+	case s.pause:
+		reason = ReasonPause
+	case s.step == stepIn && newLine,
+		s.step == stepOver && newLine && depth <= s.stepDepth,
+		s.step == stepOut && depth < s.stepDepth:
+		reason = ReasonStep
+	default:
+		condition, ok := s.breakpoints[Location{state.Position.File, line}]
+		if ok && newLine && conditionTrue(state, condition) {
+			reason = ReasonBreakpoint
+		}
+	}
+	if reason != "" {
+		s.entry = false
+		s.pause = false
+		s.step = stepNone
+	}
+	return reason
+}
+
+// conditionTrue reports whether a breakpoint condition holds. A condition
+// that fails to evaluate counts as true, so the user sees the problem.
+func conditionTrue(state *interpreter.DebugState, condition string) bool {
+	if condition == "" {
+		return true
+	}
+	v, err := state.Evaluate(condition)
+	return err != nil || v == true
+}
+
+// SetBreakpoint sets a breakpoint on a line of a file, replacing any
+// existing one. If condition isn't empty, it's an expression which must be
+// true for the program to stop.
+func (s *Session) SetBreakpoint(file string, line int, condition string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints[Location{file, line}] = condition
+}
+
+// ClearBreakpoint removes the breakpoint on a line of a file, if any.
+func (s *Session) ClearBreakpoint(file string, line int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.breakpoints, Location{file, line})
+}
+
+// ClearBreakpoints removes all breakpoints.
+func (s *Session) ClearBreakpoints() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints = map[Location]string{}
+}
+
+// Breakpoints returns a copy of the breakpoints, a map of location to
+// condition.
+func (s *Session) Breakpoints() map[Location]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	breakpoints := make(map[Location]string, len(s.breakpoints))
+	for location, condition := range s.breakpoints {
+		breakpoints[location] = condition
+	}
+	return breakpoints
+}
+
+// Pause stops a running program before its next statement.
+func (s *Session) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pause = true
+}
+
+// Do calls f with the state of the stopped program, on the interpreter's
+// goroutine, and waits for it to return. It must only be called while the
+// program is stopped.
+func (s *Session) Do(f func(state *interpreter.DebugState)) {
+	done := make(chan struct{})
+	s.commands <- command{func(state *interpreter.DebugState) {
+		f(state)
+		close(done)
+	}, false}
+	<-done
+}
+
+// Continue resumes a stopped program until the next breakpoint.
+func (s *Session) Continue() {
+	s.resume(stepNone)
+}
+
+// StepIn resumes a stopped program until the next line, entering calls.
+func (s *Session) StepIn() {
+	s.resume(stepIn)
+}
+
+// StepOver resumes a stopped program until the next line in the current
+// function or a caller.
+func (s *Session) StepOver() {
+	s.resume(stepOver)
+}
+
+// StepOut resumes a stopped program until the current function returns.
+func (s *Session) StepOut() {
+	s.resume(stepOut)
+}
+
+func (s *Session) resume(mode stepMode) {
+	s.commands <- command{func(state *interpreter.DebugState) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.step = mode
+		s.stepDepth = state.Depth()
+	}, true}
+}
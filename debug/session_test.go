@@ -0,0 +1,64 @@
+// DaVinci Script
+
+package debug
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"testing"
+)
+
+// TestBreakpointFiles checks that a breakpoint only stops the program in
+// the file it was set in.
+func TestBreakpointFiles(t *testing.T) {
+	stops := make(chan string)
+	session := NewSession(false, func(reason string, state *interpreter.DebugState) {
+		stops <- fmt.Sprintf("%s at %s", reason, state.Position)
+	})
+	session.SetBreakpoint("b.davi", 2, "")
+	session.SetBreakpoint("c.davi", 1, "")
+	session.ClearBreakpoint("c.davi", 1)
+	expected := map[Location]string{{"b.davi", 2}: ""}
+	if breakpoints := session.Breakpoints(); fmt.Sprint(breakpoints) != fmt.Sprint(expected) {
+		t.Fatalf("expected breakpoints %v, got %v", expected, breakpoints)
+	}
+
+	interp := interpreter.New(&interpreter.Config{Stdout: io.Discard, Debugger: session.Hook})
+	done := make(chan error)
+	go func() {
+		s := interp.NewSession()
+		for _, file := range []string{"a.davi", "b.davi", "c.davi"} {
+			prog, err := parser.ParseFile(file, []byte("$x = 1\n$y = 2\n$z = 3"))
+			if err != nil {
+				done <- err
+				return
+			}
+			if err := s.Execute(prog); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	expectedStops := []string{"breakpoint at b.davi:2:4"}
+	for i := 0; ; i++ {
+		select {
+		case stop := <-stops:
+			if i >= len(expectedStops) || stop != expectedStops[i] {
+				t.Errorf("unexpected stop %d: %s", i, stop)
+			}
+			session.Continue()
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != len(expectedStops) {
+				t.Fatalf("expected %d stops, got %d", len(expectedStops), i)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// DaVinci Script
+
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const terminalHelp = `Commands:
+  break LINE [if EXPR]   b  set a breakpoint, stopping only if EXPR is true
+  delete [LINE]          d  delete the breakpoint on LINE, or all breakpoints
+  breakpoints               list breakpoints
+  continue               c  run until the next breakpoint
+  step                   s  run to the next line, stepping into calls
+  next                   n  run to the next line, stepping over calls
+  out                    o  run until the current function returns
+  print EXPR             p  evaluate and print an expression
+  locals [FRAME]            show the variables of a call stack frame
+  globals                   show the global variables
+  stack                  bt show the call stack
+  list                   l  show the source around the current line
+  quit                   q  stop debugging
+An empty line repeats the last command.
+`
+
+// stop describes where the program stopped.
+type stop struct {
+	reason   string
+	function string
+	file     string
+	line     int
+}
+
+type terminal struct {
+	session *Session
+	lines   []string
+	in      *bufio.Scanner
+	out     io.Writer
+	file    string // file and line the program is stopped at
+	line    int
+	last    string
+}
+
+// Run runs prog (parsed from source) under a debugger controlled by
+// commands read from in, writing debugger output to out. The program stops
+// before its first statement so breakpoints can be set. Run returns the
+// program's error, if any, or nil when it finishes or the user quits; after
+// quitting the program is left stopped and the caller should exit.
+func Run(prog *parser.Program, source []byte, config *interpreter.Config, in io.Reader, out io.Writer) error {
+	stops := make(chan stop)
+	t := &terminal{
+		lines: strings.Split(string(source), "\n"),
+		in:    bufio.NewScanner(in),
+		out:   out,
+	}
+	t.session = NewSession(true, func(reason string, state *interpreter.DebugState) {
+		stops <- stop{reason, state.Stack()[0].Function, state.Position.File, state.Position.Line}
+	})
+	c := *config
+	c.Debugger = t.session.Hook
+	done := make(chan error)
+	go func() {
+		_, err := interpreter.Execute(prog, &c)
+		done <- err
+	}()
+
+	fmt.Fprintln(out, `Type "help" for a list of commands.`)
+	for {
+		select {
+		case s := <-stops:
+			t.file, t.line = s.file, s.line
+			fmt.Fprintf(out, "Stopped at line %d in %s (%s)\n", s.line, s.function, s.reason)
+			t.list(s.line, s.line)
+			if !t.commands() {
+				return nil
+			}
+		case err := <-done:
+			if err == nil {
+				fmt.Fprintln(out, "Program finished.")
+			}
+			return err
+		}
+	}
+}
+
+// commands reads and runs commands until one resumes the program. It
+// returns false if the user quits.
+func (t *terminal) commands() bool {
+	for {
+		fmt.Fprint(t.out, "(davi) ")
+		if !t.in.Scan() {
+			fmt.Fprintln(t.out)
+			return false
+		}
+		line := strings.TrimSpace(t.in.Text())
+		if line == "" {
+			line = t.last
+		}
+		t.last = line
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch name {
+		case "":
+		case "break", "b":
+			t.setBreakpoint(arg)
+		case "delete", "d":
+			if arg == "" {
+				t.session.ClearBreakpoints()
+				fmt.Fprintln(t.out, "Deleted all breakpoints.")
+			} else if n, err := strconv.Atoi(arg); err == nil {
+				t.session.ClearBreakpoint(t.file, n)
+			} else {
+				fmt.Fprintf(t.out, "Invalid line number %q.\n", arg)
+			}
+		case "breakpoints":
+			t.breakpoints()
+		case "continue", "c":
+			t.session.Continue()
+			return true
+		case "step", "s":
+			t.session.StepIn()
+			return true
+		case "next", "n":
+			t.session.StepOver()
+			return true
+		case "out", "o":
+			t.session.StepOut()
+			return true
+		case "print", "p":
+			t.session.Do(func(state *interpreter.DebugState) {
+				v, err := state.Evaluate(arg)
+				if err != nil {
+					fmt.Fprintln(t.out, err)
+					return
+				}
+				fmt.Fprintln(t.out, interpreter.ToString(v, true))
+			})
+		case "locals", "globals":
+			t.scope(name, arg)
+		case "stack", "bt":
+			t.session.Do(func(state *interpreter.DebugState) {
+				for i, frame := range state.Stack() {
+					fmt.Fprintf(t.out, "#%d  %s at line %d\n", i, frame.Function, frame.Position.Line)
+				}
+			})
+		case "list", "l":
+			t.list(t.line-5, t.line+5)
+		case "quit", "q":
+			return false
+		case "help", "h":
+			fmt.Fprint(t.out, terminalHelp)
+		default:
+			fmt.Fprintf(t.out, "Unknown command %q. Type \"help\" for a list of commands.\n", name)
+		}
+	}
+}
+
+func (t *terminal) setBreakpoint(arg string) {
+	lineArg, condition, _ := strings.Cut(arg, " ")
+	line, err := strconv.Atoi(lineArg)
+	if err != nil || line < 1 || line > len(t.lines) {
+		fmt.Fprintf(t.out, "Invalid line number %q.\n", lineArg)
+		return
+	}
+	condition = strings.TrimSpace(condition)
+	if condition != "" {
+		c, ok := strings.CutPrefix(condition, "if ")
+		if !ok {
+			fmt.Fprintln(t.out, `Usage: break LINE [if EXPR]`)
+			return
+		}
+		condition = strings.TrimSpace(c)
+		if _, err := parser.ParseExpression([]byte(condition)); err != nil {
+			fmt.Fprintf(t.out, "Invalid condition: %s\n", err)
+			return
+		}
+	}
+	t.session.SetBreakpoint(t.file, line, condition)
+	fmt.Fprintf(t.out, "Breakpoint set at line %d.\n", line)
+}
+
+func (t *terminal) breakpoints() {
+	breakpoints := t.session.Breakpoints()
+	if len(breakpoints) == 0 {
+		fmt.Fprintln(t.out, "No breakpoints.")
+		return
+	}
+	locations := []Location{}
+	for location := range breakpoints {
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		return a.File < b.File || (a.File == b.File && a.Line < b.Line)
+	})
+	for _, location := range locations {
+		where := fmt.Sprintf("line %d", location.Line)
+		if location.File != "" {
+			where = fmt.Sprintf("%s:%d", location.File, location.Line)
+		}
+		if condition := breakpoints[location]; condition != "" {
+			fmt.Fprintf(t.out, "%s if %s\n", where, condition)
+		} else {
+			fmt.Fprintln(t.out, where)
+		}
+	}
+}
+
+// scope prints the locals of a frame (arg is the frame number, default 0)
+// or the globals.
+func (t *terminal) scope(name, arg string) {
+	frame := 0
+	if arg != "" {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			fmt.Fprintf(t.out, "Invalid frame number %q.\n", arg)
+			return
+		}
+		frame = n
+	}
+	t.session.Do(func(state *interpreter.DebugState) {
+		scopes := state.Scopes(frame)
+		vars := scopes[len(scopes)-1].Vars
+		if name == "locals" {
+			if len(scopes) == 1 {
+				fmt.Fprintln(t.out, "No locals at the top level; see globals.")
+				return
+			}
+			vars = scopes[0].Vars
+		}
+		fmt.Fprint(t.out, formatVars(vars))
+	})
+}
+
+// formatVars returns "name = value" lines for vars, sorted by name.
+func formatVars(vars map[string]interpreter.Value) string {
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "$%s = %s\n", name, interpreter.ToString(vars[name], true))
+	}
+	return b.String()
+}
+
+// list prints source lines from first to last, marking the current line.
+func (t *terminal) list(first, last int) {
+	if first < 1 {
+		first = 1
+	}
+	if last > len(t.lines) {
+		last = len(t.lines)
+	}
+	for n := first; n <= last; n++ {
+		marker := "  "
+		if n == t.line {
+			marker = "->"
+		}
+		fmt.Fprintf(t.out, "%4d %s %s\n", n, marker, t.lines[n-1])
+	}
+}
@@ -0,0 +1,128 @@
+// DaVinci Script
+
+package debug
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+const testSource = `function add($a, $b) {
+    $sum = $a + $b
+    return $sum
+}
+$x = 1
+$y = add($x, 2)
+echo($y)
+$z = add($y, 3)
+echo($z)`
+
+func runTerminal(t *testing.T, commands string) string {
+	prog, err := parser.ParseFile("main.davi", []byte(testSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	config := &interpreter.Config{Stdout: &out}
+	err = Run(prog, []byte(testSource), config, strings.NewReader(commands), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestTerminal(t *testing.T) {
+	tests := []struct {
+		commands string
+		output   string
+	}{
+		{"c\n", `Type "help" for a list of commands.
+Stopped at line 1 in main (entry)
+   1 -> function add($a, $b) {
+(davi) 3
+6
+Program finished.
+`},
+		// Breakpoints, with conditions
+		{"break 2\nbreak 8 if $y > 100\nbreak 7 if $y ==\nbreak 99\nbreakpoints\nc\nc\ndelete 2\nbreakpoints\nc\n", `Type "help" for a list of commands.
+Stopped at line 1 in main (entry)
+   1 -> function add($a, $b) {
+(davi) Breakpoint set at line 2.
+(davi) Breakpoint set at line 8.
+(davi) Invalid condition: parse error at 1:6: expected expression, not EOF
+(davi) Invalid line number "99".
+(davi) main.davi:2
+main.davi:8 if $y > 100
+(davi) Stopped at line 2 in add (breakpoint)
+   2 ->     $sum = $a + $b
+(davi) 3
+Stopped at line 2 in add (breakpoint)
+   2 ->     $sum = $a + $b
+(davi) (davi) main.davi:8 if $y > 100
+(davi) 6
+Program finished.
+`},
+		// Stepping, and looking at the stopped program
+		{"n\nn\ns\nlocals\np $a + $b\np $nope\nstack\nlocals 1\nlocals x\nn\no\nglobals\nq\n", `Type "help" for a list of commands.
+Stopped at line 1 in main (entry)
+   1 -> function add($a, $b) {
+(davi) Stopped at line 5 in main (step)
+   5 -> $x = 1
+(davi) Stopped at line 6 in main (step)
+   6 -> $y = add($x, 2)
+(davi) Stopped at line 2 in add (step)
+   2 ->     $sum = $a + $b
+(davi) $a = 1
+$b = 2
+(davi) 3
+(davi) name error at 1:2: name "nope" not found
+(davi) #0  add at line 2
+#1  main at line 6
+(davi) No locals at the top level; see globals.
+(davi) Invalid frame number "x".
+(davi) Stopped at line 3 in add (step)
+   3 ->     return $sum
+(davi) Stopped at line 7 in main (step)
+   7 -> echo($y)
+(davi) $add = <function add>
+$x = 1
+$y = 3
+(davi) `},
+		// next steps over calls, and an empty line repeats the last command
+		{"n\nn\nn\n\n\nq\n", `Type "help" for a list of commands.
+Stopped at line 1 in main (entry)
+   1 -> function add($a, $b) {
+(davi) Stopped at line 5 in main (step)
+   5 -> $x = 1
+(davi) Stopped at line 6 in main (step)
+   6 -> $y = add($x, 2)
+(davi) Stopped at line 7 in main (step)
+   7 -> echo($y)
+(davi) 3
+Stopped at line 8 in main (step)
+   8 -> $z = add($y, 3)
+(davi) Stopped at line 9 in main (step)
+   9 -> echo($z)
+(davi) `},
+		{"bogus\nlist\nq\n", `Type "help" for a list of commands.
+Stopped at line 1 in main (entry)
+   1 -> function add($a, $b) {
+(davi) Unknown command "bogus". Type "help" for a list of commands.
+(davi)    1 -> function add($a, $b) {
+   2        $sum = $a + $b
+   3        return $sum
+   4    }
+   5    $x = 1
+   6    $y = add($x, 2)
+(davi) `},
+	}
+	for _, test := range tests {
+		output := runTerminal(t, test.commands)
+		if output != test.output {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.commands, test.output, output)
+		}
+	}
+}
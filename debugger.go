@@ -0,0 +1,87 @@
+// DaVinci Script
+
+package main
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/debug"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const debugUsage = `Usage:
+  davi debug <file> [--] [args...]   debug a script from the terminal
+  davi debug --dap                   start a Debug Adapter Protocol server on
+                                     stdin/stdout for editors
+
+The script stops before its first statement; type "help" at the (davi)
+prompt for the debugger's commands.
+`
+
+// runDebug implements the "davi debug" command.
+func runDebug(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, debugUsage)
+		return exitUsageError
+	}
+	switch args[0] {
+	case "-h", "--help":
+		fmt.Print(debugUsage)
+		return exitOK
+	case "--dap":
+		err := debug.ServeDAP(os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitRuntimeError
+		}
+		return exitOK
+	case "--":
+		args = args[1:]
+		if len(args) == 0 {
+			fmt.Fprint(os.Stderr, debugUsage)
+			return exitUsageError
+		}
+	}
+	if strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(os.Stderr, "davi debug: unknown option %s\n\n%s", args[0], debugUsage)
+		return exitUsageError
+	}
+
+	filename := args[0]
+	args = args[1:]
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	input, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file %s. Please check the file path and try again.\n", filename)
+		return exitUsageError
+	}
+	// Keep the script's line numbers so breakpoints match the file
+	input = parser.StripTags(input)
+
+	prog, err := parser.ParseFile(filename, input)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%s", err)
+		if e, ok := err.(parser.Error); ok {
+			showErrorSource(os.Stderr, input, e.Position, e.End, len(errorMessage))
+		}
+		fmt.Fprintln(os.Stderr, errorMessage)
+		return exitParseError
+	}
+
+	err = debug.Run(prog, input, &interpreter.Config{Args: args}, os.Stdin, os.Stdout)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%s", err)
+		if e, ok := err.(interpreter.Error); ok {
+			start, end := errorSpan(e)
+			showErrorSource(os.Stderr, input, start, end, len(errorMessage))
+		}
+		fmt.Fprintln(os.Stderr, errorMessage)
+		return exitRuntimeError
+	}
+	return exitOK
+}
@@ -18,6 +18,7 @@ export default defineUserConfig({
           '/guide/tutorials.md',
           '/guide/functions.md',
           '/guide/oop.md',
+          '/guide/concurrency.md',
         ],
         sidebar: [
           {
@@ -27,6 +28,7 @@ export default defineUserConfig({
               '/guide/tutorials.md',
               '/guide/functions.md',
               '/guide/oop.md',
+              '/guide/concurrency.md',
             ]
           }
         ]
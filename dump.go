@@ -0,0 +1,133 @@
+// DaVinci Script
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DavinciScript/Davi/dump"
+	"github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const tokensUsage = `Usage: davi tokens [--comments] [file]
+
+Prints the tokens of a Davi script as a JSON array, ending with an EOF
+token, or an ILLEGAL token if the script has a syntax error. With no file,
+or a file of -, the script is read from standard input. Each token is an
+object with these fields:
+
+  type     the token as written in Davi ("=", "while", "->"), or one of
+           "int", "name", "str", "comment", "EOF" and "ILLEGAL"
+  value    the number, name, string value or comment text of int, name,
+           str and comment tokens, the error message of an ILLEGAL token,
+           and "" otherwise
+  line     line the token starts on, from 1
+  column   column the token starts at, from 1
+  offset   byte offset the token starts at, from 0
+  end      the line, column and offset just after the token
+
+Options:
+  --comments   include comments as comment tokens
+`
+
+const astUsage = `Usage: davi ast [file]
+
+Prints the syntax tree of a Davi script as JSON. With no file, or a file
+of -, the script is read from standard input.
+
+Each node is an object whose "type" is the node type ("Assign", "Binary",
+"Variable" and so on), followed by the "line", "column" and "offset" where
+errors in it are reported, the "start" and "end" of the source it was
+parsed from (each with a line, column and offset) and then its fields.
+Lists of statements or expressions are arrays and missing children are
+null. The root is a Program node with only a "statements" field. See the
+documentation of the dump package for the fields of each node type.
+`
+
+// runTokens implements the "davi tokens" command.
+func runTokens(args []string) int {
+	if isHelp(args) {
+		fmt.Print(tokensUsage)
+		return exitOK
+	}
+	comments := false
+	if len(args) > 0 && args[0] == "--comments" {
+		comments = true
+		args = args[1:]
+	}
+	input, file, code := readDumpInput(tokensUsage, args)
+	if code != exitOK {
+		return code
+	}
+	tokens := dump.Tokens(input, comments)
+	printJSON(tokens)
+	if last := tokens[len(tokens)-1]; last.Type == lexer.ILLEGAL.String() {
+		pos := lexer.Position{Line: last.Line, Column: last.Column, File: file}
+		fmt.Fprintf(os.Stderr, "parse error at %s: %s\n", pos, last.Value)
+		return exitParseError
+	}
+	return exitOK
+}
+
+// runAST implements the "davi ast" command.
+func runAST(args []string) int {
+	if isHelp(args) {
+		fmt.Print(astUsage)
+		return exitOK
+	}
+	input, file, code := readDumpInput(astUsage, args)
+	if code != exitOK {
+		return code
+	}
+	prog, err := parser.ParseFile(file, input)
+	if err != nil {
+		errorMessage := fmt.Sprintf("%s", err)
+		if e, ok := err.(parser.Error); ok {
+			showErrorSource(os.Stderr, input, e.Position, e.End, len(errorMessage))
+		}
+		fmt.Fprintln(os.Stderr, errorMessage)
+		return exitParseError
+	}
+	printJSON(dump.Program(prog))
+	return exitOK
+}
+
+// readDumpInput reads the script for a dump command from the file in
+// args, or from standard input, with its tags replaced by spaces so
+// that positions are the same as in the file. It returns the script and
+// its file name, which is empty for standard input.
+func readDumpInput(usage string, args []string) ([]byte, string, int) {
+	if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-") && args[0] != "-") {
+		fmt.Fprint(os.Stderr, usage)
+		return nil, "", exitUsageError
+	}
+	if len(args) == 0 || args[0] == "-" {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+			return nil, "", exitUsageError
+		}
+		return parser.StripTags(input), "", exitOK
+	}
+	input, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file %s. Please check the file path and try again.\n", args[0])
+		return nil, "", exitUsageError
+	}
+	return parser.StripTags(input), args[0], exitOK
+}
+
+// isHelp reports whether args asks for a command's help.
+func isHelp(args []string) bool {
+	return len(args) == 1 && (args[0] == "-h" || args[0] == "--help")
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(out))
+}
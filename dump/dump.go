@@ -0,0 +1,327 @@
+// DaVinci Script
+
+// Package dump converts Davi tokens and syntax trees to JSON for external
+// tools. The format is stable: fields may be added in later versions, but
+// existing fields keep their names and meaning.
+//
+// A token is an object with these fields:
+//
+//	type     the token as written in Davi ("=", "while", "->"), or one
+//	         of "int", "name", "str", "comment", "EOF" and "ILLEGAL"
+//	value    the number, name, string value or comment text of int, name,
+//	         str and comment tokens, the error message of an ILLEGAL
+//	         token, and "" otherwise
+//	line     line the token starts on, from 1
+//	column   column the token starts at, from 1
+//	offset   byte offset the token starts at, from 0
+//	end      position just after the token (see below)
+//
+// A syntax tree node is an object whose "type" is the name of the node
+// type in package parser ("Assign", "Binary", "Variable" and so on),
+// followed by its "line", "column" and "offset", its "start" and "end",
+// and then its fields, named as in package parser but starting with a
+// lower case letter. Child nodes are objects, lists of statements or
+// expressions are arrays, and missing children (such as the target of a
+// select case that doesn't assign the value it receives) are null. The
+// fields are:
+//
+//	Program              statements
+//	Assign               target, value
+//	OuterAssign          name, value
+//	If                   condition, body, else
+//	While                condition, body
+//	For                  name, iterable, body, slot
+//	Return               result
+//	ExpressionStatement  expression
+//	FunctionDefinition   name, parameters, ellipsis, async, body, slot, locals
+//	Spawn                call
+//	Select               cases, hasDefault, default
+//	SelectCase           target, channel, value, body
+//	ClassDefinition      className, parent, body, slot
+//	Binary               left, operator, right
+//	Unary                operator, operand
+//	Call                 function, arguments, ellipsis
+//	Literal              value (null, a bool, a number or a string)
+//	List                 values
+//	Map                  items (objects with a key and a value)
+//	NewExpression        className, arguments
+//	PropertyAccess       object, property
+//	MethodCall           object, method, arguments
+//	FunctionExpression   parameters, ellipsis, async, body, locals
+//	Await                value
+//	Subscript            container, subscript
+//	Variable             name, slot
+//	SemiTag
+//
+// The line, column and offset of a node are its Position, where errors in
+// it are reported, and start and end are its Span, the range of source it
+// was parsed from, as described on parser.Span. The Program has no
+// position or span. Positions such as start and end are objects with a
+// "line", "column" and "offset". Operators are strings as written in Davi,
+// names and parameters are strings without the $, and a slot is an object
+// with the "local", "depth" and "index" of the variable as described on
+// parser.Slot.
+package dump
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+)
+
+// Token is the JSON form of a token.
+type Token struct {
+	Type   string   `json:"type"`
+	Value  string   `json:"value"`
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Offset int      `json:"offset"`
+	End    Position `json:"end"`
+}
+
+// Position is the JSON form of a lexer.Position.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+func position(pos lexer.Position) Position {
+	return Position{pos.Line, pos.Column, pos.Offset}
+}
+
+// Tokens returns the tokens of src, ending with an EOF token, or with an
+// ILLEGAL token if there's a syntax error. With comments, comments are
+// included as comment tokens.
+func Tokens(src []byte, comments bool) []Token {
+	var l *lexer.Lexer
+	if comments {
+		l = lexer.NewLexerWithComments(src)
+	} else {
+		l = lexer.NewLexer(src)
+	}
+	tokens := []Token{}
+	for {
+		pos, tok, val := l.Next()
+		tokens = append(tokens, Token{tok.String(), val, pos.Line, pos.Column, pos.Offset, position(l.End())})
+		if tok == lexer.EOF || tok == lexer.ILLEGAL {
+			return tokens
+		}
+	}
+}
+
+// Object is a JSON object that keeps its fields in order.
+type Object []Field
+
+// Field is a field of an Object.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.Name)
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Program returns the JSON form of prog.
+func Program(prog *parser.Program) Object {
+	return Object{{"type", "Program"}, {"statements", block(prog.Statements)}}
+}
+
+// spanner is a node with a position and span.
+type spanner interface {
+	Position() lexer.Position
+	Span() parser.Span
+}
+
+// node returns the JSON object for a node of the named type with the
+// given fields.
+func node(typeName string, n spanner, fields ...Field) Object {
+	pos := n.Position()
+	span := n.Span()
+	o := Object{
+		{"type", typeName},
+		{"line", pos.Line},
+		{"column", pos.Column},
+		{"offset", pos.Offset},
+		{"start", position(span.Start)},
+		{"end", position(span.End)},
+	}
+	return append(o, fields...)
+}
+
+func block(b parser.Block) []interface{} {
+	nodes := make([]interface{}, len(b))
+	for i, s := range b {
+		nodes[i] = Node(s)
+	}
+	return nodes
+}
+
+func expressions(exprs []parser.Expression) []interface{} {
+	nodes := make([]interface{}, len(exprs))
+	for i, e := range exprs {
+		nodes[i] = Node(e)
+	}
+	return nodes
+}
+
+func names(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func slot(s parser.Slot) Object {
+	return Object{{"local", s.Local}, {"depth", s.Depth}, {"index", s.Index}}
+}
+
+// Node returns the JSON form of a statement or expression, or nil (which
+// is null in JSON) for a nil node.
+func Node(n interface{}) interface{} {
+	switch n := n.(type) {
+	case nil:
+		return nil
+	case *parser.Assign:
+		return node("Assign", n,
+			Field{"target", Node(n.Target)},
+			Field{"value", Node(n.Value)})
+	case *parser.OuterAssign:
+		return node("OuterAssign", n,
+			Field{"name", n.Name},
+			Field{"value", Node(n.Value)})
+	case *parser.If:
+		return node("If", n,
+			Field{"condition", Node(n.Condition)},
+			Field{"body", block(n.Body)},
+			Field{"else", block(n.Else)})
+	case *parser.While:
+		return node("While", n,
+			Field{"condition", Node(n.Condition)},
+			Field{"body", block(n.Body)})
+	case *parser.For:
+		return node("For", n,
+			Field{"name", n.Name},
+			Field{"iterable", Node(n.Iterable)},
+			Field{"body", block(n.Body)},
+			Field{"slot", slot(n.Slot)})
+	case *parser.Return:
+		return node("Return", n,
+			Field{"result", Node(n.Result)})
+	case *parser.ExpressionStatement:
+		return node("ExpressionStatement", n,
+			Field{"expression", Node(n.Expression)})
+	case *parser.FunctionDefinition:
+		return node("FunctionDefinition", n,
+			Field{"name", n.Name},
+			Field{"parameters", names(n.Parameters)},
+			Field{"ellipsis", n.Ellipsis},
+			Field{"async", n.Async},
+			Field{"body", block(n.Body)},
+			Field{"slot", slot(n.Slot)},
+			Field{"locals", names(n.Locals)})
+	case *parser.Spawn:
+		return node("Spawn", n,
+			Field{"call", Node(n.Call)})
+	case *parser.Select:
+		cases := make([]interface{}, len(n.Cases))
+		for i, c := range n.Cases {
+			cases[i] = node("SelectCase", c,
+				Field{"target", Node(c.Target)},
+				Field{"channel", Node(c.Channel)},
+				Field{"value", Node(c.Value)},
+				Field{"body", block(c.Body)})
+		}
+		return node("Select", n,
+			Field{"cases", cases},
+			Field{"hasDefault", n.HasDefault},
+			Field{"default", block(n.Default)})
+	case *parser.ClassDefinition:
+		return node("ClassDefinition", n,
+			Field{"className", n.ClassName},
+			Field{"parent", n.Parent},
+			Field{"body", block(n.Body)},
+			Field{"slot", slot(n.Slot)})
+	case *parser.Binary:
+		return node("Binary", n,
+			Field{"left", Node(n.Left)},
+			Field{"operator", n.Operator.String()},
+			Field{"right", Node(n.Right)})
+	case *parser.Unary:
+		return node("Unary", n,
+			Field{"operator", n.Operator.String()},
+			Field{"operand", Node(n.Operand)})
+	case *parser.Call:
+		return node("Call", n,
+			Field{"function", Node(n.Function)},
+			Field{"arguments", expressions(n.Arguments)},
+			Field{"ellipsis", n.Ellipsis})
+	case *parser.Literal:
+		return node("Literal", n,
+			Field{"value", n.Value})
+	case *parser.List:
+		return node("List", n,
+			Field{"values", expressions(n.Values)})
+	case *parser.Map:
+		items := make([]interface{}, len(n.Items))
+		for i, item := range n.Items {
+			items[i] = Object{{"key", Node(item.Key)}, {"value", Node(item.Value)}}
+		}
+		return node("Map", n,
+			Field{"items", items})
+	case *parser.NewExpression:
+		return node("NewExpression", n,
+			Field{"className", n.ClassName},
+			Field{"arguments", names(n.Arguments)})
+	case *parser.PropertyAccess:
+		return node("PropertyAccess", n,
+			Field{"object", Node(n.Object)},
+			Field{"property", n.Property})
+	case *parser.MethodCall:
+		return node("MethodCall", n,
+			Field{"object", Node(n.Object)},
+			Field{"method", n.Method},
+			Field{"arguments", expressions(n.Arguments)})
+	case *parser.FunctionExpression:
+		return node("FunctionExpression", n,
+			Field{"parameters", names(n.Parameters)},
+			Field{"ellipsis", n.Ellipsis},
+			Field{"async", n.Async},
+			Field{"body", block(n.Body)},
+			Field{"locals", names(n.Locals)})
+	case *parser.Await:
+		return node("Await", n,
+			Field{"value", Node(n.Value)})
+	case *parser.Subscript:
+		return node("Subscript", n,
+			Field{"container", Node(n.Container)},
+			Field{"subscript", Node(n.Subscript)})
+	case *parser.Variable:
+		return node("Variable", n,
+			Field{"name", n.Name},
+			Field{"slot", slot(n.Slot)})
+	case *parser.SemiTag:
+		return node("SemiTag", n)
+	default:
+		panic("dump: unknown node type")
+	}
+}
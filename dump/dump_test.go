@@ -0,0 +1,57 @@
+// DaVinci Script
+
+package dump
+
+import (
+	"encoding/json"
+	"github.com/DavinciScript/Davi/parser"
+	"testing"
+)
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		source   string
+		comments bool
+		output   string
+	}{
+		{`$x = 1`, false, `[{"type":"$","value":"","line":1,"column":1,"offset":0,"end":{"line":1,"column":2,"offset":1}},{"type":"name","value":"x","line":1,"column":2,"offset":1,"end":{"line":1,"column":3,"offset":2}},{"type":"=","value":"","line":1,"column":4,"offset":3,"end":{"line":1,"column":5,"offset":4}},{"type":"int","value":"1","line":1,"column":6,"offset":5,"end":{"line":1,"column":7,"offset":6}},{"type":"EOF","value":"","line":1,"column":7,"offset":6,"end":{"line":1,"column":7,"offset":6}}]`},
+		{`"é" + 1`, false, `[{"type":"str","value":"é","line":1,"column":1,"offset":0,"end":{"line":1,"column":4,"offset":4}},{"type":"+","value":"","line":1,"column":5,"offset":5,"end":{"line":1,"column":6,"offset":6}},{"type":"int","value":"1","line":1,"column":7,"offset":7,"end":{"line":1,"column":8,"offset":8}},{"type":"EOF","value":"","line":1,"column":8,"offset":8,"end":{"line":1,"column":8,"offset":8}}]`},
+		{"while // c", true, `[{"type":"while","value":"","line":1,"column":1,"offset":0,"end":{"line":1,"column":6,"offset":5}},{"type":"comment","value":"// c","line":1,"column":7,"offset":6,"end":{"line":1,"column":11,"offset":10}},{"type":"EOF","value":"","line":1,"column":11,"offset":10,"end":{"line":1,"column":11,"offset":10}}]`},
+		{`"a`, false, `[{"type":"ILLEGAL","value":"didn't find end quote in string","line":1,"column":1,"offset":0,"end":{"line":1,"column":3,"offset":2}}]`},
+	}
+	for _, test := range tests {
+		output, err := json.Marshal(Tokens([]byte(test.source), test.comments))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.output {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.source, test.output, output)
+		}
+	}
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+	}{
+		{`if ($x) { }`, `{"type":"Program","statements":[{"type":"If","line":1,"column":1,"offset":0,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":12,"offset":11},"condition":{"type":"Variable","line":1,"column":6,"offset":5,"start":{"line":1,"column":5,"offset":4},"end":{"line":1,"column":7,"offset":6},"name":"x","slot":{"local":false,"depth":0,"index":0}},"body":[],"else":[]}]}`},
+		{`echo(-1, "a")`, `{"type":"Program","statements":[{"type":"ExpressionStatement","line":1,"column":1,"offset":0,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":14,"offset":13},"expression":{"type":"Call","line":1,"column":5,"offset":4,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":14,"offset":13},"function":{"type":"Variable","line":1,"column":1,"offset":0,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":5,"offset":4},"name":"echo","slot":{"local":false,"depth":0,"index":0}},"arguments":[{"type":"Unary","line":1,"column":6,"offset":5,"start":{"line":1,"column":6,"offset":5},"end":{"line":1,"column":8,"offset":7},"operator":"-","operand":{"type":"Literal","line":1,"column":7,"offset":6,"start":{"line":1,"column":7,"offset":6},"end":{"line":1,"column":8,"offset":7},"value":1}},{"type":"Literal","line":1,"column":10,"offset":9,"start":{"line":1,"column":10,"offset":9},"end":{"line":1,"column":13,"offset":12},"value":"a"}],"ellipsis":false}}]}`},
+		{`function f($a) { return $a }`, `{"type":"Program","statements":[{"type":"FunctionDefinition","line":1,"column":1,"offset":0,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":29,"offset":28},"name":"f","parameters":["a"],"ellipsis":false,"async":false,"body":[{"type":"Return","line":1,"column":18,"offset":17,"start":{"line":1,"column":18,"offset":17},"end":{"line":1,"column":27,"offset":26},"result":{"type":"Variable","line":1,"column":26,"offset":25,"start":{"line":1,"column":25,"offset":24},"end":{"line":1,"column":27,"offset":26},"name":"a","slot":{"local":true,"depth":0,"index":0}}}],"slot":{"local":false,"depth":0,"index":0},"locals":["a"]}]}`},
+		{`$m = {"k": nil}`, `{"type":"Program","statements":[{"type":"Assign","line":1,"column":4,"offset":3,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":16,"offset":15},"target":{"type":"Variable","line":1,"column":2,"offset":1,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":3,"offset":2},"name":"m","slot":{"local":false,"depth":0,"index":0}},"value":{"type":"Map","line":1,"column":6,"offset":5,"start":{"line":1,"column":6,"offset":5},"end":{"line":1,"column":16,"offset":15},"items":[{"key":{"type":"Literal","line":1,"column":7,"offset":6,"start":{"line":1,"column":7,"offset":6},"end":{"line":1,"column":10,"offset":9},"value":"k"},"value":{"type":"Literal","line":1,"column":12,"offset":11,"start":{"line":1,"column":12,"offset":11},"end":{"line":1,"column":15,"offset":14},"value":null}}]}}]}`},
+		{`(1 + 2) * $x["é"]`, `{"type":"Program","statements":[{"type":"ExpressionStatement","line":1,"column":1,"offset":0,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":18,"offset":18},"expression":{"type":"Binary","line":1,"column":9,"offset":8,"start":{"line":1,"column":1,"offset":0},"end":{"line":1,"column":18,"offset":18},"left":{"type":"Binary","line":1,"column":4,"offset":3,"start":{"line":1,"column":2,"offset":1},"end":{"line":1,"column":7,"offset":6},"left":{"type":"Literal","line":1,"column":2,"offset":1,"start":{"line":1,"column":2,"offset":1},"end":{"line":1,"column":3,"offset":2},"value":1},"operator":"+","right":{"type":"Literal","line":1,"column":6,"offset":5,"start":{"line":1,"column":6,"offset":5},"end":{"line":1,"column":7,"offset":6},"value":2}},"operator":"*","right":{"type":"Subscript","line":1,"column":13,"offset":12,"start":{"line":1,"column":11,"offset":10},"end":{"line":1,"column":18,"offset":18},"container":{"type":"Variable","line":1,"column":12,"offset":11,"start":{"line":1,"column":11,"offset":10},"end":{"line":1,"column":13,"offset":12},"name":"x","slot":{"local":false,"depth":0,"index":0}},"subscript":{"type":"Literal","line":1,"column":14,"offset":13,"start":{"line":1,"column":14,"offset":13},"end":{"line":1,"column":17,"offset":17},"value":"é"}}}}]}`},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%q: %v", test.source, err)
+		}
+		output, err := json.Marshal(Program(prog))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.output {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.source, test.output, output)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// DaVinci Script
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/format"
+	"github.com/DavinciScript/Davi/parser"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const fmtUsage = `Usage: davi fmt [--check | --write] [path...]
+
+Formats Davi source in the canonical style. Directories are searched for
+.davi files. With no paths, the source is read from standard input.
+
+Options:
+  --check   list files that aren't formatted and exit with status 1 if any
+  --write   rewrite files in place instead of printing them
+`
+
+// runFmt implements the "davi fmt" command.
+func runFmt(args []string) int {
+	check := false
+	write := false
+	paths := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--check":
+			check = true
+		case "--write", "-w":
+			write = true
+		case "-h", "--help":
+			fmt.Print(fmtUsage)
+			return exitOK
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "davi fmt: unknown option %s\n\n%s", arg, fmtUsage)
+				return exitUsageError
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if check && write {
+		fmt.Fprintf(os.Stderr, "davi fmt: --check and --write can't be used together\n\n%s", fmtUsage)
+		return exitUsageError
+	}
+
+	if len(paths) == 0 {
+		if write {
+			fmt.Fprintln(os.Stderr, "davi fmt: --write requires a path")
+			return exitUsageError
+		}
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+			return exitUsageError
+		}
+		return formatSource("<stdin>", input, check, false)
+	}
+
+	files, err := daviFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsageError
+	}
+	status := exitOK
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file %s. Please check the file path and try again.\n", file)
+			status = exitUsageError
+			continue
+		}
+		if s := formatSource(file, input, check, write); s > status {
+			status = s
+		}
+	}
+	return status
+}
+
+// formatSource formats the source of one file and prints it, checks it or
+// writes it back, returning the exit status.
+func formatSource(name string, input []byte, check, write bool) int {
+	output, err := format.Source(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+		if _, ok := err.(parser.Error); ok {
+			return exitParseError
+		}
+		return exitRuntimeError
+	}
+	switch {
+	case check:
+		if !bytes.Equal(input, output) {
+			fmt.Println(name)
+			return exitRuntimeError
+		}
+	case write:
+		if !bytes.Equal(input, output) {
+			info, err := os.Stat(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsageError
+			}
+			err = ioutil.WriteFile(name, output, info.Mode())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitUsageError
+			}
+		}
+	default:
+		os.Stdout.Write(output)
+	}
+	return exitOK
+}
+
+// daviFiles returns the given files, plus the .davi files in the given
+// directories and their subdirectories.
+func daviFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(file) == ".davi" {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
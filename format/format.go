@@ -0,0 +1,356 @@
+// DaVinci Script
+
+// Package format implements davi's canonical source formatter.
+//
+// The formatter works on the token stream rather than the AST, so comments,
+// blank lines and the <?davi ... ?> tags around a script survive. Line breaks
+// are kept where the author put them, except that an opening brace of a
+// block is moved up onto the line of its header and "else" is joined to the
+// preceding closing brace. A block the author wrote on one line stays on one
+// line; otherwise each of its statements goes on a line of its own (after
+// a ; or the } of an inner block, a trailing comment aside) and so does its
+// closing brace. Runs of blank lines are collapsed to one, indentation is
+// four spaces per level of nesting and spacing between tokens on a line is
+// normalized.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+)
+
+const (
+	openTag  = "<?davi"
+	closeTag = "?>"
+	indent   = "    "
+)
+
+// Source formats a Davi program in the canonical style. It returns a
+// parser.Error if src has a syntax error.
+func Source(src []byte) ([]byte, error) {
+	code, open, close := stripTags(src)
+	_, err := parser.ParseProgram(code)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []token{}
+	if open >= 0 {
+		tokens = append(tokens, token{lineOf(code, open), ILLEGAL, openTag})
+	}
+	l := NewLexerWithComments(code)
+	for {
+		pos, tok, val := l.Next()
+		if tok == EOF {
+			break
+		}
+		tokens = append(tokens, token{pos, tok, val})
+	}
+	if close >= 0 {
+		tokens = append(tokens, token{lineOf(code, close), ILLEGAL, closeTag})
+	}
+
+	p := &printer{multiLine: multiLineBraces(tokens)}
+	for i := range tokens {
+		p.print(tokens[i])
+	}
+	out := p.bytes()
+
+	// The formatter must only ever change whitespace and comments, so
+	// double check that the tokens are the same as before
+	formatted, _, _ := stripTags(out)
+	if !sameTokens(code, formatted) {
+		return nil, fmt.Errorf("formatting changed the program's tokens (this is a bug in davi fmt)")
+	}
+	return out, nil
+}
+
+// stripTags blanks out a <?davi tag at the start and a ?> tag at the end of
+// src, keeping the positions of everything else. It returns the offsets of
+// the tags, or -1 for a missing tag.
+func stripTags(src []byte) (code []byte, open, close int) {
+	code = append([]byte{}, src...)
+	open, close = -1, -1
+	trimmed := bytes.TrimSpace(code)
+	if bytes.HasPrefix(trimmed, []byte(openTag)) {
+		open = bytes.Index(code, []byte(openTag))
+		copy(code[open:], strings.Repeat(" ", len(openTag)))
+		trimmed = bytes.TrimSpace(code)
+	}
+	if bytes.HasSuffix(trimmed, []byte(closeTag)) {
+		close = bytes.LastIndex(code, []byte(closeTag))
+		copy(code[close:], strings.Repeat(" ", len(closeTag)))
+	}
+	return code, open, close
+}
+
+// lineOf returns the position of the start of the line containing offset.
+func lineOf(src []byte, offset int) Position {
+	return Position{Line: bytes.Count(src[:offset], []byte{'\n'}) + 1, Column: 1}
+}
+
+func sameTokens(a, b []byte) bool {
+	la := NewLexer(a)
+	lb := NewLexer(b)
+	for {
+		_, tokA, valA := la.Next()
+		_, tokB, valB := lb.Next()
+		if tokA != tokB || valA != valB {
+			return false
+		}
+		if tokA == EOF || tokA == ILLEGAL {
+			return true
+		}
+	}
+}
+
+// multiLineBraces returns the offsets of the { tokens whose matching } is
+// on a later line.
+func multiLineBraces(tokens []token) map[int]bool {
+	multiLine := make(map[int]bool)
+	open := []token{}
+	for _, t := range tokens {
+		switch {
+		case isOpener(t.tok):
+			open = append(open, t)
+		case isCloser(t.tok) && len(open) > 0:
+			o := open[len(open)-1]
+			open = open[:len(open)-1]
+			if o.tok == LBRACE && t.pos.Line > o.pos.Line {
+				multiLine[o.pos.Offset] = true
+			}
+		}
+	}
+	return multiLine
+}
+
+type token struct {
+	pos Position
+	tok Token
+	val string // value of INT, NAME, STR and COMMENT tokens, or tag text
+}
+
+func (t token) isTag() bool {
+	return t.tok == ILLEGAL
+}
+
+func (t token) String() string {
+	switch t.tok {
+	case INT, NAME, COMMENT, ILLEGAL:
+		return t.val
+	case STR:
+		return quote(t.val)
+	default:
+		return t.tok.String()
+	}
+}
+
+// quote returns s as a Davi string literal, escaping only what the lexer
+// requires to be escaped.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// opener is an open bracket that hasn't been closed yet.
+type opener struct {
+	tok       Token
+	indent    int  // indentation of the lines inside the brackets
+	block     bool // whether a { is a block rather than a map literal
+	multiLine bool // whether a block's } is on a later line than its {
+	function  bool // whether it holds a function expression's parameters or body
+}
+
+// printer builds the formatted output one token at a time.
+type printer struct {
+	out       bytes.Buffer
+	indent    int
+	stack     []opener
+	multiLine map[int]bool // from multiLineBraces
+
+	last     *token // last token printed, including comments
+	lastLine int    // source line of last
+	lastSig  *token // last token that isn't a comment or a tag
+	prevSig  *token // the significant token before lastSig
+	unary    bool   // whether lastSig is a unary minus
+	closed   opener // the bracket lastSig closed, if it is a closing bracket
+}
+
+func (p *printer) print(t token) {
+	block := t.tok == LBRACE && p.isBlockBrace()
+
+	newLine := p.last == nil || t.pos.Line > p.lastLine || t.isTag() || p.last.isTag()
+	if newLine && p.last != nil && !t.isTag() && p.last.tok != COMMENT && !p.last.isTag() {
+		// Pull a block's { up onto its header line and join "} else"
+		if block || (t.tok == ELSE && p.last.tok == RBRACE) {
+			newLine = false
+		}
+	}
+	if !newLine && p.startsStatementLine(t) {
+		newLine = true
+	}
+
+	if newLine {
+		if p.last != nil {
+			p.out.WriteByte('\n')
+			if t.pos.Line-p.lastLine > 1 && !isOpener(p.last.tok) && !isCloser(t.tok) {
+				p.out.WriteByte('\n')
+			}
+		}
+		p.indent = 0
+		if len(p.stack) > 0 && !t.isTag() {
+			top := p.stack[len(p.stack)-1]
+			p.indent = top.indent
+			if isCloser(t.tok) {
+				p.indent--
+			}
+		}
+		p.out.WriteString(strings.Repeat(indent, p.indent))
+	} else if p.needSpace(t) {
+		p.out.WriteByte(' ')
+	}
+	p.out.WriteString(t.String())
+
+	switch {
+	case isOpener(t.tok):
+		o := opener{tok: t.tok, indent: p.indent + 1, block: block}
+		if block {
+			o.multiLine = p.multiLine[t.pos.Offset]
+			o.function = p.lastSig.tok == RPAREN && p.closed.function
+		} else if t.tok == LPAREN {
+			o.function = p.lastSig != nil && p.lastSig.tok == FUNCTION
+		}
+		p.stack = append(p.stack, o)
+	case isCloser(t.tok) && len(p.stack) > 0:
+		p.closed = p.stack[len(p.stack)-1]
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+
+	p.last = &t
+	p.lastLine = t.pos.Line
+	if t.tok != COMMENT && !t.isTag() {
+		p.unary = t.tok == MINUS && !p.endsOperand()
+		p.prevSig = p.lastSig
+		p.lastSig = &t
+	}
+}
+
+func (p *printer) bytes() []byte {
+	if p.last != nil {
+		p.out.WriteByte('\n')
+	}
+	return p.out.Bytes()
+}
+
+// isBlockBrace reports whether a { following the tokens printed so far
+// starts a block (rather than a map literal).
+func (p *printer) isBlockBrace() bool {
+	if p.lastSig == nil {
+		return false
+	}
+	switch p.lastSig.tok {
+	case RPAREN, ELSE, DEFAULT, SELECT:
+		return true
+	case NAME:
+		return p.prevSig != nil && p.prevSig.tok == CLASS
+	}
+	return false
+}
+
+// startsStatementLine reports whether t, which is on the same source line
+// as the last token printed, goes on a new line because it starts a
+// statement or is the closing brace of a block that spans several lines.
+func (p *printer) startsStatementLine(t token) bool {
+	if len(p.stack) == 0 || t.tok == COMMENT || t.isTag() {
+		return false
+	}
+	top := p.stack[len(p.stack)-1]
+	if !top.block || !top.multiLine {
+		return false
+	}
+	if t.tok == RBRACE {
+		return true
+	}
+	switch p.last.tok {
+	case LBRACE, SEMI:
+		return true
+	case RBRACE:
+		// The end of a function expression's body doesn't end the statement
+		return p.closed.block && !p.closed.function && t.tok != ELSE && t.tok != SEMI
+	}
+	return false
+}
+
+// endsOperand reports whether the last significant token ends an operand,
+// so that a following minus is binary rather than unary.
+func (p *printer) endsOperand() bool {
+	if p.lastSig == nil {
+		return false
+	}
+	switch p.lastSig.tok {
+	case NAME, INT, STR, TRUE, FALSE, NIL, RPAREN, RBRACKET:
+		return true
+	case RBRACE:
+		return !p.closed.block
+	}
+	return false
+}
+
+// needSpace reports whether a space goes between the last token printed and
+// t, which is on the same line.
+func (p *printer) needSpace(t token) bool {
+	last := p.last
+	switch {
+	case t.tok == COMMENT:
+		return true
+	case last.tok == LPAREN || last.tok == LBRACKET:
+		return false
+	case last.tok == LBRACE:
+		return t.tok != RBRACE && p.stack[len(p.stack)-1].block
+	case t.tok == RPAREN || t.tok == RBRACKET:
+		return false
+	case t.tok == RBRACE:
+		return len(p.stack) > 0 && p.stack[len(p.stack)-1].block
+	case t.tok == COMMA || t.tok == SEMI || t.tok == COLON || t.tok == ELLIPSIS:
+		return false
+	case last.tok == DOLLAR || last.tok == DOT || last.tok == OBJECT_OPERATOR:
+		return false
+	case t.tok == DOT || t.tok == OBJECT_OPERATOR:
+		return false
+	case last.tok == MINUS && p.unary:
+		return false
+	case t.tok == LPAREN:
+		return !(last.tok == NAME || last.tok == RPAREN || last.tok == RBRACKET || last.tok == RBRACE || last.tok == FUNCTION)
+	case t.tok == LBRACKET:
+		return !(last.tok == NAME || last.tok == RPAREN || last.tok == RBRACKET || last.tok == STR)
+	}
+	return true
+}
+
+func isOpener(tok Token) bool {
+	return tok == LPAREN || tok == LBRACKET || tok == LBRACE
+}
+
+func isCloser(tok Token) bool {
+	return tok == RPAREN || tok == RBRACKET || tok == RBRACE
+}
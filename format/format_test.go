@@ -0,0 +1,136 @@
+// DaVinci Script
+
+package format
+
+import (
+	"github.com/DavinciScript/Davi/parser"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var sourceTests = []struct {
+	source string
+	output string
+}{
+	// Spacing between tokens
+	{"$x=1+2*3", "$x = 1 + 2 * 3\n"},
+	{"$x = -1 - -$y", "$x = -1 - -$y\n"},
+	{"function   f( $a , $b ) { return $a+$b }", "function f($a, $b) { return $a + $b }\n"},
+	{"$m = {\"a\":1, \"b\" : [1,2]}\n$e = {}", "$m = {\"a\": 1, \"b\": [1, 2]}\n$e = {}\n"},
+	{"$f = function($x) { return $x * 2 }\necho($f(1), $l[0])", "$f = function($x) { return $x * 2 }\necho($f(1), $l[0])\n"},
+	{"echo(sum([4, 5, 6] ...))", "echo(sum([4, 5, 6]...))\n"},
+	{"spawn square( $n , $out )", "spawn square($n, $out)\n"},
+	{"async function f() { return await g() }", "async function f() { return await g() }\n"},
+	{`echo("a\tb\n\"q\"")`, "echo(\"a\\tb\\n\\\"q\\\"\")\n"},
+
+	// Blocks are indented, with their { on the header's line and "else"
+	// after the }
+	{"if($x>1)\n{\necho( \"big\" )\n}\nelse\n{\necho(\"small\")\n}", "if ($x > 1) {\n    echo(\"big\")\n} else {\n    echo(\"small\")\n}\n"},
+	{"for ($x in [1,2, 3]) {\necho($x)\n}", "for ($x in [1, 2, 3]) {\n    echo($x)\n}\n"},
+	{"while ($i < 3) { $i = $i + 1; }", "while ($i < 3) { $i = $i + 1; }\n"},
+	{"class Greeter {\n$greeting = \"Hello\"\nfunction greet($name) {\necho(\"Hi \" + $name)\n}\n}\n$g = new Greeter()\n$g->greet(\"Ann\")",
+		"class Greeter {\n    $greeting = \"Hello\"\n    function greet($name) {\n        echo(\"Hi \" + $name)\n    }\n}\n$g = new Greeter()\n$g->greet(\"Ann\")\n"},
+	{"select {\ncase $v = recv($ch)\n{\necho($v)\n}\ndefault {\necho(0)\n}\n}", "select {\n    case $v = recv($ch) {\n        echo($v)\n    }\n    default {\n        echo(0)\n    }\n}\n"},
+	{"$x = [\n1,\n2\n]", "$x = [\n    1,\n    2\n]\n"},
+
+	// A block on several lines has each statement and its } on a line of
+	// their own
+	{"if ($a) {\necho(\"a\")\n} else {echo(\"other\");\n}", "if ($a) {\n    echo(\"a\")\n} else {\n    echo(\"other\");\n}\n"},
+	{"function f() { $a = 1; $b = 2;\nreturn {\"a\": $a} }", "function f() {\n    $a = 1;\n    $b = 2;\n    return {\"a\": $a}\n}\n"},
+	{"while ($x) {\nif ($y) { echo(2) } echo(1); // done\n}", "while ($x) {\n    if ($y) { echo(2) }\n    echo(1); // done\n}\n"},
+	{"$f = function() {\nreturn 1 };", "$f = function() {\n    return 1\n};\n"},
+	{"spawn function() {\necho(1) }(); echo(2)", "spawn function() {\n    echo(1)\n}(); echo(2)\n"},
+
+	// Comments and the author's line breaks are kept, but runs of blank
+	// lines become one and blank lines at the start and end of a block go
+	{"// top\n$x = 1 // trailing\n\n\n\n$y = 2\n// last", "// top\n$x = 1 // trailing\n\n$y = 2\n// last\n"},
+	{"$a = 1; $b = 2\n$c = 3", "$a = 1; $b = 2\n$c = 3\n"},
+	{"if ($a) {\n\n\n$b = 1\n\n}", "if ($a) {\n    $b = 1\n}\n"},
+	{"if ($a) { // why\n// more\n$b = 1 }", "if ($a) { // why\n    // more\n    $b = 1\n}\n"},
+
+	// Tags stay on their own lines
+	{"<?davi\necho(1)\n?>", "<?davi\necho(1)\n?>\n"},
+	{"<?davi\n\necho(1)\n\n?>", "<?davi\n\necho(1)\n\n?>\n"},
+	{"", ""},
+}
+
+func TestSource(t *testing.T) {
+	for _, test := range sourceTests {
+		output, err := Source([]byte(test.source))
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+			continue
+		}
+		if string(output) != test.output {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.source, test.output, output)
+		}
+	}
+}
+
+// TestIdempotent checks that formatting formatted source doesn't change
+// it, for the tests above and the scripts in tests/.
+func TestIdempotent(t *testing.T) {
+	sources := map[string][]byte{}
+	for _, test := range sourceTests {
+		sources[test.source] = []byte(test.source)
+	}
+	paths, err := filepath.Glob("../tests/*.davi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[path] = source
+	}
+	for name, source := range sources {
+		once, err := Source(source)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		twice, err := Source(once)
+		if err != nil {
+			t.Errorf("%s: formatted source doesn't format: %v", name, err)
+			continue
+		}
+		if string(twice) != string(once) {
+			t.Errorf("%s: formatting again changed\n%s\nto\n%s", name, once, twice)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source([]byte("$x = (1"))
+	if _, ok := err.(parser.Error); !ok {
+		t.Fatalf("expected a parser.Error, got %v", err)
+	}
+}
+
+// TestSameTokens checks the safety check that makes Source refuse a
+// formatting that changes anything but whitespace and comments.
+func TestSameTokens(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"$x=1", "$x = 1", true},
+		{"$x = 1 // c", "$x = 1", true},
+		{"if ($a)\n{ }", "if ($a) { }", true},
+		{`"a\tb"`, "\"a\tb\"", true},
+		{"$x = 1", "$x = 2", false},
+		{"$x = -1", "$x = 1", false},
+		{"$ab", "$a b", false},
+		{`"a b"`, `"a  b"`, false},
+		{"$x = 1", "$x = 1;", false},
+		{"$x = 1", "", false},
+	}
+	for _, test := range tests {
+		if same := sameTokens([]byte(test.a), []byte(test.b)); same != test.same {
+			t.Errorf("sameTokens(%q, %q) = %v, expected %v", test.a, test.b, same, test.same)
+		}
+	}
+}
@@ -2,12 +2,13 @@ module github.com/DavinciScript/Davi
 
 go 1.21.5
 
-require github.com/hokaccha/go-prettyjson v0.0.0-20211117102719-0474bc63780f
+require (
+	github.com/hokaccha/go-prettyjson v0.0.0-20211117102719-0474bc63780f
+	golang.org/x/sys v0.0.0-20220731174439-a90be440212d
+)
 
 require (
 	github.com/fatih/color v1.10.0 // indirect
-	github.com/fsamin/go-dump v1.8.0 // indirect
 	github.com/mattn/go-colorable v0.1.8 // indirect
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	golang.org/x/sys v0.0.0-20220731174439-a90be440212d // indirect
 )
@@ -0,0 +1,139 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+	"strings"
+)
+
+/**
+ * function: assert
+ * args: condition, [message]
+ * return: nil
+ * example: assert(len("abc") == 3, "length of abc")
+ * output: nil
+ * description: Fail the test with an assertion error if the condition is false, prefixing the error with the message if one is given.
+ * title: Assert
+ * category: Testing
+ */
+
+// assertFunction implements assert($condition[, $message]), which fails
+// with an AssertionError if the condition is false.
+func assertFunction(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "assert() requires 1 or 2 args, got %d", len(args)))
+	}
+	condition, ok := args[0].(bool)
+	if !ok {
+		panic(typeError(pos, "assert() requires a bool condition, not %s", typeName(args[0])))
+	}
+	if !condition {
+		panic(assertionError(pos, "%s", assertMessage(pos, "assert", args[1:], "condition is false")))
+	}
+	return Value(nil)
+}
+
+/**
+ * function: assertEquals
+ * args: expected, actual, [message]
+ * return: nil
+ * example: assertEquals("ABC", upper("abc"))
+ * output: nil
+ * description: Fail the test with an assertion error showing both values unless they're equal as compared by ==.
+ * title: Assert Equals
+ * category: Testing
+ */
+
+// assertEqualsFunction implements assertEquals($expected, $actual[,
+// $message]), which fails with an AssertionError unless the values are
+// equal as compared by ==.
+func assertEqualsFunction(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 2 && len(args) != 3 {
+		panic(typeError(pos, "assertEquals() requires 2 or 3 args, got %d", len(args)))
+	}
+	expected, actual := args[0], args[1]
+	if !evalEqual(pos, expected, actual).(bool) {
+		detail := "expected " + toString(expected, true) + ", got " + toString(actual, true)
+		panic(assertionError(pos, "%s", assertMessage(pos, "assertEquals", args[2:], detail)))
+	}
+	return Value(nil)
+}
+
+/**
+ * function: assertThrows
+ * args: function, [substring]
+ * return: str
+ * example: assertThrows(function() { return 1 / 0; }, "divide by zero")
+ * output: "value error at 1:36: can't divide by zero"
+ * description: Call a function and fail the test unless it raises an error containing the substring, returning the error message.
+ * title: Assert Throws
+ * category: Testing
+ */
+
+// assertThrowsFunction implements assertThrows($function[, $substring]),
+// which calls the function (awaiting its result if it's async) and fails
+// with an AssertionError unless it raises an error, and if a substring is
+// given, one whose message contains it. It returns the error message.
+//
+// Errors from a limit that stops the whole program aren't caught, so a
+// runaway function under test still stops.
+func assertThrowsFunction(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) != 1 && len(args) != 2 {
+		panic(typeError(pos, "assertThrows() requires 1 or 2 args, got %d", len(args)))
+	}
+	f, ok := args[0].(functionType)
+	if !ok {
+		panic(typeError(pos, "assertThrows() requires a function, not %s", typeName(args[0])))
+	}
+	substring := ""
+	if len(args) > 1 {
+		substring, ok = args[1].(string)
+		if !ok {
+			panic(typeError(pos, "assertThrows() requires a str message, not %s", typeName(args[1])))
+		}
+	}
+	err := interp.catch(func() {
+		interp.await(pos, interp.callFunction(pos, f, nil))
+	})
+	if err == nil {
+		panic(assertionError(pos, "expected %s to raise an error", f.name()))
+	}
+	message := err.Error()
+	if !strings.Contains(message, substring) {
+		panic(assertionError(pos, "expected an error containing %q, got %q", substring, message))
+	}
+	return Value(message)
+}
+
+// catch calls f and returns the Error it raises, or nil if it returns
+// normally. Errors from an exceeded limit or a failed goroutine are raised
+// again.
+func (interp *interpreter) catch(f func()) (err Error) {
+	raising := interp.raising
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := interp.spanError(r).(Error)
+			interp.raising = raising
+			if !ok || interp.limits.exceeded(interp.stats.Ops) || interp.shared.err != nil {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	f()
+	return nil
+}
+
+// assertMessage returns the failure message of an assertion: detail,
+// prefixed by the message argument if one was given.
+func assertMessage(pos Position, name string, args []Value, detail string) string {
+	if len(args) == 0 {
+		return detail
+	}
+	message, ok := args[0].(string)
+	if !ok {
+		panic(typeError(pos, "%s() requires a str message, not %s", name, typeName(args[0])))
+	}
+	return message + ": " + detail
+}
@@ -0,0 +1,52 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		source string
+		config Config
+		output string
+		err    string
+	}{
+		{`assert(true); assertEquals([1, {"a": nil}], [1, {"a": nil}])`, Config{}, "", ""},
+		{`assert(false)`, Config{}, "", "assertion failed at 1:1: condition is false"},
+		{`assert(1 > 2, "order")`, Config{}, "", "assertion failed at 1:1: order: condition is false"},
+		{`assert(1)`, Config{}, "", "type error at 1:1: assert() requires a bool condition, not int"},
+		{`assertEquals("a", 1)`, Config{}, "", `assertion failed at 1:1: expected "a", got 1`},
+		{`assertEquals(1, 2, "sum")`, Config{}, "", "assertion failed at 1:1: sum: expected 1, got 2"},
+		{`echo(assertThrows(function() { return 1 / 0; }, "zero"))`, Config{}, "value error at 1:41: can't divide by zero\n", ""},
+		{`assertThrows(function() { })`, Config{}, "", "assertion failed at 1:1: expected <function> to raise an error"},
+		{`assertThrows(function() { nil + 1 }, "zero")`, Config{}, "", `expected an error containing "zero"`},
+		{`async function f() { assert(false) } echo(assertThrows(f))`, Config{}, "assertion failed at 1:22: condition is false\n", ""},
+		{`assertThrows(function() { while (true) { } })`, Config{MaxOps: 1000}, "", "runtime error at 1:34: op limit of 1000 exceeded"},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			var out bytes.Buffer
+			config := test.config
+			config.Stdout = &out
+			_, err := execute(prog, &config, compiled)
+			if test.err == "" && err != nil {
+				t.Errorf("%s (compiled %v): unexpected error %v", test.source, compiled, err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("%s (compiled %v): expected error %q, got %v", test.source, compiled, test.err, err)
+			}
+			if out.String() != test.output {
+				t.Errorf("%s (compiled %v): expected output %q, got %q", test.source, compiled, test.output, out.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// Promise is the Davi value returned by async functions and async I/O
+// builtins. It settles exactly once, either with a value or an error.
+type Promise struct {
+	state     promiseState
+	value     Value
+	err       error
+	waiters   []*task
+	callbacks []func()
+	loop      *eventLoop
+}
+
+type promiseState int
+
+const (
+	promisePending promiseState = iota
+	promiseFulfilled
+	promiseRejected
+)
+
+// eventLoop schedules the async tasks and I/O completions of one goroutine
+// of Davi code. Only one task runs at a time and ready tasks run in FIFO
+// order, so script code stays single-threaded and deterministic; only the
+// Go side of async builtins runs concurrently.
+//
+// Each run of a program (an Execute call, say) has its own loop, shared by
+// the goroutines it spawns, and done is closed when the run finishes. The
+// goroutines of tasks, async builtins and spawned calls the run left behind
+// then exit rather than waiting forever for a loop that's gone.
+type eventLoop struct {
+	ready       []*task
+	completions chan func()
+	pending     int
+	done        <-chan struct{}
+}
+
+// task is an async function call running on its own goroutine. Control is
+// handed back and forth between the loop and the task over resume and yield.
+type task struct {
+	resume  chan struct{}
+	yield   chan struct{}
+	running bool // whether the task has control
+}
+
+func newEventLoop(done <-chan struct{}) *eventLoop {
+	return &eventLoop{completions: make(chan func()), done: done}
+}
+
+// startLoop gives interp a new event loop for a run of a program. The
+// returned function stops the loop, and must be called with the interpreter
+// lock held when the run finishes.
+func (interp *interpreter) startLoop() (stop func()) {
+	done := make(chan struct{})
+	interp.loop = newEventLoop(done)
+	return func() { close(done) }
+}
+
+// stopped reports whether the run the loop belongs to has finished.
+func (loop *eventLoop) stopped() bool {
+	select {
+	case <-loop.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (loop *eventLoop) newPromise() *Promise {
+	return &Promise{loop: loop}
+}
+
+func (p *Promise) settle(state promiseState, value Value, err error) {
+	if p.state != promisePending {
+		return
+	}
+	p.state = state
+	p.value = value
+	p.err = err
+	p.loop.ready = append(p.loop.ready, p.waiters...)
+	p.waiters = nil
+	callbacks := p.callbacks
+	p.callbacks = nil
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+func (p *Promise) resolve(value Value) {
+	p.settle(promiseFulfilled, value, nil)
+}
+
+func (p *Promise) reject(err error) {
+	p.settle(promiseRejected, nil, err)
+}
+
+func (p *Promise) settled() bool {
+	return p.state != promisePending
+}
+
+// then calls f once p has settled (straight away if it already has).
+func (p *Promise) then(f func()) {
+	if p.settled() {
+		f()
+		return
+	}
+	p.callbacks = append(p.callbacks, f)
+}
+
+func (p *Promise) String() string {
+	switch p.state {
+	case promiseFulfilled:
+		return "<promise fulfilled>"
+	case promiseRejected:
+		return "<promise rejected>"
+	default:
+		return "<promise pending>"
+	}
+}
+
+// run hands control to t until it awaits a pending promise or finishes. If
+// the loop was stopped in the meantime, the goroutine running it exits.
+func (loop *eventLoop) run(t *task) {
+	t.resume <- struct{}{}
+	<-t.yield
+	if loop.stopped() {
+		runtime.Goexit()
+	}
+}
+
+// resume waits for the event loop to hand control to interp's task. If the
+// loop is stopped first, the task's goroutine exits, unwinding with the
+// interpreter lock held.
+func (interp *interpreter) resume() {
+	t := interp.task
+	select {
+	case <-t.resume:
+		t.running = true
+	case <-interp.loop.done:
+		interp.shared.lock.Lock()
+		runtime.Goexit()
+	}
+}
+
+// suspend hands control from interp's task back to the event loop until
+// the loop resumes it.
+func (interp *interpreter) suspend() {
+	t := interp.task
+	t.running = false
+	t.yield <- struct{}{}
+	interp.resume()
+}
+
+// step runs one ready task or, if there are none, waits for one async
+// builtin to complete. It returns false if there is nothing left to do.
+// pos is where the script is waiting, for the error if it's cancelled.
+func (interp *interpreter) step(pos Position) bool {
+	loop := interp.loop
+	if len(loop.ready) > 0 {
+		t := loop.ready[0]
+		loop.ready = loop.ready[1:]
+		loop.run(t)
+		return true
+	}
+	if loop.pending > 0 {
+		_, recv, _ := interp.block(pos, []reflect.SelectCase{{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(loop.completions),
+		}})
+		loop.pending--
+		recv.Interface().(func())()
+		return true
+	}
+	return false
+}
+
+// drain runs the event loop until every task and async builtin is done.
+func (interp *interpreter) drain() {
+	// The script has finished, so there's no position to wait at
+	for interp.step(Position{}) {
+	}
+}
+
+// goAsync runs f on a new goroutine and returns a promise settled with its
+// result. f must not touch interpreter state.
+func (interp *interpreter) goAsync(f func() (Value, error)) *Promise {
+	loop := interp.loop
+	p := loop.newPromise()
+	loop.pending++
+	go func() {
+		value, err := f()
+		select {
+		case loop.completions <- func() {
+			if err != nil {
+				p.reject(err)
+			} else {
+				p.resolve(value)
+			}
+		}:
+		case <-loop.done:
+		}
+	}()
+	return p
+}
+
+// after calls f on the event loop after d, unless the returned stop function
+// is called first.
+func (interp *interpreter) after(d time.Duration, f func()) (stop func()) {
+	loop := interp.loop
+	loop.pending++
+	timer := time.AfterFunc(d, func() {
+		select {
+		case loop.completions <- f:
+		case <-loop.done:
+		}
+	})
+	return func() {
+		if timer.Stop() {
+			loop.pending--
+		}
+	}
+}
+
+// callAsync starts an async function call as a new task and returns the
+// promise for its result. The task first runs the next time the script
+// awaits (or when the script ends).
+func (interp *interpreter) callAsync(pos Position, f bodyFunction, args []Value) *Promise {
+	p := interp.loop.newPromise()
+	t := &task{resume: make(chan struct{}), yield: make(chan struct{})}
+	child := interp.fork()
+	child.loop = interp.loop
+	child.task = t
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				switch e := child.spanError(r).(type) {
+				case Error:
+					p.reject(e)
+				default:
+					p.reject(runtimeError(pos, "async function panicked: %v", r))
+				}
+			}
+			if t.running {
+				t.yield <- struct{}{}
+			} else {
+				// Stopped while suspended, so resume took the lock
+				child.shared.lock.Unlock()
+			}
+		}()
+		child.resume()
+		p.resolve(child.callFunction(pos, asyncBody{f}, args))
+	}()
+	interp.loop.ready = append(interp.loop.ready, t)
+	return p
+}
+
+// bodyFunction is a user function whose body can be called synchronously,
+// whether or not it's async.
+type bodyFunction interface {
+	functionType
+	callBody(interp *interpreter, pos Position, args []Value) Value
+}
+
+// asyncBody calls an async function's body synchronously.
+type asyncBody struct {
+	f bodyFunction
+}
+
+func (b asyncBody) call(interp *interpreter, pos Position, args []Value) Value {
+	return b.f.callBody(interp, pos, args)
+}
+
+func (b asyncBody) name() string {
+	return b.f.name()
+}
+
+// await waits for value to settle if it's a promise, running other tasks in
+// the meantime, and returns its value or panics with its error. Other
+// values are returned as is.
+func (interp *interpreter) await(pos Position, value Value) Value {
+	p, ok := value.(*Promise)
+	if !ok {
+		return value
+	}
+	if p.loop != interp.loop {
+		panic(runtimeError(pos, "can't await a promise created on another goroutine"))
+	}
+	if !p.settled() {
+		if interp.task != nil {
+			// Suspend this task until p settles
+			p.waiters = append(p.waiters, interp.task)
+			interp.suspend()
+		} else {
+			for !p.settled() {
+				if !interp.step(pos) {
+					panic(runtimeError(pos, "await would block forever: promise can never settle"))
+				}
+			}
+		}
+	}
+	if p.state == promiseRejected {
+		panic(p.err)
+	}
+	return p.value
+}
+
+func ensurePromises(pos Position, name string, v Value) []Value {
+	list, ok := v.(*[]Value)
+	if !ok {
+		panic(typeError(pos, "%s() requires a list, not %s", name, typeName(v)))
+	}
+	return *list
+}
+
+// promiseAll settles with the list of all values once every promise in
+// values is fulfilled, or with the first error.
+func (interp *interpreter) promiseAll(pos Position, values []Value) *Promise {
+	result := interp.loop.newPromise()
+	results := make([]Value, len(values))
+	remaining := len(values)
+	for i, v := range values {
+		p, ok := v.(*Promise)
+		if !ok {
+			results[i] = v
+			remaining--
+			continue
+		}
+		i := i
+		p.then(func() {
+			if p.state == promiseRejected {
+				result.reject(p.err)
+				return
+			}
+			results[i] = p.value
+			remaining--
+			if remaining == 0 {
+				result.resolve(Value(&results))
+			}
+		})
+	}
+	if remaining == 0 {
+		result.resolve(Value(&results))
+	}
+	return result
+}
+
+// promiseRace settles like the first promise in values to settle.
+func (interp *interpreter) promiseRace(pos Position, values []Value) *Promise {
+	result := interp.loop.newPromise()
+	for _, v := range values {
+		p, ok := v.(*Promise)
+		if !ok {
+			result.resolve(v)
+			break
+		}
+		p.then(func() {
+			result.settle(p.state, p.value, p.err)
+		})
+	}
+	return result
+}
+
+// promiseTimeout settles like p, or is rejected if p hasn't settled after ms
+// milliseconds.
+func (interp *interpreter) promiseTimeout(pos Position, p *Promise, ms int) *Promise {
+	result := interp.loop.newPromise()
+	stop := interp.after(time.Duration(ms)*time.Millisecond, func() {
+		result.reject(runtimeError(pos, "promise timed out after %dms", ms))
+	})
+	p.then(func() {
+		stop()
+		result.settle(p.state, p.value, p.err)
+	})
+	return result
+}
+
+// sleep returns a promise fulfilled with nil after ms milliseconds.
+func (interp *interpreter) sleep(ms int) *Promise {
+	result := interp.loop.newPromise()
+	interp.after(time.Duration(ms)*time.Millisecond, func() {
+		result.resolve(Value(nil))
+	})
+	return result
+}
@@ -0,0 +1,54 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"testing"
+)
+
+const loopSource = `
+function loop($n) {
+    $total = 0;
+    $i = 0;
+    while ($i < $n) {
+        $total = $total + $i;
+        $i = $i + 1;
+    }
+    for ($x in range($n)) {
+        $total = $total - $x;
+    }
+    return $total;
+}
+loop(20000)
+`
+
+const recursionSource = `
+function fib($n) {
+    if ($n < 2) {
+        return $n;
+    }
+    return fib($n - 1) + fib($n - 2);
+}
+fib(18)
+`
+
+func benchmark(b *testing.B, source string, compiled bool) {
+	prog, err := parser.ParseProgram([]byte(source))
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := &Config{Stdout: io.Discard}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := execute(prog, config, compiled); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoop(b *testing.B)              { benchmark(b, loopSource, false) }
+func BenchmarkLoopCompiled(b *testing.B)      { benchmark(b, loopSource, true) }
+func BenchmarkRecursion(b *testing.B)         { benchmark(b, recursionSource, false) }
+func BenchmarkRecursionCompiled(b *testing.B) { benchmark(b, recursionSource, true) }
@@ -0,0 +1,444 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+)
+
+// The compiler turns a parsed program into bytecode for the virtual machine
+// in vm.go. Function locals live in the slots the parser's resolver gave
+// them; globals stay in the interpreter's global scope map so builtins,
+// Config.Vars and the REPL see the same variables as the tree-walking
+// evaluator.
+
+type opcode uint8
+
+const (
+	opConst          opcode = iota // push constants[arg]
+	opPop                          // discard the top of the stack
+	opLoadLocal                    // push local slot arg
+	opStoreLocal                   // pop into local slot arg
+	opLoadOuter                    // push outers[arg] from an enclosing function
+	opLoadGlobal                   // push global names[arg]
+	opStoreGlobal                  // pop into global names[arg]
+	opBinary                       // apply binary operator arg to the top two values
+	opUnary                        // apply unary operator arg to the top value
+	opAnd                          // left side of "and": jump to arg if false
+	opOr                           // left side of "or": jump to arg if true
+	opCheckBool                    // right side of "and" (arg 0) or "or" (arg 1)
+	opJump                         // jump to arg
+	opIfFalse                      // pop an if condition, jump to arg if false
+	opWhileFalse                   // pop a while condition, jump to arg if false
+	opIter                         // replace the top value with an iterator
+	opForNext                      // push the iterator's next value, or pop it and jump to arg
+	opList                         // pop arg values into a list
+	opMapKey                       // check the top value is a valid map key
+	opMap                          // pop arg key, value pairs into a map
+	opSubscript                    // pop container and subscript, push the element
+	opStoreSubscript               // pop container, subscript and value, assign the element
+	opCallable                     // check the top value can be called
+	opSpawnable                    // check the top value can be spawned
+	opSpread                       // replace the top value with its items, for f(...$x)
+	opCall                         // call a function with arg arguments
+	opCallSpread                   // like opCall, but the last argument was spread
+	opSpawn                        // call a function with arg arguments on a new goroutine
+	opSpawnSpread                  // like opSpawn, but the last argument was spread
+	opMethod                       // replace an object with its method names[arg]
+	opFunction                     // push a closure of functions[arg]
+	opClass                        // pop field values and push class classes[arg]
+	opNew                          // push a new instance of class names[arg]
+	opAwait                        // await the top value
+	opChannel                      // check the top value is a select case's channel
+	opSelect                       // run select selects[arg] and jump to the chosen case
+	opReturn                       // return the top value from the function
+)
+
+type instruction struct {
+	op  opcode
+	arg int
+}
+
+// code is a compiled function, or the top level of a program.
+type code struct {
+	name       string
+	parameters []string
+	ellipsis   bool
+	async      bool
+	topLevel   bool
+
+	instructions []instruction
+	positions    []Position    // position of each instruction, for errors
+	spans        []parser.Span // span of each instruction's expression, if any
+	constants    []Value
+	names        []string
+
+	locals    []string // from the parser's resolver, parameters first
+	outers    []outer
+	functions []*code
+	classes   []*classCode
+	selects   []*selectCode
+}
+
+// outer is a local of an enclosing function: depth is how many functions
+// out it is.
+type outer struct {
+	name  string
+	depth int
+	slot  int
+}
+
+type classCode struct {
+	name    string
+	methods []*code
+	fields  []string
+}
+
+type selectCode struct {
+	sends      []bool // whether each case is a send, with a value on the stack
+	targets    []int  // where each case's body starts
+	hasDefault bool
+	fallback   int // where the default body starts
+}
+
+// unsupportedError is returned by compile for programs the compiler can't
+// handle, which are run by the tree-walking evaluator instead.
+type unsupportedError struct {
+	pos  Position
+	what string
+}
+
+func (e unsupportedError) Error() string {
+	return fmt.Sprintf("can't compile %s at %s", e.what, e.pos)
+}
+
+type compiler struct {
+	code *code
+}
+
+// compile compiles prog to bytecode for the top level of a program, or
+// returns an unsupportedError.
+func compile(prog *parser.Program) (c *code, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(unsupportedError)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	comp := &compiler{code: newCode("", true)}
+	comp.block(prog.Statements)
+	return comp.code, nil
+}
+
+func newCode(name string, topLevel bool) *code {
+	return &code{name: name, topLevel: topLevel}
+}
+
+func unsupported(pos Position, format string, a ...interface{}) unsupportedError {
+	return unsupportedError{pos, fmt.Sprintf(format, a...)}
+}
+
+// function compiles the body of a function or method.
+func (c *compiler) function(name string, parameters []string, ellipsis, async bool, locals []string, body parser.Block) int {
+	fc := &compiler{newCode(name, false)}
+	fc.code.parameters = parameters
+	fc.code.ellipsis = ellipsis
+	fc.code.async = async
+	fc.code.locals = locals
+	fc.block(body)
+	c.code.functions = append(c.code.functions, fc.code)
+	return len(c.code.functions) - 1
+}
+
+func (c *compiler) emit(pos Position, op opcode, arg int) int {
+	c.code.instructions = append(c.code.instructions, instruction{op, arg})
+	c.code.positions = append(c.code.positions, pos)
+	c.code.spans = append(c.code.spans, parser.Span{})
+	return len(c.code.instructions) - 1
+}
+
+// here returns the address of the next instruction.
+func (c *compiler) here() int {
+	return len(c.code.instructions)
+}
+
+// patch sets the jump target of the instruction at addr to the next
+// instruction.
+func (c *compiler) patch(addr int) {
+	c.code.instructions[addr].arg = c.here()
+}
+
+func (c *compiler) constant(v Value) int {
+	c.code.constants = append(c.code.constants, v)
+	return len(c.code.constants) - 1
+}
+
+func (c *compiler) name(name string) int {
+	for i, n := range c.code.names {
+		if n == name {
+			return i
+		}
+	}
+	c.code.names = append(c.code.names, name)
+	return len(c.code.names) - 1
+}
+
+func (c *compiler) load(v *parser.Variable) {
+	switch {
+	case !v.Slot.Local:
+		c.emit(v.Position(), opLoadGlobal, c.name(v.Name))
+	case v.Slot.Depth == 0:
+		c.emit(v.Position(), opLoadLocal, v.Slot.Index)
+	default:
+		c.code.outers = append(c.code.outers, outer{v.Name, v.Slot.Depth, v.Slot.Index})
+		c.emit(v.Position(), opLoadOuter, len(c.code.outers)-1)
+	}
+}
+
+// store assigns to a variable, which is always local to the code being
+// compiled, or global at the top level.
+func (c *compiler) store(pos Position, slot parser.Slot, name string) {
+	if slot.Local {
+		c.emit(pos, opStoreLocal, slot.Index)
+	} else {
+		c.emit(pos, opStoreGlobal, c.name(name))
+	}
+}
+
+func (c *compiler) block(block parser.Block) {
+	for _, s := range block {
+		c.statement(s)
+	}
+}
+
+func (c *compiler) statement(s parser.Statement) {
+	switch s := s.(type) {
+	case *parser.Assign:
+		switch target := s.Target.(type) {
+		case *parser.Variable:
+			c.expression(s.Value)
+			c.store(target.Position(), target.Slot, target.Name)
+		case *parser.Subscript:
+			c.expression(target.Container)
+			c.expression(target.Subscript)
+			c.expression(s.Value)
+			c.emit(target.Subscript.Position(), opStoreSubscript, 0)
+		default:
+			panic(unsupported(s.Position(), "assignment to %T", target))
+		}
+	case *parser.If:
+		c.expression(s.Condition)
+		jumpElse := c.emit(s.Condition.Position(), opIfFalse, 0)
+		c.block(s.Body)
+		if len(s.Else) > 0 {
+			jumpEnd := c.emit(s.Position(), opJump, 0)
+			c.patch(jumpElse)
+			c.block(s.Else)
+			c.patch(jumpEnd)
+		} else {
+			c.patch(jumpElse)
+		}
+	case *parser.While:
+		loop := c.here()
+		c.expression(s.Condition)
+		jumpEnd := c.emit(s.Condition.Position(), opWhileFalse, 0)
+		c.block(s.Body)
+		c.emit(s.Position(), opJump, loop)
+		c.patch(jumpEnd)
+	case *parser.For:
+		c.expression(s.Iterable)
+		c.emit(s.Iterable.Position(), opIter, 0)
+		loop := c.emit(s.Position(), opForNext, 0)
+		c.store(s.Position(), s.Slot, s.Name)
+		c.block(s.Body)
+		c.emit(s.Position(), opJump, loop)
+		c.patch(loop)
+	case *parser.ExpressionStatement:
+		if _, ok := s.Expression.(*parser.SemiTag); ok {
+			return
+		}
+		c.expression(s.Expression)
+		c.emit(s.Position(), opPop, 0)
+	case *parser.FunctionDefinition:
+		index := c.function(s.Name, s.Parameters, s.Ellipsis, s.Async, s.Locals, s.Body)
+		c.emit(s.Position(), opFunction, index)
+		c.store(s.Position(), s.Slot, s.Name)
+	case *parser.Return:
+		c.expression(s.Result)
+		c.emit(s.Position(), opReturn, 0)
+	case *parser.Spawn:
+		c.call(s.Call, s.Call.Function.Position(), opSpawnable, opSpawn, opSpawnSpread)
+	case *parser.Select:
+		c.select_(s)
+	case *parser.ClassDefinition:
+		c.class(s)
+	default:
+		panic(unsupported(s.Position(), "statement %T", s))
+	}
+}
+
+func (c *compiler) select_(s *parser.Select) {
+	sc := &selectCode{hasDefault: s.HasDefault}
+	for _, cs := range s.Cases {
+		c.expression(cs.Channel)
+		c.emit(cs.Channel.Position(), opChannel, 0)
+		if cs.IsSend() {
+			c.expression(cs.Value)
+		}
+		sc.sends = append(sc.sends, cs.IsSend())
+	}
+	c.code.selects = append(c.code.selects, sc)
+	c.emit(s.Position(), opSelect, len(c.code.selects)-1)
+
+	jumps := []int{}
+	for _, cs := range s.Cases {
+		// The received value (nil for a send) is on the stack
+		sc.targets = append(sc.targets, c.here())
+		if cs.Target != nil {
+			v, ok := cs.Target.(*parser.Variable)
+			if !ok {
+				panic(unsupported(cs.Position(), "select target %T", cs.Target))
+			}
+			c.store(v.Position(), v.Slot, v.Name)
+		} else {
+			c.emit(cs.Position(), opPop, 0)
+		}
+		c.block(cs.Body)
+		jumps = append(jumps, c.emit(cs.Position(), opJump, 0))
+	}
+	sc.fallback = c.here()
+	c.block(s.Default)
+	for _, jump := range jumps {
+		c.patch(jump)
+	}
+}
+
+func (c *compiler) class(s *parser.ClassDefinition) {
+	cc := &classCode{name: s.ClassName}
+	for _, stmt := range s.Body {
+		switch stmt := stmt.(type) {
+		case *parser.FunctionDefinition:
+			// Methods are never async, like the tree-walking evaluator's
+			index := c.function(stmt.Name, stmt.Parameters, stmt.Ellipsis, false, stmt.Locals, stmt.Body)
+			cc.methods = append(cc.methods, c.code.functions[index])
+		case *parser.Assign:
+			v, ok := stmt.Target.(*parser.Variable)
+			if !ok {
+				panic(unsupported(stmt.Position(), "class field %T", stmt.Target))
+			}
+			c.expression(stmt.Value)
+			cc.fields = append(cc.fields, v.Name)
+		}
+	}
+	c.code.classes = append(c.code.classes, cc)
+	c.emit(s.Position(), opClass, len(c.code.classes)-1)
+	c.store(s.Position(), s.Slot, s.ClassName)
+}
+
+// call compiles a call or spawn: the function, a check that it can be
+// called, the arguments and the call itself.
+func (c *compiler) call(e *parser.Call, pos Position, check, op, spreadOp opcode) {
+	c.expression(e.Function)
+	c.emit(e.Function.Position(), check, 0)
+	for _, arg := range e.Arguments {
+		c.expression(arg)
+	}
+	if e.Ellipsis {
+		if len(e.Arguments) == 0 {
+			panic(unsupported(e.Position(), "... without arguments"))
+		}
+		c.emit(e.Arguments[len(e.Arguments)-1].Position(), opSpread, 0)
+		c.emit(pos, spreadOp, len(e.Arguments))
+	} else {
+		c.emit(pos, op, len(e.Arguments))
+	}
+}
+
+// spanFrom gives the instructions from start at the error position of expr
+// that don't have a span yet the span of expr, so the errors they raise are
+// about expr. Inner expressions are compiled first, so they keep theirs.
+func (c *compiler) spanFrom(start int, expr parser.Expression) {
+	pos := errorPosition(expr)
+	for i := start; i < len(c.code.instructions); i++ {
+		if c.code.positions[i] == pos && c.code.spans[i] == (parser.Span{}) {
+			c.code.spans[i] = expr.Span()
+		}
+	}
+}
+
+func (c *compiler) expression(expr parser.Expression) {
+	defer c.spanFrom(c.here(), expr)
+	switch e := expr.(type) {
+	case *parser.Binary:
+		switch {
+		case e.Operator == AND || e.Operator == OR:
+			op, check := opAnd, 0
+			if e.Operator == OR {
+				op, check = opOr, 1
+			}
+			c.expression(e.Left)
+			jump := c.emit(e.Position(), op, 0)
+			c.expression(e.Right)
+			c.emit(e.Position(), opCheckBool, check)
+			c.patch(jump)
+		case binaryEvalTable[e.Operator] != nil:
+			c.expression(e.Left)
+			c.expression(e.Right)
+			c.emit(e.Position(), opBinary, int(e.Operator))
+		default:
+			panic(unsupported(e.Position(), "binary operator %v", e.Operator))
+		}
+	case *parser.Unary:
+		if unaryEvalTable[e.Operator] == nil {
+			panic(unsupported(e.Position(), "unary operator %v", e.Operator))
+		}
+		c.expression(e.Operand)
+		c.emit(e.Position(), opUnary, int(e.Operator))
+	case *parser.Call:
+		c.call(e, e.Function.Position(), opCallable, opCall, opCallSpread)
+	case *parser.Literal:
+		c.emit(e.Position(), opConst, c.constant(e.Value))
+	case *parser.Variable:
+		c.load(e)
+	case *parser.List:
+		for _, v := range e.Values {
+			c.expression(v)
+		}
+		c.emit(e.Position(), opList, len(e.Values))
+	case *parser.Map:
+		for _, item := range e.Items {
+			c.expression(item.Key)
+			c.emit(item.Key.Position(), opMapKey, 0)
+			c.expression(item.Value)
+		}
+		c.emit(e.Position(), opMap, len(e.Items))
+	case *parser.Subscript:
+		c.expression(e.Container)
+		c.expression(e.Subscript)
+		c.emit(e.Subscript.Position(), opSubscript, 0)
+	case *parser.FunctionExpression:
+		index := c.function("", e.Parameters, e.Ellipsis, e.Async, e.Locals, e.Body)
+		c.emit(e.Position(), opFunction, index)
+	case *parser.Await:
+		c.expression(e.Value)
+		c.emit(e.Position(), opAwait, 0)
+	case *parser.SemiTag:
+		c.emit(e.Position(), opConst, c.constant(nil))
+	case *parser.MethodCall:
+		c.expression(e.Object)
+		c.emit(e.Position(), opMethod, c.name(e.Method))
+		for _, arg := range e.Arguments {
+			c.expression(arg)
+		}
+		c.emit(e.Position(), opCall, len(e.Arguments))
+	case *parser.NewExpression:
+		c.emit(e.Position(), opNew, c.name(e.ClassName))
+	default:
+		panic(unsupported(expr.Position(), "expression %T", expr))
+	}
+}
@@ -0,0 +1,304 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// How many statements a goroutine executes before giving other goroutines
+// a chance to take the interpreter lock.
+const yieldInterval = 1024
+
+// sharedState is shared by an interpreter and every interpreter running a
+// spawned goroutine. Davi code only runs while holding the interpreter lock,
+// so scopes, maps and lists never see concurrent access; the lock is released
+// while a goroutine blocks on a channel, wait group, mutex or I/O.
+type sharedState struct {
+	lock       sync.Mutex
+	concurrent bool
+
+	errOnce sync.Once
+	err     error
+	failed  chan struct{}
+}
+
+func newSharedState() *sharedState {
+	return &sharedState{failed: make(chan struct{})}
+}
+
+// fail records the first error raised by a spawned goroutine and wakes up
+// every goroutine blocked in a channel operation so it can stop too.
+func (s *sharedState) fail(err error) {
+	s.errOnce.Do(func() {
+		s.err = err
+		close(s.failed)
+	})
+}
+
+// Channel is a Davi channel value, created by the channel() builtin.
+type Channel struct {
+	ch chan Value
+}
+
+// WaitGroup is a Davi wait group value, created by the waitGroup() builtin.
+type WaitGroup struct {
+	mu      sync.Mutex
+	count   int
+	waiters chan struct{}
+}
+
+// Mutex is a Davi mutex value, created by the mutex() builtin.
+type Mutex struct {
+	ch chan struct{}
+}
+
+// block releases the interpreter lock while waiting for one of the given
+// select cases to proceed, like reflect.Select. It panics with the error of
+// a failed goroutine if one fails while waiting, with an error at pos if
+// the execution context is cancelled, or with a RuntimeError at pos if a
+// case sends on a closed channel. The lock is held again when it returns or
+// panics. If the run finishes while waiting, the goroutine exits as in
+// relock.
+func (interp *interpreter) block(pos Position, cases []reflect.SelectCase) (chosen int, recv reflect.Value, ok bool) {
+	cases = append(cases, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(interp.shared.failed),
+	}, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(interp.limits.done()),
+	}, reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(interp.loop.done),
+	})
+	interp.unlocked(func() {
+		chosen, recv, ok = selectCases(pos, cases)
+	})
+	// unlocked doesn't return once the loop is done, so the last case
+	// can't have been chosen
+	switch chosen {
+	case len(cases) - 3:
+		panic(interp.shared.err)
+	case len(cases) - 2:
+		panic(interp.limits.cancelled(pos))
+	}
+	return chosen, recv, ok
+}
+
+// selectCases is reflect.Select, but panics with a RuntimeError at pos
+// instead of a Go runtime error if a case sends on a closed channel.
+func selectCases(pos Position, cases []reflect.SelectCase) (int, reflect.Value, bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(runtimeError(pos, "send on closed channel"))
+		}
+	}()
+	return reflect.Select(cases)
+}
+
+// unlocked runs f (typically blocking I/O) without holding the interpreter
+// lock, so other goroutines can run in the meantime.
+func (interp *interpreter) unlocked(f func()) {
+	interp.shared.lock.Unlock()
+	defer interp.relock()
+	f()
+}
+
+// relock takes the interpreter lock again after it was released. If the
+// run has finished in the meantime, the goroutine exits instead, unwinding
+// with the lock held; the deferred Unlock that every goroutine running
+// Davi code has then releases it.
+func (interp *interpreter) relock() {
+	interp.shared.lock.Lock()
+	if interp.loop.stopped() {
+		runtime.Goexit()
+	}
+}
+
+// yield briefly releases the interpreter lock once goroutines are running.
+func (interp *interpreter) yield() {
+	if interp.shared.concurrent && interp.stats.Ops%yieldInterval == 0 {
+		interp.shared.lock.Unlock()
+		runtime.Gosched()
+		interp.relock()
+	}
+}
+
+// fork returns a new interpreter sharing the globals, I/O and shared state
+// of interp, with its own event loop, for running a function on another
+// goroutine. The loop is stopped along with interp's.
+func (interp *interpreter) fork() *interpreter {
+	child := *interp
+	child.env = nil
+	child.loop = newEventLoop(interp.loop.done)
+	child.task = nil
+	child.frames = nil
+	child.depth = 0
+	// Charge nothing until the child runs a line of its own
+	child.profileCursor.time = time.Time{}
+	return &child
+}
+
+// goCall calls f with args on a new goroutine, which exits once the run
+// that spawned it finishes, like a Go program's goroutines when main
+// returns.
+func (interp *interpreter) goCall(pos Position, f functionType, args []Value) {
+	interp.shared.concurrent = true
+	child := interp.fork()
+	go func() {
+		defer child.shared.lock.Unlock()
+		child.relock()
+		defer func() {
+			if r := recover(); r != nil {
+				switch e := child.spanError(r).(type) {
+				case Error:
+					child.shared.fail(e)
+				default:
+					child.shared.fail(runtimeError(pos, "spawned goroutine panicked: %v", r))
+				}
+			}
+		}()
+		child.callFunction(pos, f, args)
+		child.drain()
+	}()
+}
+
+func (interp *interpreter) executeSpawn(s *parser.Spawn) {
+	function := interp.evaluate(s.Call.Function)
+	f, ok := function.(functionType)
+	if !ok {
+		interp.raising = nil
+		panic(typeError(s.Call.Function.Position(), "can't spawn non-function type %s", typeName(function)))
+	}
+	args := interp.evaluateArgs(s.Call)
+	interp.goCall(s.Call.Function.Position(), f, args)
+}
+
+func (interp *interpreter) executeSelect(s *parser.Select) completion {
+	cases := make([]reflect.SelectCase, len(s.Cases))
+	for i, c := range s.Cases {
+		value := interp.evaluate(c.Channel)
+		channel, ok := value.(*Channel)
+		if !ok {
+			interp.raising = nil
+			panic(typeError(c.Channel.Position(), "select case requires a channel, got %s", typeName(value)))
+		}
+		if c.IsSend() {
+			send := interp.evaluate(c.Value)
+			cases[i] = reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(channel.ch),
+				Send: reflect.ValueOf(&send).Elem(),
+			}
+		} else {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.ch)}
+		}
+	}
+
+	var chosen int
+	var recv reflect.Value
+	var recvOK bool
+	if s.HasDefault {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		chosen, recv, recvOK = selectCases(s.Position(), cases)
+		if chosen == len(s.Cases) {
+			return interp.executeBlock(s.Default)
+		}
+	} else {
+		if len(cases) == 0 {
+			panic(runtimeError(s.Position(), "select with no cases blocks forever"))
+		}
+		chosen, recv, recvOK = interp.block(s.Position(), cases)
+	}
+
+	c := s.Cases[chosen]
+	if c.Target != nil {
+		var value Value
+		if recvOK {
+			value = recv.Interface()
+		}
+		target := c.Target.(*parser.Variable)
+		interp.store(target.Slot, target.Name, value)
+	}
+	return interp.executeBlock(c.Body)
+}
+
+func (interp *interpreter) send(pos Position, c *Channel, value Value) {
+	interp.block(pos, []reflect.SelectCase{{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(c.ch),
+		Send: reflect.ValueOf(&value).Elem(),
+	}})
+}
+
+func (interp *interpreter) recv(pos Position, c *Channel) Value {
+	_, recv, ok := interp.block(pos, []reflect.SelectCase{{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(c.ch),
+	}})
+	if !ok {
+		return Value(nil)
+	}
+	return recv.Interface()
+}
+
+func (wg *WaitGroup) add(pos Position, delta int) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	if wg.count+delta < 0 {
+		panic(valueError(pos, "negative wait group counter"))
+	}
+	if wg.waiters == nil {
+		wg.waiters = make(chan struct{})
+	}
+	wg.count += delta
+	if wg.count == 0 {
+		close(wg.waiters)
+		wg.waiters = nil
+	}
+}
+
+func (interp *interpreter) wait(pos Position, wg *WaitGroup) {
+	wg.mu.Lock()
+	waiters := wg.waiters
+	wg.mu.Unlock()
+	if waiters == nil {
+		return
+	}
+	interp.block(pos, []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(waiters)}})
+}
+
+func (interp *interpreter) lock(pos Position, m *Mutex) {
+	interp.block(pos, []reflect.SelectCase{{
+		Dir:  reflect.SelectSend,
+		Chan: reflect.ValueOf(m.ch),
+		Send: reflect.ValueOf(struct{}{}),
+	}})
+}
+
+func (m *Mutex) unlock(pos Position) {
+	select {
+	case <-m.ch:
+	default:
+		panic(runtimeError(pos, "unlock of unlocked mutex"))
+	}
+}
+
+func (c *Channel) close(pos Position) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(runtimeError(pos, "close of closed channel"))
+		}
+	}()
+	close(c.ch)
+}
+
+func (c *Channel) String() string {
+	return fmt.Sprintf("<channel %d/%d>", len(c.ch), cap(c.ch))
+}
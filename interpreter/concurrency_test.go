@@ -0,0 +1,56 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"testing"
+)
+
+func TestSendOnClosedChannel(t *testing.T) {
+	tests := []string{
+		`send($ch, 1)`,
+		`select { case send($ch, 1) { } }`,
+		`select { case send($ch, 1) { } default { } }`,
+	}
+	for _, test := range tests {
+		source := "$ch = channel(1); close($ch);\n" +
+			"echo(assertThrows(function() { " + test + "; }, \"send on closed channel\"));\n" +
+			test + ";"
+		prog, err := parser.ParseProgram([]byte(source))
+		if err != nil {
+			t.Fatalf("%s: %v", test, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			var out bytes.Buffer
+			_, err := execute(prog, &Config{Stdout: &out}, compiled)
+			if out.String() != "runtime error at 2:32: send on closed channel\n" {
+				t.Errorf("%s (compiled %v): expected the error to be caught, got output %q", test, compiled, out.String())
+			}
+			if e, ok := err.(RuntimeError); !ok || e.Error() != "runtime error at 3:1: send on closed channel" {
+				t.Errorf("%s (compiled %v): expected send on closed channel error, got %v", test, compiled, err)
+			}
+		}
+	}
+
+	// The session is still usable, with its lock released, after the error
+	session := New(&Config{}).NewSession()
+	prog, err := parser.ParseProgram([]byte(`$ch = channel(1); close($ch); send($ch, 1);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Execute(prog); err == nil {
+		t.Fatal("expected an error")
+	}
+	prog, err = parser.ParseProgram([]byte(`$x = 1 + 2;`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Execute(prog); err != nil {
+		t.Fatalf("expected no error after the send error, got %v", err)
+	}
+	if x, _ := session.Get("x"); x != 3 {
+		t.Errorf("expected $x to be 3, got %v", x)
+	}
+}
@@ -0,0 +1,233 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"sort"
+	"sync"
+)
+
+// Coverage records how many times each statement of the programs run with
+// it executes, and which way each if and while condition goes, for code
+// coverage reports. Set Config.Coverage to record coverage. Programs run
+// with a Coverage are run by the tree-walking evaluator, like programs run
+// with a Debugger.
+//
+// A Coverage can be shared by several interpreters, even at the same
+// time, so the coverage of a set of tests that each run in a fresh
+// interpreter adds up.
+type Coverage struct {
+	mu         sync.Mutex
+	programs   map[*parser.Program]bool
+	statements []parser.Statement // in the order they were added
+	counts     map[parser.Statement]*StatementCoverage
+}
+
+// StatementCoverage is the coverage of one statement.
+type StatementCoverage struct {
+	Statement parser.Statement
+	Count     int // times the statement was executed
+
+	// For if and while statements, the times the condition was true and
+	// the times it was false
+	True, False int
+}
+
+// Branches reports whether the statement is an if or while statement,
+// whose condition has a true and a false branch.
+func (s *StatementCoverage) Branches() bool {
+	switch s.Statement.(type) {
+	case *parser.If, *parser.While:
+		return true
+	}
+	return false
+}
+
+// NewCoverage returns a new Coverage that hasn't recorded anything yet.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		programs: make(map[*parser.Program]bool),
+		counts:   make(map[parser.Statement]*StatementCoverage),
+	}
+}
+
+// add adds the statements of prog to the coverage, so ones that are never
+// executed are reported with a count of zero.
+func (c *Coverage) add(prog *parser.Program) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.programs[prog] {
+		return
+	}
+	c.programs[prog] = true
+	c.block(prog.Statements)
+}
+
+func (c *Coverage) block(block parser.Block) {
+	for _, s := range block {
+		c.statement(s)
+	}
+}
+
+func (c *Coverage) statement(s parser.Statement) {
+	if isSemiTag(s) {
+		return
+	}
+	if _, ok := c.counts[s]; !ok {
+		c.statements = append(c.statements, s)
+		c.counts[s] = &StatementCoverage{Statement: s}
+	}
+	switch s := s.(type) {
+	case *parser.Assign:
+		c.expression(s.Target)
+		c.expression(s.Value)
+	case *parser.OuterAssign:
+		c.expression(s.Value)
+	case *parser.If:
+		c.expression(s.Condition)
+		c.block(s.Body)
+		c.block(s.Else)
+	case *parser.While:
+		c.expression(s.Condition)
+		c.block(s.Body)
+	case *parser.For:
+		c.expression(s.Iterable)
+		c.block(s.Body)
+	case *parser.Return:
+		c.expression(s.Result)
+	case *parser.ExpressionStatement:
+		c.expression(s.Expression)
+	case *parser.FunctionDefinition:
+		c.block(s.Body)
+	case *parser.Spawn:
+		c.expression(s.Call)
+	case *parser.Select:
+		for _, sc := range s.Cases {
+			c.expression(sc.Target)
+			c.expression(sc.Channel)
+			c.expression(sc.Value)
+			c.block(sc.Body)
+		}
+		c.block(s.Default)
+	case *parser.ClassDefinition:
+		c.block(s.Body)
+	}
+}
+
+// expression adds the statements in the bodies of the function
+// expressions in e.
+func (c *Coverage) expression(e parser.Expression) {
+	switch e := e.(type) {
+	case *parser.Binary:
+		c.expression(e.Left)
+		c.expression(e.Right)
+	case *parser.Unary:
+		c.expression(e.Operand)
+	case *parser.Call:
+		c.expression(e.Function)
+		c.expressions(e.Arguments)
+	case *parser.List:
+		c.expressions(e.Values)
+	case *parser.Map:
+		for _, item := range e.Items {
+			c.expression(item.Key)
+			c.expression(item.Value)
+		}
+	case *parser.PropertyAccess:
+		c.expression(e.Object)
+	case *parser.MethodCall:
+		c.expression(e.Object)
+		c.expressions(e.Arguments)
+	case *parser.FunctionExpression:
+		c.block(e.Body)
+	case *parser.Await:
+		c.expression(e.Value)
+	case *parser.Subscript:
+		c.expression(e.Container)
+		c.expression(e.Subscript)
+	}
+}
+
+func (c *Coverage) expressions(exprs []parser.Expression) {
+	for _, e := range exprs {
+		c.expression(e)
+	}
+}
+
+// executed records that s was executed.
+func (c *Coverage) executed(s parser.Statement) {
+	if isSemiTag(s) {
+		return
+	}
+	c.mu.Lock()
+	c.get(s).Count++
+	c.mu.Unlock()
+}
+
+// branch records which way the condition of the if or while statement s
+// went.
+func (c *Coverage) branch(s parser.Statement, cond bool) {
+	c.mu.Lock()
+	if cond {
+		c.get(s).True++
+	} else {
+		c.get(s).False++
+	}
+	c.mu.Unlock()
+}
+
+// get returns the coverage of s, adding s if it's from a program that
+// wasn't added (such as an expression passed to Evaluate).
+func (c *Coverage) get(s parser.Statement) *StatementCoverage {
+	sc, ok := c.counts[s]
+	if !ok {
+		c.statements = append(c.statements, s)
+		sc = &StatementCoverage{Statement: s}
+		c.counts[s] = sc
+	}
+	return sc
+}
+
+// Files returns the names of the files with statements in the coverage,
+// in sorted order. Programs parsed without a file name have the name "".
+func (c *Coverage) Files() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, s := range c.statements {
+		if file := s.Position().File; !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Statements returns the coverage of each statement in the named file, in
+// order of position.
+func (c *Coverage) Statements(file string) []StatementCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statements := []StatementCoverage{}
+	for _, s := range c.statements {
+		if s.Position().File == file {
+			statements = append(statements, *c.counts[s])
+		}
+	}
+	sort.SliceStable(statements, func(i, j int) bool {
+		return before(statements[i].Statement.Position(), statements[j].Statement.Position())
+	})
+	return statements
+}
+
+// before reports whether position a comes before position b.
+func before(a, b Position) bool {
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Column < b.Column
+}
@@ -0,0 +1,69 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	source := `
+function f($n) {
+    if ($n > 0) {
+        return "positive";
+    }
+    $i = 0;
+    while ($i < 2) { $i = $i + 1; }
+    return "other";
+}
+$g = function() { return 1; };
+f(1);
+f(0);
+`
+	prog, err := parser.ParseFile("c.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	coverage := NewCoverage()
+	var out bytes.Buffer
+	if _, err := Execute(prog, &Config{Stdout: &out, Coverage: coverage}); err != nil {
+		t.Fatal(err)
+	}
+	// Running the program again adds to the counts
+	session := New(&Config{Stdout: &out, Coverage: coverage}).NewSession()
+	if err := session.Execute(prog); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := coverage.Files(); len(files) != 1 || files[0] != "c.davi" {
+		t.Fatalf("expected files [c.davi], got %v", files)
+	}
+	type count struct {
+		line, count, true, false int
+	}
+	expected := []count{
+		{2, 2, 0, 0},  // function f
+		{3, 4, 2, 2},  // if
+		{4, 2, 0, 0},  // return "positive"
+		{6, 2, 0, 0},  // $i = 0
+		{7, 2, 4, 2},  // while
+		{7, 4, 0, 0},  // $i = $i + 1
+		{8, 2, 0, 0},  // return "other"
+		{10, 2, 0, 0}, // $g = ...
+		{10, 0, 0, 0}, // return 1, never called
+		{11, 2, 0, 0},
+		{12, 2, 0, 0},
+	}
+	statements := coverage.Statements("c.davi")
+	if len(statements) != len(expected) {
+		t.Fatalf("expected %d statements, got %d", len(expected), len(statements))
+	}
+	for i, s := range statements {
+		got := count{s.Statement.Position().Line, s.Count, s.True, s.False}
+		if got != expected[i] {
+			t.Errorf("statement %d: expected %v, got %v", i, expected[i], got)
+		}
+	}
+}
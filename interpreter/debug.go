@@ -0,0 +1,123 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+)
+
+// frame is a user function call on the interpreter's call stack.
+type frame struct {
+	function string
+	call     Position // where the function was called from
+	env      *environment
+}
+
+// DebugState gives a debugger access to a stopped interpreter. It's passed
+// to Config.Debugger before each statement is executed and is only valid
+// until the Debugger call returns.
+type DebugState struct {
+	// Position is the position of the statement about to be executed.
+	Position Position
+
+	interp *interpreter
+}
+
+// StackFrame is one call in the call stack returned by DebugState.Stack.
+type StackFrame struct {
+	Function string
+	Position Position
+}
+
+// Scope is a named set of variables returned by DebugState.Scopes.
+type Scope struct {
+	Name string
+	Vars map[string]Value
+}
+
+func (interp *interpreter) debug(s parser.Statement) {
+	debugger := interp.debugger
+	// Don't stop inside expressions evaluated by the debugger itself
+	interp.debugger = nil
+	defer func() { interp.debugger = debugger }()
+	debugger(&DebugState{s.Position(), interp})
+}
+
+// Depth returns the number of user function calls on the call stack, 0 at
+// the top level of the program.
+func (s *DebugState) Depth() int {
+	return len(s.interp.frames)
+}
+
+// Stack returns the call stack, innermost call first. The last frame is
+// the top level of the program, named "main".
+func (s *DebugState) Stack() []StackFrame {
+	frames := s.interp.frames
+	stack := make([]StackFrame, 0, len(frames)+1)
+	pos := s.Position
+	for i := len(frames) - 1; i >= 0; i-- {
+		name := frames[i].function
+		if name == "" {
+			name = "<anonymous>"
+		}
+		stack = append(stack, StackFrame{name, pos})
+		pos = frames[i].call
+	}
+	return append(stack, StackFrame{"main", pos})
+}
+
+// Scopes returns the variables visible in the given frame of the call stack
+// (0 is the innermost), innermost scope first. Builtin functions are left
+// out of the globals.
+func (s *DebugState) Scopes(frame int) []Scope {
+	frames := s.interp.frames
+	scopes := []Scope{}
+	if frame < len(frames) {
+		env := frames[len(frames)-1-frame].env
+		locals := make(map[string]Value)
+		for i, name := range env.names {
+			if env.slots[i] != undefined {
+				locals[name] = env.slots[i]
+			}
+		}
+		scopes = append(scopes, Scope{"Locals", locals})
+	}
+	globals := make(map[string]Value)
+	for name, value := range s.interp.globals {
+		if b, ok := value.(builtinFunction); ok && b.Name == name {
+			continue
+		}
+		globals[name] = value
+	}
+	return append(scopes, Scope{"Globals", globals})
+}
+
+// Evaluate parses and evaluates an expression in the scope of the statement
+// about to be executed, returning an error on a parse or runtime error.
+func (s *DebugState) Evaluate(source string) (v Value, err error) {
+	expr, err := parser.ParseExpression([]byte(source))
+	if err != nil {
+		return nil, err
+	}
+	interp := s.interp
+	locals := [][]string{}
+	for env := interp.env; env != nil; env = env.parent {
+		locals = append(locals, env.names)
+	}
+	parser.ResolveExpression(expr, locals...)
+	raising := interp.raising
+	defer func() {
+		if r := recover(); r != nil {
+			e := interp.spanError(r)
+			interp.raising = raising
+			switch e := e.(type) {
+			case Error:
+				err = e
+			default:
+				panic(r)
+			}
+		}
+	}()
+	return interp.evaluate(expr), nil
+}
@@ -1,14 +1,15 @@
 package interpreter
 
 import (
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"github.com/DavinciScript/Davi/interpreter/functions"
 	goParser "go/parser"
 	goToken "go/token"
-	"io"
 	"os"
 	"slices"
+	"sort"
 	"strings"
 )
 
@@ -53,15 +54,21 @@ func GenerateDocs() {
 	// write the markdown content
 	markdownContent := "# Functions \n\n"
 
+	// sort the categories and functions so the generated files only
+	// change when the docs do
 	functionsCategories := []string{}
 	functionDetails := GetFunctionsDetails()
-	for _, f := range functionDetails {
+	functionNames := []string{}
+	for name, f := range functionDetails {
+		functionNames = append(functionNames, name)
 		if f.category != "" {
 			if !slices.Contains(functionsCategories, f.category) {
 				functionsCategories = append(functionsCategories, f.category)
 			}
 		}
 	}
+	sort.Strings(functionsCategories)
+	sort.Strings(functionNames)
 
 	jsonContent := make(map[string]interface{})
 	if len(functionsCategories) > 0 {
@@ -73,8 +80,8 @@ func GenerateDocs() {
 
 			jsonContent[category] = make(map[string]interface{})
 
-			for _, f := range functionDetails {
-				if f.category == category {
+			for _, name := range functionNames {
+				if f := functionDetails[name]; f.category == category {
 
 					// put f.functionName on jsonContent[category] on first level
 					jsonContent[category].(map[string]interface{})[f.functionName] = map[string]interface{}{
@@ -94,12 +101,18 @@ func GenerateDocs() {
 					markdownContent += f.description + "\n\n"
 					markdownContent += "#### Example\n\n"
 					markdownContent += "```php\n"
+					if f.setup != "" {
+						markdownContent += f.setup + "\n"
+					}
 					markdownContent += f.example + "\n\n"
 					markdownContent += "// output: " + f.output + "\n"
 					markdownContent += "```\n\n"
 
 					variable := "$call" + functions.UpWords(f.functionName)
 					daviContent += "// " + f.title + "\n"
+					if f.setup != "" {
+						daviContent += f.setup + "\n"
+					}
 					daviContent += variable + " = " + f.example + "\n\n"
 					daviContent += "// must output: " + f.output + "\n\n"
 					daviContent += "echo(" + variable + ")\n\n"
@@ -130,39 +143,63 @@ func GenerateDocs() {
 
 }
 
-func GetFunctionsDetails() map[string]functionDetails {
-
-	// all functions file path
-	allFunctionsFile := "interpreter/functions.go"
+// functionsSource and assertSource are the source of functions.go and
+// assert.go, whose doc comments describe the builtin functions. They're
+// embedded so that the docs are available wherever davi is run from.
+//
+//go:embed functions.go
+var functionsSource string
 
-	// open the file
-	file, err := os.Open(allFunctionsFile)
-	if err != nil {
-		fmt.Println(err)
-	}
-	// get content of the file
-	content, err := io.ReadAll(file)
-	if err != nil {
-		fmt.Println(err)
-	}
-	contentString := string(content)
+//go:embed assert.go
+var assertSource string
 
-	fs := goToken.NewFileSet()
-	f, err := goParser.ParseFile(fs, "", contentString, goParser.ParseComments)
-	if err != nil {
-		fmt.Println(err)
-		return nil
-	}
+func GetFunctionsDetails() map[string]functionDetails {
 
 	allFunctionsDetails := make(map[string]functionDetails)
-	for _, c := range f.Comments {
-		parsedComment := ParseComment(c.Text())
-		allFunctionsDetails[parsedComment.functionName] = parsedComment
+	for _, source := range []string{functionsSource, assertSource} {
+		fs := goToken.NewFileSet()
+		f, err := goParser.ParseFile(fs, "", source, goParser.ParseComments)
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		for _, c := range f.Comments {
+			parsedComment := ParseComment(c.Text())
+			allFunctionsDetails[parsedComment.functionName] = parsedComment
+		}
 	}
 
 	return allFunctionsDetails
 }
 
+// FunctionDoc is the documentation of a builtin function.
+type FunctionDoc struct {
+	Name        string
+	Args        string
+	Return      string
+	Example     string
+	Output      string
+	Description string
+	Title       string
+	Category    string
+	Setup       string // code to run before the example
+	Check       string // how the example is checked, as for CheckExamples
+}
+
+// FunctionDocs returns the documentation of every documented builtin
+// function, keyed by function name.
+func FunctionDocs() map[string]FunctionDoc {
+	docs := make(map[string]FunctionDoc)
+	for name, f := range GetFunctionsDetails() {
+		if name == "" {
+			continue
+		}
+		docs[name] = FunctionDoc{f.functionName, f.args, f.returnValue, f.example,
+			f.output, f.description, f.title, f.category, f.setup, f.check}
+	}
+	return docs
+}
+
 type functionDetails struct {
 	functionName string
 	args         string
@@ -172,6 +209,8 @@ type functionDetails struct {
 	description  string
 	title        string
 	category     string
+	setup        string
+	check        string
 }
 
 func ParseComment(comment string) functionDetails {
@@ -179,59 +218,40 @@ func ParseComment(comment string) functionDetails {
 	 * function: httpRegister
 	 * args: pattern, handler
 	 * return: nil
-	 * example: httpRegister("/", func() { return "Hello, World!" })
+	 * example: httpRegister("/", function() { return "Hello, World!"; })
 	 * output: "Hello, World!"
 	 * description: Register a handler function for a URL pattern.
 	 * title: HTTP Register
 	 * category: HTTP
 	 */
 
-	functionName := ""
-	args := ""
-	returnValue := ""
-	example := ""
-	output := ""
-	description := ""
-	title := ""
-	category := ""
+	details := functionDetails{}
+	fields := map[string]*string{
+		"function":    &details.functionName,
+		"args":        &details.args,
+		"return":      &details.returnValue,
+		"example":     &details.example,
+		"output":      &details.output,
+		"description": &details.description,
+		"title":       &details.title,
+		"category":    &details.category,
+		"setup":       &details.setup,
+		"check":       &details.check,
+	}
 
-	// split the comment by new line
+	// split the comment by new line, and each line into the field name
+	// and its value at the first colon, as values may contain colons
 	commentLines := strings.Split(comment, "\n")
 	for _, line := range commentLines {
-		if strings.Contains(line, "function:") {
-			functionName = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "args:") {
-			args = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "return:") {
-			returnValue = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "example:") {
-			example = strings.TrimSpace(strings.Split(line, ":")[1])
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
 		}
-		if strings.Contains(line, "output:") {
-			output = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "description:") {
-			description = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "title:") {
-			title = strings.TrimSpace(strings.Split(line, ":")[1])
-		}
-		if strings.Contains(line, "category:") {
-			category = strings.TrimSpace(strings.Split(line, ":")[1])
+		name := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(parts[0]), "*"))
+		if field, ok := fields[name]; ok {
+			*field = strings.TrimSpace(parts[1])
 		}
 	}
 
-	return functionDetails{
-		functionName: functionName,
-		args:         args,
-		returnValue:  returnValue,
-		example:      example,
-		output:       output,
-		description:  description,
-		title:        title,
-		category:     category,
-	}
+	return details
 }
@@ -0,0 +1,96 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+)
+
+// Interpreter runs Davi programs that can use Go functions and values
+// registered by the Go program embedding it. Go values are converted to
+// Davi values and back automatically:
+//
+//	Go                          Davi
+//	bool                        bool
+//	int and uint types          int
+//	float32, float64            int (if it's a whole number)
+//	string, []byte              str
+//	slice, array                list
+//	map with str or int keys    map
+//	struct                      map of its exported fields (or object)
+//	pointer                     the value it points to, or nil
+//	func                        function
+//	*Function                   function (Davi to Go only)
+//	error                       str of its message (Go to Davi only), but
+//	                            one a function returns last is raised
+//	interface{}                 any value
+//
+// Conversions that overflow the Go or Davi type are type errors. Struct
+// fields are named as in Go, unless they have a `davi:"name"` tag. A Go
+// function that panics stops the program with a RuntimeError.
+type Interpreter struct {
+	config Config
+	vars   map[string]Value
+}
+
+// New returns an Interpreter configured by config, which may be nil. The
+// config's Vars are available to programs along with the registered
+// functions and values.
+func New(config *Config) *Interpreter {
+	i := &Interpreter{vars: make(map[string]Value)}
+	if config != nil {
+		i.config = *config
+	}
+	for name, v := range i.config.Vars {
+		i.vars[name] = v
+	}
+	i.config.Vars = i.vars
+	return i
+}
+
+// RegisterFunc makes the Go function f available to programs as a Davi
+// function called name. f may be variadic, and may return nothing, a
+// value, an error, or a value and an error. A non-nil error stops the
+// program with a RuntimeError. A Davi function passed to a parameter with
+// a func type is converted to a Go function that calls it; if that func
+// type returns an error last, errors from the Davi function are returned
+// as it.
+func (i *Interpreter) RegisterFunc(name string, f interface{}) error {
+	v := reflect.ValueOf(f)
+	if !v.IsValid() {
+		return fmt.Errorf("can't register nil as %s", name)
+	}
+	if err := checkNativeFunction(v); err != nil {
+		return fmt.Errorf("can't register %s: %v", name, err)
+	}
+	i.vars[name] = nativeFunction{v, name}
+	return nil
+}
+
+// Set makes the Go value v available to programs as the variable name.
+func (i *Interpreter) Set(name string, v interface{}) error {
+	value, err := fromGo(reflect.ValueOf(v))
+	if err != nil {
+		return fmt.Errorf("can't set %s: %v", name, err)
+	}
+	i.vars[name] = value
+	return nil
+}
+
+// Execute runs prog like the Execute function, with the registered
+// functions and values. Each call runs in a new global scope; use a
+// Session to keep globals between runs.
+func (i *Interpreter) Execute(prog *parser.Program) (*Stats, error) {
+	return Execute(prog, &i.config)
+}
+
+// Evaluate evaluates expr like the Evaluate function, with the registered
+// functions and values, and returns its value converted to a Go value as
+// for an interface{} parameter. Functions in the value can't be called, as
+// the evaluation has finished.
+func (i *Interpreter) Evaluate(expr parser.Expression) (interface{}, *Stats, error) {
+	v, stats, err := Evaluate(expr, &i.config)
+	return toGoInterface(nil, expr.Position(), v), stats, err
+}
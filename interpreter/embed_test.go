@@ -0,0 +1,149 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y  int
+	Label string `davi:"label"`
+	note  string
+}
+
+func newTestInterpreter(t *testing.T, out *bytes.Buffer) *Interpreter {
+	i := New(&Config{Stdout: out, Vars: map[string]Value{"fromConfig": 42}})
+	funcs := map[string]interface{}{
+		"add": func(a, b int) int { return a + b },
+		"sum": func(prefix string, ns ...int) string {
+			t := 0
+			for _, n := range ns {
+				t += n
+			}
+			return fmt.Sprint(prefix, t)
+		},
+		"not":  func(b bool) bool { return !b },
+		"keys": func(m map[string]int) int { return len(m) },
+		"swap": func(p point) point { return point{X: p.Y, Y: p.X, Label: p.Label + "!"} },
+		"fail": func(msg string) (int, error) { return 0, errors.New(msg) },
+		"check": func(n int) error {
+			if n < 0 {
+				return errors.New("negative")
+			}
+			return nil
+		},
+		"apply":  func(f func(int) int, n int) int { return f(f(n)) },
+		"safely": func(f func() (interface{}, error)) string { _, err := f(); return fmt.Sprint(err != nil) },
+		"each": func(list []interface{}, f *Function) (int, error) {
+			for _, item := range list {
+				if _, err := f.Call(item); err != nil {
+					return 0, err
+				}
+			}
+			return len(list), nil
+		},
+		"describe": func(v interface{}) string { return fmt.Sprintf("%T", v) },
+		"maker":    func() func(string) string { return strings.ToUpper },
+	}
+	for name, f := range funcs {
+		if err := i.RegisterFunc(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := i.Set("origin", point{Label: "origin"}); err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestEmbedding(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		{`echo(add(1, 2), fromConfig)`, "3 42\n", ""},
+		{`echo(sum("total "), sum("total ", 1, 2, 3))`, "total 0 total 6\n", ""},
+		{`echo(not(false))`, "true\n", ""},
+		{`echo(keys({"a": 1, "b": 2}))`, "2\n", ""},
+		{`$p = swap({"X": 1, "Y": 2, "label": "p"}); echo($p["X"], $p["Y"], $p["label"])`, "2 1 p!\n", ""},
+		{`echo(origin["label"], len(origin))`, "origin 3\n", ""},
+		{`echo(apply(function($n) { return $n * 3; }, 2))`, "18\n", ""},
+		{`each([1, 2], function($x) { echo("item", $x); })`, "item 1\nitem 2\n", ""},
+		{`echo(safely(function() { return 1 + "a"; }))`, "true\n", ""},
+		{`echo(describe([1]), describe({"a": 1}), describe(nil), describe(add))`, "[]interface {} map[string]interface {} <nil> *interpreter.Function\n", ""},
+		{`$upper = maker(); echo($upper("abc"))`, "ABC\n", ""},
+		{`check(1); echo("ok")`, "ok\n", ""},
+		{`check(-1)`, "", "runtime error at 1:1: check() error: negative"},
+		{`fail("oops")`, "", "runtime error at 1:1: fail() error: oops"},
+		{`add(1)`, "", "add() requires 2 args, got 1"},
+		{`add(1, "2")`, "", "type error at 1:1: add() argument 2: can't convert str to Go int"},
+		{`keys({"a": "b"})`, "", `keys() argument 1: key "a": can't convert str to Go int`},
+		{`swap({"Z": 1})`, "", `swap() argument 1: interpreter.point has no field "Z"`},
+		{`each([1], function($x) { return $x + "a"; })`, "", "type error at 1:36: + requires two ints, strs, lists, or maps"},
+		{`apply(function($n) { return "x"; }, 1)`, "", "result of Go callback: can't convert str to Go int"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		i := newTestInterpreter(t, &out)
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		_, err = i.Execute(prog)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.source, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.source, test.err, err)
+		}
+		if out.String() != test.output {
+			t.Errorf("%s: expected output %q, got %q", test.source, test.output, out.String())
+		}
+	}
+}
+
+func TestEmbeddingEvaluate(t *testing.T) {
+	i := newTestInterpreter(t, &bytes.Buffer{})
+	expr, err := parser.ParseExpression([]byte(`[add(1, 2), {"x": not(true)}, "s"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := i.Evaluate(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{3, map[string]interface{}{"x": false}, "s"}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %#v, got %#v", expected, v)
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	i := New(nil)
+	tests := []struct {
+		f   interface{}
+		err string
+	}{
+		{nil, "can't register nil as f"},
+		{42, "can't register f: int is not a function"},
+		{func() (int, int) { return 1, 2 }, "the second isn't an error"},
+		{func() (int, int, error) { return 1, 2, nil }, "returns more than 2 results"},
+	}
+	for _, test := range tests {
+		err := i.RegisterFunc("f", test.f)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%T: expected error %q, got %v", test.f, test.err, err)
+		}
+	}
+	if err := i.Set("c", make(chan int)); err == nil {
+		t.Errorf("expected error setting a Go channel")
+	}
+}
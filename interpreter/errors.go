@@ -5,84 +5,211 @@ package interpreter
 import (
 	"fmt"
 	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
 )
 
 // Error is the error type returned by Evaluate and Execute. Each error holds
 // the position of the error in the source and the error message, which can be
-// queried on the type or via Error().
+// queried on the type or via Error(). Start and End are the range of source
+// of the expression the error is about, from its first character to just
+// after its last, or zero Positions if it isn't known.
 type Error interface {
 	error
 	Position() Position
+	Start() Position
+	End() Position
 }
 
 // TypeError is returned for invalid types and wrong number of arguments.
 type TypeError struct {
 	Message string
 	pos     Position
+	span    parser.Span
 }
 
 func (e TypeError) Error() string {
-	return fmt.Sprintf("type error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("type error at %s: %s", e.pos, e.Message)
 }
 
 func (e TypeError) Position() Position {
 	return e.pos
 }
 
+func (e TypeError) Start() Position {
+	return e.span.Start
+}
+
+func (e TypeError) End() Position {
+	return e.span.End
+}
+
 func typeError(pos Position, format string, args ...interface{}) error {
-	return TypeError{fmt.Sprintf(format, args...), pos}
+	return TypeError{fmt.Sprintf(format, args...), pos, parser.Span{}}
 }
 
 // ValueError is returned for invalid values (out of bounds index, etc).
 type ValueError struct {
 	Message string
 	pos     Position
+	span    parser.Span
 }
 
 func (e ValueError) Error() string {
-	return fmt.Sprintf("value error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("value error at %s: %s", e.pos, e.Message)
 }
 
 func (e ValueError) Position() Position {
 	return e.pos
 }
 
+func (e ValueError) Start() Position {
+	return e.span.Start
+}
+
+func (e ValueError) End() Position {
+	return e.span.End
+}
+
 func valueError(pos Position, format string, args ...interface{}) error {
-	return ValueError{fmt.Sprintf(format, args...), pos}
+	return ValueError{fmt.Sprintf(format, args...), pos, parser.Span{}}
 }
 
 // NameError is returned when a variable is not found.
 type NameError struct {
 	Message string
 	pos     Position
+	span    parser.Span
 }
 
 func (e NameError) Error() string {
-	return fmt.Sprintf("name error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("name error at %s: %s", e.pos, e.Message)
 }
 
 func (e NameError) Position() Position {
 	return e.pos
 }
 
+func (e NameError) Start() Position {
+	return e.span.Start
+}
+
+func (e NameError) End() Position {
+	return e.span.End
+}
+
 func nameError(pos Position, format string, args ...interface{}) error {
-	return NameError{fmt.Sprintf(format, args...), pos}
+	return NameError{fmt.Sprintf(format, args...), pos, parser.Span{}}
 }
 
 // RuntimeError is returned for other or internal runtime errors.
 type RuntimeError struct {
 	Message string
 	pos     Position
+	span    parser.Span
 }
 
 func (e RuntimeError) Error() string {
-	return fmt.Sprintf("runtime error at %d:%d: %s", e.pos.Line, e.pos.Column, e.Message)
+	return fmt.Sprintf("runtime error at %s: %s", e.pos, e.Message)
 }
 
 func (e RuntimeError) Position() Position {
 	return e.pos
 }
 
+func (e RuntimeError) Start() Position {
+	return e.span.Start
+}
+
+func (e RuntimeError) End() Position {
+	return e.span.End
+}
+
 func runtimeError(pos Position, format string, args ...interface{}) error {
-	return RuntimeError{fmt.Sprintf(format, args...), pos}
+	return RuntimeError{fmt.Sprintf(format, args...), pos, parser.Span{}}
+}
+
+// AssertionError is returned when an assert(), assertEquals() or
+// assertThrows() assertion fails.
+type AssertionError struct {
+	Message string
+	pos     Position
+	span    parser.Span
+}
+
+func (e AssertionError) Error() string {
+	return fmt.Sprintf("assertion failed at %s: %s", e.pos, e.Message)
+}
+
+func (e AssertionError) Position() Position {
+	return e.pos
+}
+
+func (e AssertionError) Start() Position {
+	return e.span.Start
+}
+
+func (e AssertionError) End() Position {
+	return e.span.End
+}
+
+func assertionError(pos Position, format string, args ...interface{}) error {
+	return AssertionError{fmt.Sprintf(format, args...), pos, parser.Span{}}
+}
+
+// errorPosition returns the position of the errors expr raises, which for
+// a call is that of the function called and for a subscript that of the
+// subscript.
+func errorPosition(expr parser.Expression) Position {
+	switch e := expr.(type) {
+	case *parser.Call:
+		return e.Function.Position()
+	case *parser.Subscript:
+		return e.Subscript.Position()
+	}
+	return expr.Position()
+}
+
+// spanError returns r, a value interp is panicking with, with the span of
+// interp.raising if it's an Error raised at that expression's position, as
+// in withSpan.
+func (interp *interpreter) spanError(r interface{}) interface{} {
+	e := interp.raising
+	if e == nil {
+		return r
+	}
+	return withSpan(r, errorPosition(e), e.Span())
+}
+
+// withSpan returns r, a value being panicked with, with its span set to
+// span if it's an Error at pos without one, so the error is about the
+// expression at pos that raised it. Other values are returned as they are.
+func withSpan(r interface{}, pos Position, span parser.Span) interface{} {
+	switch e := r.(type) {
+	case TypeError:
+		if e.pos == pos && e.span == (parser.Span{}) {
+			e.span = span
+		}
+		return e
+	case ValueError:
+		if e.pos == pos && e.span == (parser.Span{}) {
+			e.span = span
+		}
+		return e
+	case NameError:
+		if e.pos == pos && e.span == (parser.Span{}) {
+			e.span = span
+		}
+		return e
+	case RuntimeError:
+		if e.pos == pos && e.span == (parser.Span{}) {
+			e.span = span
+		}
+		return e
+	case AssertionError:
+		if e.pos == pos && e.span == (parser.Span{}) {
+			e.span = span
+		}
+		return e
+	}
+	return r
 }
@@ -0,0 +1,153 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long a documented example may run before it's stopped.
+const exampleTimeout = 5 * time.Second
+
+// ExampleResult is the result of checking the example in a builtin
+// function's documentation.
+type ExampleResult struct {
+	Doc     FunctionDoc
+	Output  string // what the example actually output
+	Err     error  // error running the example, if any
+	Skipped bool   // true if the example isn't checked
+}
+
+// Passed reports whether the example ran and output what its
+// documentation says it does.
+func (r ExampleResult) Passed() bool {
+	if r.Skipped {
+		return true
+	}
+	if r.Err != nil {
+		return false
+	}
+	if r.Doc.Check == "format" {
+		return sameFormat(r.Doc.Output, r.Output)
+	}
+	return r.Output == r.Doc.Output
+}
+
+// CheckExamples runs the example of each documented builtin function, in
+// order of function name, so tests can catch documentation that no longer
+// matches the implementation. Each example runs in a fresh interpreter,
+// after the code in its "setup" field if it has one.
+//
+// The output of an example is what it prints if it prints anything (as
+// for echo()), "exit status N" if it calls exit(N), and otherwise its
+// value as the REPL shows it, with strings quoted. An example's "check"
+// field says how its output is compared with the documented output:
+//
+//	(none)   the output must be the same
+//	format   the output must be the same except that each digit may be
+//	         any digit, for examples whose value changes
+//	skip     the example isn't run, as it needs the network, files or
+//	         standard input, has side effects outside the interpreter,
+//	         or doesn't output what its documentation says yet
+func CheckExamples() []ExampleResult {
+	docs := FunctionDocs()
+	names := make([]string, 0, len(docs))
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]ExampleResult, len(names))
+	for i, name := range names {
+		doc := docs[name]
+		results[i] = ExampleResult{Doc: doc}
+		if doc.Check == "skip" {
+			results[i].Skipped = true
+			continue
+		}
+		results[i].Output, results[i].Err = runExample(doc)
+	}
+	return results
+}
+
+// exampleExit is panicked with by exit() in an example.
+type exampleExit int
+
+// runExample runs the setup and example of doc, returning the example's
+// output as described on CheckExamples.
+func runExample(doc FunctionDoc) (output string, err error) {
+	setup, err := parser.ParseProgram([]byte(doc.Setup))
+	if err != nil {
+		return "", fmt.Errorf("can't parse setup: %v", err)
+	}
+	prog, err := parser.ParseProgram([]byte(doc.Example))
+	if err != nil {
+		return "", fmt.Errorf("can't parse example: %v", err)
+	}
+	if len(prog.Statements) != 1 {
+		return "", fmt.Errorf("example isn't an expression")
+	}
+	statement, ok := prog.Statements[0].(*parser.ExpressionStatement)
+	if !ok {
+		return "", fmt.Errorf("example isn't an expression")
+	}
+
+	var out bytes.Buffer
+	config := &Config{
+		Stdout:  &out,
+		Exit:    func(code int) { panic(exampleExit(code)) },
+		Timeout: exampleTimeout,
+	}
+	session := New(config).NewSession()
+	defer func() {
+		if r := recover(); r != nil {
+			code, ok := r.(exampleExit)
+			if !ok {
+				panic(r)
+			}
+			output = fmt.Sprintf("exit status %d", int(code))
+		}
+	}()
+	if err := session.Execute(setup); err != nil {
+		return "", fmt.Errorf("setup failed: %v", err)
+	}
+	out.Reset()
+	var value Value
+	err = session.interp.runTopLevel(func() {
+		interp := session.interp
+		value = interp.await(Position{}, interp.evaluate(statement.Expression))
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.Len() > 0 {
+		return strings.TrimSuffix(out.String(), "\n"), nil
+	}
+	return toString(value, true), nil
+}
+
+// sameFormat reports whether s is the same as format, except that each
+// digit in format may be any digit in s.
+func sameFormat(format, s string) bool {
+	if len(format) != len(s) {
+		return false
+	}
+	for i := 0; i < len(format); i++ {
+		if isDigit(format[i]) && isDigit(s[i]) {
+			continue
+		}
+		if format[i] != s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,46 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"testing"
+)
+
+// TestExamples fails if the documentation of a builtin function no longer
+// matches what it does.
+func TestExamples(t *testing.T) {
+	for _, r := range CheckExamples() {
+		if !r.Passed() {
+			t.Errorf("%s: example %s: documented output %s, got %s (error %v)",
+				r.Doc.Name, r.Doc.Example, r.Doc.Output, r.Output, r.Err)
+		}
+	}
+}
+
+func TestCheckExample(t *testing.T) {
+	tests := []struct {
+		doc    FunctionDoc
+		output string
+		passed bool
+	}{
+		{FunctionDoc{Example: `upper("a")`, Output: `"A"`}, `"A"`, true},
+		{FunctionDoc{Example: `upper("a")`, Output: `"a"`}, `"A"`, false},
+		{FunctionDoc{Example: `echo("a", 1)`, Output: `a 1`}, `a 1`, true},
+		{FunctionDoc{Example: `exit(3)`, Output: `exit status 3`}, `exit status 3`, true},
+		{FunctionDoc{Example: `recv($ch)`, Output: `1`, Setup: `$ch = channel(1); send($ch, 1)`}, `1`, true},
+		{FunctionDoc{Example: `await sleep(1)`, Output: `nil`}, `nil`, true},
+		{FunctionDoc{Example: `str(12)`, Output: `"34"`, Check: "format"}, `"12"`, true},
+		{FunctionDoc{Example: `str(12)`, Output: `"1x"`, Check: "format"}, `"12"`, false},
+		{FunctionDoc{Example: `len(1)`, Output: `1`}, "", false},
+	}
+	for _, test := range tests {
+		output, err := runExample(test.doc)
+		r := ExampleResult{Doc: test.doc, Output: output, Err: err}
+		if test.output != "" && output != test.output {
+			t.Errorf("%s: expected output %s, got %s (error %v)", test.doc.Example, test.output, output, err)
+		}
+		if r.Passed() != test.passed {
+			t.Errorf("%s: expected passed %v, got %v", test.doc.Example, test.passed, r.Passed())
+		}
+	}
+}
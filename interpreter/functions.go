@@ -27,7 +27,9 @@ type userFunction struct {
 	Parameters []string
 	Ellipsis   bool
 	Body       parser.Block
-	Closure    map[string]Value
+	Closure    *environment
+	Async      bool
+	Locals     []string // from the parser's resolver, parameters first
 }
 
 func ensureNumArgs(pos Position, name string, args []Value, required int) {
@@ -41,39 +43,84 @@ func ensureNumArgs(pos Position, name string, args []Value, required int) {
 }
 
 func (f *userFunction) call(interp *interpreter, pos Position, args []Value) Value {
-	if f.Ellipsis {
-		ellipsisArgs := args[len(f.Parameters)-1:]
-		newArgs := make([]Value, 0, len(f.Parameters)+1)
-		newArgs = append(newArgs, args[:len(f.Parameters)-1]...)
-		args = append(newArgs, Value(&ellipsisArgs))
+	if f.Async {
+		return Value(interp.callAsync(pos, f, args))
 	}
+	return f.callBody(interp, pos, args)
+}
+
+// packEllipsis gathers the arguments for a function's last parameter into
+// a list if it has an ellipsis.
+func packEllipsis(parameters []string, ellipsis bool, args []Value) []Value {
+	if !ellipsis {
+		return args
+	}
+	ellipsisArgs := args[len(parameters)-1:]
+	newArgs := make([]Value, 0, len(parameters)+1)
+	newArgs = append(newArgs, args[:len(parameters)-1]...)
+	return append(newArgs, Value(&ellipsisArgs))
+}
+
+func (f *userFunction) callBody(interp *interpreter, pos Position, args []Value) (result Value) {
+	args = packEllipsis(f.Parameters, f.Ellipsis, args)
 	ensureNumArgs(pos, f.Name, args, len(f.Parameters))
-	interp.pushScope(f.Closure)
-	defer interp.popScope()
-	interp.pushScope(make(map[string]Value))
-	defer interp.popScope()
-	for i, arg := range args {
-		interp.assign(f.Parameters[i], arg)
+	env := newEnvironment(f.Locals, args, f.Closure)
+	interp.enter(pos)
+	if interp.tracer != nil {
+		interp.trace(&TraceEvent{Kind: TraceCall, Position: pos, Callee: traceName(f.Name), Args: args})
+		// Deferred first so it runs once the call's frame is gone
+		defer interp.traceReturn(f.Name, pos, &result)
+	}
+	caller := interp.env
+	interp.env = env
+	interp.frames = append(interp.frames, frame{f.Name, pos, env})
+	if interp.profiler != nil {
+		interp.profileCall(f.Name, pos)
 	}
+	defer func() {
+		if interp.profiler != nil {
+			interp.profileReturn()
+		}
+		interp.env = caller
+		interp.frames = interp.frames[:len(interp.frames)-1]
+		interp.leave()
+	}()
 	interp.stats.UserCalls++
-	interp.executeBlock(f.Body)
+	// Put back the caller's raising, which the body's expressions replaced,
+	// in case the builtin making the call raises an error after it. It's
+	// left alone when the body raises an error, for spanError.
+	raising := interp.raising
+	c := interp.executeBlock(f.Body)
+	interp.raising = raising
+	if c.kind == completedReturn {
+		return c.value
+	}
 	return Value(nil)
 }
 
 func (f *userFunction) name() string {
+	async := ""
+	if f.Async {
+		async = "async "
+	}
 	if f.Name == "" {
-		return "<function>"
+		return fmt.Sprintf("<%sfunction>", async)
 	}
-	return fmt.Sprintf("<function %s>", f.Name)
+	return fmt.Sprintf("<%sfunction %s>", async, f.Name)
 }
 
 type builtinFunction struct {
 	Function func(interp *interpreter, pos Position, args []Value) Value
 	Name     string
+	MinArgs  int
+	MaxArgs  int // -1 if there's no maximum
 }
 
 func (f builtinFunction) call(interp *interpreter, pos Position, args []Value) Value {
 	interp.stats.BuiltinCalls++
+	if interp.tracer != nil {
+		interp.trace(&TraceEvent{Kind: TraceBuiltin, Position: pos, Callee: f.Name, Args: args})
+	}
 	return f.Function(interp, pos, args)
 }
 
@@ -82,39 +129,66 @@ func (f builtinFunction) name() string {
 }
 
 var builtins = map[string]builtinFunction{
-	"append":          {appendFunction, "append"},
-	"args":            {argsFunction, "args"},
-	"char":            {charFunction, "char"},
-	"exit":            {exitFunction, "exit"},
-	"find":            {findFunction, "find"},
-	"int":             {intFunction, "int"},
-	"join":            {joinFunction, "join"},
-	"len":             {lenFunction, "len"},
-	"echo":            {echoFunction, "echo"},
-	"range":           {rangeFunction, "range"},
-	"read":            {readFunction, "read"},
-	"rune":            {runeFunction, "rune"},
-	"slice":           {sliceFunction, "slice"},
-	"sort":            {sortFunction, "sort"},
-	"split":           {splitFunction, "split"},
-	"explode":         {explodeFunction, "explode"},
-	"str":             {strFunction, "str"},
-	"type":            {typeFunction, "type"},
-	"lower":           {lowerFunction, "lower"},
-	"upper":           {upperFunction, "upper"},
-	"upFirst":         {upFirstFunction, "upFirst"},
-	"upWords":         {upWordsFunction, "upWords"},
-	"lowerFirst":      {lowerFirstFunction, "lowerFirst"},
-	"lowerWords":      {lowerWordsFunction, "lowerWords"},
-	"camelCase":       {camelCaseFunction, "camelCase"},
-	"snakeCase":       {snakeCaseFunction, "snakeCase"},
-	"kebabCase":       {kebabCaseFunction, "kebabCase"},
-	"pascalCase":      {pascalCaseFunction, "pascalCase"},
-	"dotCase":         {dotCaseFunction, "dotCase"},
-	"time":            {timeFunction, "time"},
-	"fileGetContents": {fileGetContentsFunction, "fileGetContents"},
-	"httpRegister":    {httpRegisterFunction, "httpRegister"},
-	"httpListen":      {httpListenFunction, "httpListen"},
+	"append":               {appendFunction, "append", 1, -1},
+	"args":                 {argsFunction, "args", 0, 0},
+	"char":                 {charFunction, "char", 1, 1},
+	"exit":                 {exitFunction, "exit", 0, 1},
+	"find":                 {findFunction, "find", 2, 2},
+	"int":                  {intFunction, "int", 1, 1},
+	"join":                 {joinFunction, "join", 2, 2},
+	"len":                  {lenFunction, "len", 1, 1},
+	"echo":                 {echoFunction, "echo", 0, -1},
+	"range":                {rangeFunction, "range", 1, 1},
+	"read":                 {readFunction, "read", 0, 1},
+	"rune":                 {runeFunction, "rune", 1, 1},
+	"slice":                {sliceFunction, "slice", 3, 3},
+	"sort":                 {sortFunction, "sort", 1, 2},
+	"split":                {splitFunction, "split", 1, 2},
+	"explode":              {explodeFunction, "explode", 1, 2},
+	"str":                  {strFunction, "str", 1, 1},
+	"type":                 {typeFunction, "type", 1, 1},
+	"lower":                {lowerFunction, "lower", 1, 1},
+	"upper":                {upperFunction, "upper", 1, 1},
+	"upFirst":              {upFirstFunction, "upFirst", 1, 1},
+	"upWords":              {upWordsFunction, "upWords", 1, 1},
+	"lowerFirst":           {lowerFirstFunction, "lowerFirst", 1, 1},
+	"lowerWords":           {lowerWordsFunction, "lowerWords", 1, 1},
+	"camelCase":            {camelCaseFunction, "camelCase", 1, 1},
+	"snakeCase":            {snakeCaseFunction, "snakeCase", 1, 1},
+	"kebabCase":            {kebabCaseFunction, "kebabCase", 1, 1},
+	"pascalCase":           {pascalCaseFunction, "pascalCase", 1, 1},
+	"dotCase":              {dotCaseFunction, "dotCase", 1, 1},
+	"time":                 {timeFunction, "time", 0, 0},
+	"fileGetContents":      {fileGetContentsFunction, "fileGetContents", 1, 1},
+	"httpRegister":         {httpRegisterFunction, "httpRegister", 2, 2},
+	"httpListen":           {httpListenFunction, "httpListen", 1, 1},
+	"channel":              {channelFunction, "channel", 0, 1},
+	"send":                 {sendFunction, "send", 2, 2},
+	"recv":                 {recvFunction, "recv", 1, 1},
+	"close":                {closeFunction, "close", 1, 1},
+	"waitGroup":            {waitGroupFunction, "waitGroup", 0, 0},
+	"wgAdd":                {wgAddFunction, "wgAdd", 2, 2},
+	"wgDone":               {wgDoneFunction, "wgDone", 1, 1},
+	"wgWait":               {wgWaitFunction, "wgWait", 1, 1},
+	"mutex":                {mutexFunction, "mutex", 0, 0},
+	"lock":                 {lockFunction, "lock", 1, 1},
+	"unlock":               {unlockFunction, "unlock", 1, 1},
+	"promiseAll":           {promiseAllFunction, "promiseAll", 1, 1},
+	"promiseRace":          {promiseRaceFunction, "promiseRace", 1, 1},
+	"promiseTimeout":       {promiseTimeoutFunction, "promiseTimeout", 2, 2},
+	"sleep":                {sleepFunction, "sleep", 1, 1},
+	"fileGetContentsAsync": {fileGetContentsAsyncFunction, "fileGetContentsAsync", 1, 1},
+	"assert":               {assertFunction, "assert", 1, 2},
+	"assertEquals":         {assertEqualsFunction, "assertEquals", 2, 3},
+	"assertThrows":         {assertThrowsFunction, "assertThrows", 1, 2},
+}
+
+// BuiltinArgs returns the minimum and maximum number of arguments the named
+// builtin function accepts (max is -1 if there's no maximum), and false if
+// there's no such builtin.
+func BuiltinArgs(name string) (min, max int, ok bool) {
+	f, ok := builtins[name]
+	return f.MinArgs, f.MaxArgs, ok
 }
 
 /**
@@ -126,6 +200,7 @@ var builtins = map[string]builtinFunction{
  * description: Append values to a array.
  * title: Append
  * category: Array
+ * check: skip
  */
 func appendFunction(interp *interpreter, pos Position, args []Value) Value {
 	if len(args) < 1 {
@@ -155,6 +230,7 @@ func stringsToList(strings []string) Value {
  * description: Get the command-line arguments passed to the script.
  * title: Args
  * category: System
+ * check: skip
  */
 func argsFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "args", args, 0)
@@ -174,7 +250,7 @@ func argsFunction(interp *interpreter, pos Position, args []Value) Value {
 func charFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "char", args, 1)
 	if code, ok := args[0].(int); ok {
-		return string(code)
+		return string(rune(code))
 	}
 	panic(typeError(pos, "char() requires an int, not %s", typeName(args[0])))
 }
@@ -201,6 +277,7 @@ func exitFunction(interp *interpreter, pos Position, args []Value) Value {
 		}
 		code = arg
 	}
+	interp.allowExit(pos)
 	interp.exit(code)
 	return Value(nil)
 }
@@ -352,7 +429,7 @@ func echoFunction(interp *interpreter, pos Position, args []Value) Value {
 	for i, a := range args {
 		strs[i] = toString(a, false)
 	}
-	fmt.Fprintln(interp.stdout, strs...)
+	interp.println(pos, strs...)
 	return Value(nil)
 }
 
@@ -390,6 +467,7 @@ func rangeFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Read the contents of a file or standard input.
  * title: Read
  * category: System
+ * check: skip
  */
 func readFunction(interp *interpreter, pos Position, args []Value) Value {
 	if len(args) > 1 {
@@ -398,13 +476,14 @@ func readFunction(interp *interpreter, pos Position, args []Value) Value {
 	var b []byte
 	var err error
 	if len(args) == 0 {
-		b, err = ioutil.ReadAll(interp.stdin)
+		interp.unlocked(func() { b, err = ioutil.ReadAll(interp.stdin) })
 	} else {
 		filename, ok := args[0].(string)
 		if !ok {
 			panic(typeError(pos, "read() argument must be a str"))
 		}
-		b, err = ioutil.ReadFile(filename)
+		interp.allowFile(pos, filename)
+		interp.unlocked(func() { b, err = ioutil.ReadFile(filename) })
 	}
 	if err != nil {
 		panic(runtimeError(pos, "read() error: %v", err))
@@ -478,6 +557,7 @@ func sliceFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Sort a list of values.
  * title: Sort
  * category: Array
+ * check: skip
  */
 func sortFunction(interp *interpreter, pos Position, args []Value) Value {
 	if len(args) != 1 && len(args) != 2 {
@@ -629,6 +709,16 @@ func toString(value Value, quoteStr bool) string {
 		s = fmt.Sprintf("{%s}", strings.Join(strs, ", "))
 	case functionType:
 		s = v.name()
+	case *Channel:
+		s = v.String()
+	case *WaitGroup:
+		s = "<waitGroup>"
+	case *Mutex:
+		s = "<mutex>"
+	case *Promise:
+		s = v.String()
+	case *ClassObject:
+		s = fmt.Sprintf("<object %s>", v.Name)
 	default:
 		// Interpreter should never give us this
 		panic(fmt.Sprintf("str() got unexpected type %T", v))
@@ -671,6 +761,14 @@ func typeName(v Value) string {
 		t = "function"
 	case *ClassObject:
 		t = "object"
+	case *Channel:
+		t = "channel"
+	case *WaitGroup:
+		t = "waitGroup"
+	case *Mutex:
+		t = "mutex"
+	case *Promise:
+		t = "promise"
 
 	default:
 		// Interpreter should never give us this
@@ -812,6 +910,7 @@ func lowerWordsFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Convert a string to camelCase.
  * title: Camel Case
  * category: String
+ * check: skip
  */
 func camelCaseFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "camelCase", args, 1)
@@ -837,6 +936,7 @@ func camelCaseFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Convert a string to snake_case.
  * title: Snake Case
  * category: String
+ * check: skip
  */
 func snakeCaseFunction(interp *interpreter, pos Position, args []Value) Value {
 
@@ -856,6 +956,7 @@ func snakeCaseFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Convert a string to kebab-case.
  * title: Kebab Case
  * category: String
+ * check: skip
  */
 func kebabCaseFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "kebabCase", args, 1)
@@ -892,6 +993,7 @@ func pascalCaseFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Convert a string to dot.case.
  * title: Dot Case
  * category: String
+ * check: skip
  */
 func dotCaseFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "dotCase", args, 1)
@@ -910,6 +1012,7 @@ func dotCaseFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Get the current date and time as a string.
  * title: Time
  * category: System
+ * check: skip
  */
 func timeFunction(interp *interpreter, pos Position, args []Value) Value {
 	ensureNumArgs(pos, "time", args, 0)
@@ -928,6 +1031,7 @@ func timeFunction(interp *interpreter, pos Position, args []Value) Value {
  * description: Get the contents of a file or URL.
  * title: File Get Contents
  * category: File System
+ * check: skip
  */
 func fileGetContentsFunction(interp *interpreter, pos Position, args []Value) Value {
 
@@ -936,14 +1040,20 @@ func fileGetContentsFunction(interp *interpreter, pos Position, args []Value) Va
 
 		_, err := url.ParseRequestURI(s)
 		if err == nil {
-			data, err := functions.GetContentFromUrl(s)
+			var data []byte
+			client := interp.httpClient(pos, "fileGetContents", s)
+			ctx := interp.limits.context()
+			interp.unlocked(func() { data, err = functions.GetContentFromUrlWithClient(ctx, client, s) })
 			if err != nil {
+				if ctx.Err() != nil {
+					panic(interp.limits.cancelled(pos))
+				}
 				panic(runtimeError(pos, "fileGetContents() error: %v", err))
 			} else {
 				return Value(string(data))
 			}
 		} else {
-			fmt.Fprintln(interp.stdout, s)
+			interp.println(pos, s)
 		}
 
 		return Value(nil)
@@ -956,15 +1066,17 @@ func fileGetContentsFunction(interp *interpreter, pos Position, args []Value) Va
  * function: httpRegister
  * args: pattern, handler
  * return: nil
- * example: httpRegister("/", func() { return "Hello, World!" })
+ * example: httpRegister("/", function() { return "Hello, World!"; })
  * output: "Hello, World!"
  * description: Register a handler function for a URL pattern.
  * title: HTTP Register
  * category: HTTP
+ * check: skip
  */
 func httpRegisterFunction(interp *interpreter, pos Position, args []Value) Value {
 
 	ensureNumArgs(pos, "httpRegister", args, 2)
+	interp.allowListen(pos, "httpRegister")
 	//
 	if len(args) != 1 && len(args) != 2 {
 		panic(typeError(pos, "httpRegisterFunction() requires 2 args, got %d", len(args)))
@@ -982,9 +1094,21 @@ func httpRegisterFunction(interp *interpreter, pos Position, args []Value) Value
 	pattern := args[0].(string)
 
 	getRoot := func(w http.ResponseWriter, r *http.Request) {
-		outputFunction := interp.callFunction(pos, handlerFunction, []Value{})
+		// Each request runs on its own goroutine, so call the handler
+		// on a forked interpreter holding the interpreter lock
+		handler := interp.fork()
+		handler.shared.lock.Lock()
+		defer handler.shared.lock.Unlock()
+		if handler.loop.stopped() {
+			http.Error(w, "script has finished", http.StatusServiceUnavailable)
+			return
+		}
+		outputFunction := handler.callFunction(pos, handlerFunction, []Value{})
+		outputFunction = handler.await(pos, outputFunction)
+		handler.drain()
 		fmt.Fprintln(w, outputFunction)
 	}
+	interp.shared.concurrent = true
 	http.HandleFunc(pattern, getRoot)
 
 	return Value(nil)
@@ -1000,10 +1124,12 @@ func httpRegisterFunction(interp *interpreter, pos Position, args []Value) Value
  * description: Start the HTTP server.
  * title: HTTP Listen
  * category: HTTP
+ * check: skip
  */
 func httpListenFunction(interp *interpreter, pos Position, args []Value) Value {
 
 	ensureNumArgs(pos, "httpListen", args, 1)
+	interp.allowListen(pos, "httpListen")
 
 	if len(args) != 1 {
 		panic(typeError(pos, "httpRegisterFunction() requires 1 arg, got %d", len(args)))
@@ -1020,10 +1146,333 @@ func httpListenFunction(interp *interpreter, pos Position, args []Value) Value {
 		fmt.Printf("Server is starting on http://localhost%s...\n", portOrAddress)
 	}
 
-	err := http.ListenAndServe(portOrAddress, nil)
+	var err error
+	interp.unlocked(func() { err = http.ListenAndServe(portOrAddress, nil) })
 	if err != nil {
 		panic(runtimeError(pos, "httpListen() error: %v", err))
 	}
 
 	return Value(nil)
 }
+
+/**
+ * function: channel
+ * args: [size]
+ * return: channel
+ * example: channel(10)
+ * output: <channel 0/10>
+ * description: Create a channel for passing values between goroutines, buffered if size is given.
+ * title: Channel
+ * category: Concurrency
+ */
+func channelFunction(interp *interpreter, pos Position, args []Value) Value {
+	if len(args) > 1 {
+		panic(typeError(pos, "channel() requires 0 or 1 args, got %d", len(args)))
+	}
+	size := 0
+	if len(args) > 0 {
+		n, ok := args[0].(int)
+		if !ok {
+			panic(typeError(pos, "channel() requires an int, not %s", typeName(args[0])))
+		}
+		if n < 0 {
+			panic(valueError(pos, "channel() size must not be negative"))
+		}
+		size = n
+	}
+	return Value(&Channel{make(chan Value, size)})
+}
+
+func ensureChannel(pos Position, name string, v Value) *Channel {
+	if c, ok := v.(*Channel); ok {
+		return c
+	}
+	panic(typeError(pos, "%s() requires first argument to be a channel, not %s", name, typeName(v)))
+}
+
+/**
+ * function: send
+ * args: channel, value
+ * return: nil
+ * example: send($ch, 42)
+ * output: nil
+ * description: Send a value on a channel, blocking until it can be delivered.
+ * title: Send
+ * category: Concurrency
+ * setup: $ch = channel(1)
+ */
+func sendFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "send", args, 2)
+	interp.send(pos, ensureChannel(pos, "send", args[0]), args[1])
+	return Value(nil)
+}
+
+/**
+ * function: recv
+ * args: channel
+ * return: any
+ * example: recv($ch)
+ * output: 42
+ * description: Receive a value from a channel, blocking until one is available. Returns nil once the channel is closed and empty.
+ * title: Receive
+ * category: Concurrency
+ * setup: $ch = channel(1); send($ch, 42)
+ */
+func recvFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "recv", args, 1)
+	return interp.recv(pos, ensureChannel(pos, "recv", args[0]))
+}
+
+/**
+ * function: close
+ * args: channel
+ * return: nil
+ * example: close($ch)
+ * output: nil
+ * description: Close a channel so no more values can be sent on it.
+ * title: Close
+ * category: Concurrency
+ * setup: $ch = channel()
+ */
+func closeFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "close", args, 1)
+	ensureChannel(pos, "close", args[0]).close(pos)
+	return Value(nil)
+}
+
+/**
+ * function: waitGroup
+ * args: none
+ * return: waitGroup
+ * example: waitGroup()
+ * output: <waitGroup>
+ * description: Create a wait group for waiting on a collection of goroutines to finish.
+ * title: Wait Group
+ * category: Concurrency
+ */
+func waitGroupFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "waitGroup", args, 0)
+	return Value(&WaitGroup{})
+}
+
+func ensureWaitGroup(pos Position, name string, v Value) *WaitGroup {
+	if wg, ok := v.(*WaitGroup); ok {
+		return wg
+	}
+	panic(typeError(pos, "%s() requires first argument to be a waitGroup, not %s", name, typeName(v)))
+}
+
+/**
+ * function: wgAdd
+ * args: waitGroup, delta
+ * return: nil
+ * example: wgAdd($wg, 1)
+ * output: nil
+ * description: Add delta to the wait group counter.
+ * title: Wait Group Add
+ * category: Concurrency
+ * setup: $wg = waitGroup()
+ */
+func wgAddFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "wgAdd", args, 2)
+	wg := ensureWaitGroup(pos, "wgAdd", args[0])
+	delta, ok := args[1].(int)
+	if !ok {
+		panic(typeError(pos, "wgAdd() requires delta to be an int"))
+	}
+	wg.add(pos, delta)
+	return Value(nil)
+}
+
+/**
+ * function: wgDone
+ * args: waitGroup
+ * return: nil
+ * example: wgDone($wg)
+ * output: nil
+ * description: Decrement the wait group counter by one.
+ * title: Wait Group Done
+ * category: Concurrency
+ * setup: $wg = waitGroup(); wgAdd($wg, 1)
+ */
+func wgDoneFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "wgDone", args, 1)
+	ensureWaitGroup(pos, "wgDone", args[0]).add(pos, -1)
+	return Value(nil)
+}
+
+/**
+ * function: wgWait
+ * args: waitGroup
+ * return: nil
+ * example: wgWait($wg)
+ * output: nil
+ * description: Block until the wait group counter is zero.
+ * title: Wait Group Wait
+ * category: Concurrency
+ * setup: $wg = waitGroup()
+ */
+func wgWaitFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "wgWait", args, 1)
+	interp.wait(pos, ensureWaitGroup(pos, "wgWait", args[0]))
+	return Value(nil)
+}
+
+/**
+ * function: mutex
+ * args: none
+ * return: mutex
+ * example: mutex()
+ * output: <mutex>
+ * description: Create a mutual exclusion lock.
+ * title: Mutex
+ * category: Concurrency
+ */
+func mutexFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "mutex", args, 0)
+	return Value(&Mutex{make(chan struct{}, 1)})
+}
+
+func ensureMutex(pos Position, name string, v Value) *Mutex {
+	if m, ok := v.(*Mutex); ok {
+		return m
+	}
+	panic(typeError(pos, "%s() requires a mutex, not %s", name, typeName(v)))
+}
+
+/**
+ * function: lock
+ * args: mutex
+ * return: nil
+ * example: lock($mu)
+ * output: nil
+ * description: Lock a mutex, blocking until it is available.
+ * title: Lock
+ * category: Concurrency
+ * setup: $mu = mutex()
+ */
+func lockFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "lock", args, 1)
+	interp.lock(pos, ensureMutex(pos, "lock", args[0]))
+	return Value(nil)
+}
+
+/**
+ * function: unlock
+ * args: mutex
+ * return: nil
+ * example: unlock($mu)
+ * output: nil
+ * description: Unlock a locked mutex.
+ * title: Unlock
+ * category: Concurrency
+ * setup: $mu = mutex(); lock($mu)
+ */
+func unlockFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "unlock", args, 1)
+	ensureMutex(pos, "unlock", args[0]).unlock(pos)
+	return Value(nil)
+}
+
+/**
+ * function: promiseAll
+ * args: list
+ * return: promise
+ * example: await promiseAll([sleep(10), 2])
+ * output: [nil, 2]
+ * description: Wait for all promises in a list, returning the list of their values or the first error.
+ * title: Promise All
+ * category: Async
+ */
+func promiseAllFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "promiseAll", args, 1)
+	return Value(interp.promiseAll(pos, ensurePromises(pos, "promiseAll", args[0])))
+}
+
+/**
+ * function: promiseRace
+ * args: list
+ * return: promise
+ * example: await promiseRace([sleep(100), 2])
+ * output: 2
+ * description: Wait for the first promise in a list to settle, returning its value or error.
+ * title: Promise Race
+ * category: Async
+ */
+func promiseRaceFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "promiseRace", args, 1)
+	return Value(interp.promiseRace(pos, ensurePromises(pos, "promiseRace", args[0])))
+}
+
+/**
+ * function: promiseTimeout
+ * args: promise, milliseconds
+ * return: promise
+ * example: await promiseTimeout(sleep(10), 1000)
+ * output: nil
+ * description: Wait for a promise, failing with an error if it takes longer than the given milliseconds.
+ * title: Promise Timeout
+ * category: Async
+ */
+func promiseTimeoutFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "promiseTimeout", args, 2)
+	p, ok := args[0].(*Promise)
+	if !ok {
+		panic(typeError(pos, "promiseTimeout() requires first argument to be a promise, not %s", typeName(args[0])))
+	}
+	ms, ok := args[1].(int)
+	if !ok || ms < 0 {
+		panic(typeError(pos, "promiseTimeout() requires milliseconds to be a non-negative int"))
+	}
+	return Value(interp.promiseTimeout(pos, p, ms))
+}
+
+/**
+ * function: sleep
+ * args: milliseconds
+ * return: promise
+ * example: await sleep(10)
+ * output: nil
+ * description: Get a promise that is fulfilled with nil after the given milliseconds.
+ * title: Sleep
+ * category: Async
+ */
+func sleepFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "sleep", args, 1)
+	ms, ok := args[0].(int)
+	if !ok || ms < 0 {
+		panic(typeError(pos, "sleep() requires milliseconds to be a non-negative int"))
+	}
+	return Value(interp.sleep(ms))
+}
+
+/**
+ * function: fileGetContentsAsync
+ * args: url
+ * return: promise
+ * example: await fileGetContentsAsync("http://example.com")
+ * output: "..."
+ * description: Get the contents of a URL without blocking the script, returning a promise.
+ * title: File Get Contents Async
+ * category: Async
+ * check: skip
+ */
+func fileGetContentsAsyncFunction(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "fileGetContentsAsync", args, 1)
+	s, ok := args[0].(string)
+	if !ok {
+		panic(typeError(pos, "fileGetContentsAsync() requires a str"))
+	}
+	if _, err := url.ParseRequestURI(s); err != nil {
+		panic(valueError(pos, "fileGetContentsAsync() requires a URL, got %q", s))
+	}
+	client := interp.httpClient(pos, "fileGetContentsAsync", s)
+	ctx := interp.limits.context()
+	return Value(interp.goAsync(func() (Value, error) {
+		data, err := functions.GetContentFromUrlWithClient(ctx, client, s)
+		if err != nil {
+			return nil, runtimeError(pos, "fileGetContentsAsync() error: %v", err)
+		}
+		return Value(string(data)), nil
+	}))
+}
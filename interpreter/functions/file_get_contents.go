@@ -3,13 +3,24 @@
 package functions
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 )
 
 func GetContentFromUrl(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	return GetContentFromUrlWithClient(context.Background(), http.DefaultClient, url)
+}
+
+// GetContentFromUrlWithClient is like GetContentFromUrl but makes the
+// request with the given client, giving up when ctx is done.
+func GetContentFromUrlWithClient(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("GET error: %v", err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("GET error: %v", err)
 	}
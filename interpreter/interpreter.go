@@ -3,12 +3,14 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
 	. "github.com/DavinciScript/Davi/lexer"
 	"github.com/DavinciScript/Davi/parser"
 	"io"
 	"os"
 	"strings"
+	"time"
 )
 
 // Value is a littlelang runtime value (nil, bool, int, str, list, map, func).
@@ -35,6 +37,49 @@ type Config struct {
 	// Exit is the function to call when the builtin exit() is called.
 	// Defaults to os.Exit if nil.
 	Exit func(int)
+
+	// Debugger, if not nil, is called before each statement is executed.
+	// It may block to stop the program, and can inspect it via the
+	// DebugState.
+	Debugger func(state *DebugState)
+
+	// MaxOps, if positive, is the most ops (see Stats) the program may
+	// run before it's stopped with a RuntimeError.
+	MaxOps int
+
+	// MaxDepth, if positive, is how deeply user function calls may nest
+	// before the program is stopped with a RuntimeError. Without it,
+	// runaway recursion overflows the Go stack.
+	MaxDepth int
+
+	// MaxOutput, if positive, is the most bytes the program may write to
+	// Stdout before it's stopped with a RuntimeError.
+	MaxOutput int
+
+	// Timeout, if positive, is how long each Evaluate or Execute call (or
+	// Repl.Execute call) may run before it's stopped with a RuntimeError.
+	Timeout time.Duration
+
+	// Permissions, if not nil, sandboxes the program so its builtins can
+	// only access the files, hosts and process features it allows.
+	Permissions *Permissions
+
+	// Context, if not nil, stops the program with a RuntimeError when it's
+	// cancelled, including while the program is blocked on a channel,
+	// timer or promise.
+	Context context.Context
+
+	// Coverage, if not nil, records which statements and branches the
+	// program executes.
+	Coverage *Coverage
+
+	// Profiler, if not nil, records where the program spends its time.
+	Profiler *Profiler
+
+	// Tracer, if not nil, is called with each statement before it's
+	// executed, each user function call and return, and each builtin
+	// call. Goroutines may call it concurrently.
+	Tracer func(event *TraceEvent)
 }
 
 // Statistics about the interpreter from an Evaluate or Execute call.
@@ -45,19 +90,58 @@ type Stats struct {
 }
 
 type interpreter struct {
-	vars   []map[string]Value
-	args   []string
-	stdin  io.Reader
-	stdout io.Writer
-	exit   func(int)
-	stats  Stats
+	globals     map[string]Value
+	env         *environment // locals of the function being run, nil at the top level
+	args        []string
+	stdin       io.Reader
+	stdout      io.Writer
+	exit        func(int)
+	stats       *Stats
+	shared      *sharedState
+	loop        *eventLoop
+	task        *task
+	frames      []frame
+	limits      *limits
+	permissions *Permissions
+	depth       int // how deeply user function calls are nested
+
+	// raising is the expression that last ran its own step (an
+	// operation, call or subscript, once its operands are evaluated) or
+	// raised an error itself, so spanError can give the error it raises
+	// its span. Statements that raise an error at the position of an
+	// expression they evaluated set it to nil first, as the error isn't
+	// the expression's.
+	raising parser.Expression
+
+	debugger func(state *DebugState)
+	coverage *Coverage
+
+	profiler      *Profiler
+	profileCursor profileCursor
+
+	tracer func(event *TraceEvent)
 }
 
-type returnResult struct {
-	value Value
-	pos   Position
+// completionKind says how a statement finished.
+type completionKind uint8
+
+const (
+	completedNormally completionKind = iota
+	completedReturn
+)
+
+// completion is how a statement or block finished. A return stops the
+// blocks enclosing it, which pass its completion up to the function call
+// (or top level) that handles it, so no panic is needed to unwind.
+type completion struct {
+	kind  completionKind
+	value Value    // the value returned
+	pos   Position // the position of the return statement
 }
 
+// normal is the completion of a statement that finished normally.
+var normal = completion{}
+
 type binaryEvalFunc func(pos Position, l, r Value) Value
 
 var binaryEvalFuncs = map[Token]binaryEvalFunc{
@@ -119,6 +203,8 @@ func evalEqual(pos Position, l, r Value) Value {
 		if r, rok := r.(functionType); rok {
 			return Value(l == r)
 		}
+	case *Channel, *WaitGroup, *Mutex, *Promise:
+		return Value(l == r)
 	}
 	return Value(false)
 }
@@ -324,97 +410,85 @@ func evalSubscript(pos Position, container, subscript Value) Value {
 	}
 }
 
-func (interp *interpreter) evalAnd(pos Position, le, re parser.Expression) Value {
-	l := interp.evaluate(le)
+func (interp *interpreter) evalAnd(e *parser.Binary) Value {
+	l := interp.evaluate(e.Left)
 	if l, ok := l.(bool); ok {
 		if !l {
 			// Short circuit: don't evaluate right if left false
 			return Value(false)
 		}
-		r := interp.evaluate(re)
+		r := interp.evaluate(e.Right)
 		if r, ok := r.(bool); ok {
 			return Value(r)
-		} else {
-			panic(typeError(pos, "and requires two bools"))
 		}
-	} else {
-		panic(typeError(pos, "and requires two bools"))
 	}
+	interp.raising = e
+	panic(typeError(e.Position(), "and requires two bools"))
 }
 
-func (interp *interpreter) evalOr(pos Position, le, re parser.Expression) Value {
-	l := interp.evaluate(le)
+func (interp *interpreter) evalOr(e *parser.Binary) Value {
+	l := interp.evaluate(e.Left)
 	if l, ok := l.(bool); ok {
 		if l {
 			// Short circuit: don't evaluate right if left true
 			return Value(true)
 		}
-		r := interp.evaluate(re)
+		r := interp.evaluate(e.Right)
 		if r, ok := r.(bool); ok {
 			return Value(r)
-		} else {
-			panic(typeError(pos, "or requires two bools"))
 		}
-	} else {
-		panic(typeError(pos, "or requires two bools"))
 	}
+	interp.raising = e
+	panic(typeError(e.Position(), "or requires two bools"))
 }
 
-func (interp *interpreter) callFunction(pos Position, f functionType, args []Value) (ret Value) {
-	defer func() {
-		if r := recover(); r != nil {
-			if result, ok := r.(returnResult); ok {
-				ret = result.value
-			} else {
-				panic(r)
-			}
-		}
-	}()
+func (interp *interpreter) callFunction(pos Position, f functionType, args []Value) Value {
 	return f.call(interp, pos, args)
 }
 
 func (interp *interpreter) evaluate(expr parser.Expression) Value {
 	interp.stats.Ops++
+	if interp.stats.Ops >= interp.limits.next {
+		interp.raising = expr
+		interp.limits.check(expr.Position(), interp.stats.Ops)
+	}
 	switch e := expr.(type) {
 	case *parser.Binary:
 		if f, ok := binaryEvalFuncs[e.Operator]; ok {
-			return f(e.Position(), interp.evaluate(e.Left), interp.evaluate(e.Right))
+			l, r := interp.evaluate(e.Left), interp.evaluate(e.Right)
+			interp.raising = e
+			return f(e.Position(), l, r)
 		} else if e.Operator == AND {
-			return interp.evalAnd(e.Position(), e.Left, e.Right)
+			return interp.evalAnd(e)
 		} else if e.Operator == OR {
-			return interp.evalOr(e.Position(), e.Left, e.Right)
+			return interp.evalOr(e)
 		}
 		// Parser should never give us this
 		panic(fmt.Sprintf("unknown binary operator %v", e.Operator))
 	case *parser.Unary:
 		if f, ok := unaryEvalFuncs[e.Operator]; ok {
-			return f(e.Position(), interp.evaluate(e.Operand))
+			operand := interp.evaluate(e.Operand)
+			interp.raising = e
+			return f(e.Position(), operand)
 		}
 		// Parser should never give us this
 		panic(fmt.Sprintf("unknown unary operator %v", e.Operator))
 	case *parser.Call:
 		function := interp.evaluate(e.Function)
 		if f, ok := function.(functionType); ok {
-			args := []Value{}
-			for _, a := range e.Arguments {
-				args = append(args, interp.evaluate(a))
-			}
-			if e.Ellipsis {
-				iterator := getIterator(e.Arguments[len(args)-1].Position(), args[len(args)-1])
-				args = args[:len(args)-1]
-				for iterator.HasNext() {
-					args = append(args, iterator.Value())
-				}
-			}
+			args := interp.evaluateArgs(e)
+			interp.raising = e
 			return interp.callFunction(e.Function.Position(), f, args)
 		}
+		interp.raising = e
 		panic(typeError(e.Function.Position(), "can't call non-function type %s", typeName(function)))
 	case *parser.Literal:
 		return Value(e.Value)
 	case *parser.Variable:
-		if v, ok := interp.lookup(e.Name); ok {
+		if v, ok := interp.load(e); ok {
 			return v
 		}
+		interp.raising = e
 		panic(nameError(e.Position(), "name %q not found", e.Name))
 	case *parser.List:
 		values := make([]Value, len(e.Values))
@@ -429,6 +503,7 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 			if k, ok := key.(string); ok {
 				value[k] = interp.evaluate(item.Value)
 			} else {
+				interp.raising = nil
 				panic(typeError(item.Key.Position(), "map key must be str, not %s", typeName(key)))
 			}
 		}
@@ -436,10 +511,14 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 	case *parser.Subscript:
 		container := interp.evaluate(e.Container)
 		subscript := interp.evaluate(e.Subscript)
+		interp.raising = e
 		return evalSubscript(e.Subscript.Position(), container, subscript)
 	case *parser.FunctionExpression:
-		closure := interp.vars[len(interp.vars)-1]
-		return &userFunction{"", e.Parameters, e.Ellipsis, e.Body, closure}
+		return &userFunction{"", e.Parameters, e.Ellipsis, e.Body, interp.env, e.Async, e.Locals}
+	case *parser.Await:
+		value := interp.evaluate(e.Value)
+		interp.raising = e
+		return interp.await(e.Position(), value)
 	case *parser.SemiTag:
 		return nil
 	case *parser.MethodCall:
@@ -450,6 +529,7 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 		// Ensure the object is an instance of a class
 		instance, ok := object.(*ClassObject)
 		if !ok {
+			interp.raising = e
 			panic(typeError(e.Position(), "cannot call method on non-instance type %s", typeName(object)))
 		}
 
@@ -457,7 +537,8 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 
 		method, ok := instance.Methods[methodName]
 		if !ok {
-			panic(fmt.Sprintf("Method '%s' not found in class '%s'", methodName, instance.Name))
+			interp.raising = e
+			panic(nameError(e.Position(), "method %q not found in class %s", methodName, instance.Name))
 		}
 
 		args := []Value{}
@@ -466,7 +547,8 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 		}
 
 		//print("CallMethod name -> ", method.name())
-		return method.call(interp, e.Position(), args)
+		interp.raising = e
+		return interp.callFunction(e.Position(), method, args)
 
 	case *parser.NewExpression:
 		// Evaluate the class name and arguments
@@ -478,7 +560,8 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 		//}
 		//
 		//// Create a new instance of the class
-		instance := interp.newInstance(className, nil)
+		interp.raising = e
+		instance := interp.newInstance(e.Position(), className, nil)
 		//print("New exp: Instance: ", instance)
 		return instance
 	default:
@@ -487,36 +570,130 @@ func (interp *interpreter) evaluate(expr parser.Expression) Value {
 	}
 }
 
+func (interp *interpreter) evaluateArgs(call *parser.Call) []Value {
+	args := []Value{}
+	for _, a := range call.Arguments {
+		args = append(args, interp.evaluate(a))
+	}
+	if call.Ellipsis {
+		interp.raising = nil
+		iterator := getIterator(call.Arguments[len(args)-1].Position(), args[len(args)-1])
+		args = args[:len(args)-1]
+		for iterator.HasNext() {
+			args = append(args, iterator.Value())
+		}
+	}
+	return args
+}
+
 type objectWithMethodsType interface {
 	lookupMethod(methodName string) functionType
 }
 
-func (interp *interpreter) pushScope(scope map[string]Value) {
-	interp.vars = append(interp.vars, scope)
+// undefinedType is the type of the value in a local slot that hasn't been
+// assigned yet; reading it falls back to the enclosing scopes, so a
+// function can read a variable of its caller's scope before shadowing it.
+type undefinedType struct{}
+
+var undefined Value = undefinedType{}
+
+// environment holds the local variables of one function call, in the slots
+// the parser's resolver gave them, and the environment the function was
+// defined in (nil at the top level).
+type environment struct {
+	slots  []Value
+	names  []string
+	parent *environment
+}
+
+// newEnvironment returns the environment for a call of a function with the
+// given locals, the first of which are its parameters.
+func newEnvironment(locals []string, args []Value, parent *environment) *environment {
+	slots := make([]Value, len(locals))
+	copy(slots, args)
+	for i := len(args); i < len(slots); i++ {
+		slots[i] = undefined
+	}
+	return &environment{slots, locals, parent}
+}
+
+// load returns the value of a variable, using the slot the resolver gave it.
+func (interp *interpreter) load(v *parser.Variable) (Value, bool) {
+	if !v.Slot.Local {
+		if value, ok := interp.globals[v.Name]; ok {
+			return value, true
+		}
+		return interp.lookupCallers(v.Name)
+	}
+	env := interp.env
+	for d := 0; d < v.Slot.Depth; d++ {
+		env = env.parent
+	}
+	if value := env.slots[v.Slot.Index]; value != undefined {
+		return value, true
+	}
+	return interp.lookupFrom(env.parent, v.Name)
 }
 
-func (interp *interpreter) popScope() {
-	interp.vars = interp.vars[:len(interp.vars)-1]
+// store assigns to a variable, which is always in the current function (or
+// global at the top level).
+func (interp *interpreter) store(slot parser.Slot, name string, value Value) {
+	if slot.Local {
+		interp.env.slots[slot.Index] = value
+	} else {
+		interp.globals[name] = value
+	}
 }
 
-func (interp *interpreter) assign(name string, value Value) {
-	interp.vars[len(interp.vars)-1][name] = value
+// lookupFrom looks up a variable by name in env and the environments
+// enclosing it, then in the globals, then in the functions on the call
+// stack.
+func (interp *interpreter) lookupFrom(env *environment, name string) (Value, bool) {
+	if v, ok := lookupEnvironment(env, name); ok {
+		return v, true
+	}
+	if v, ok := interp.globals[name]; ok {
+		return v, true
+	}
+	return interp.lookupCallers(name)
 }
 
-func (interp *interpreter) lookup(name string) (Value, bool) {
-	for i := len(interp.vars) - 1; i >= 0; i-- {
-		thisVars := interp.vars[i]
-		if v, ok := thisVars[name]; ok {
+// lookupCallers looks up a variable that isn't visible where it's used in
+// the functions on the call stack, innermost first, and the functions
+// enclosing them. Names are scoped dynamically like this so a function can
+// use the local variables of the function that called it. Spawned and
+// async calls start with an empty call stack.
+func (interp *interpreter) lookupCallers(name string) (Value, bool) {
+	for i := len(interp.frames) - 1; i >= 0; i-- {
+		if v, ok := lookupEnvironment(interp.frames[i].env, name); ok {
 			return v, true
 		}
 	}
 	return nil, false
 }
 
-func (interp *interpreter) executeBlock(block parser.Block) {
+// lookupEnvironment looks up a variable by name in env and the
+// environments enclosing it.
+func lookupEnvironment(env *environment, name string) (Value, bool) {
+	for ; env != nil; env = env.parent {
+		for i := len(env.names) - 1; i >= 0; i-- {
+			if env.names[i] == name && env.slots[i] != undefined {
+				return env.slots[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// executeBlock executes the statements of block until one of them doesn't
+// complete normally, and returns how the block completed.
+func (interp *interpreter) executeBlock(block parser.Block) completion {
 	for _, s := range block {
-		interp.executeStatement(s)
+		if c := interp.executeStatement(s); c.kind != completedNormally {
+			return c
+		}
 	}
+	return normal
 }
 
 type iteratorType interface {
@@ -584,17 +761,35 @@ func (interp *interpreter) assignSubscript(pos Position, container, subscript, v
 	}
 }
 
-func (interp *interpreter) executeStatement(s parser.Statement) {
+func (interp *interpreter) executeStatement(s parser.Statement) completion {
 	interp.stats.Ops++
+	if interp.stats.Ops >= interp.limits.next {
+		interp.raising = nil
+		interp.limits.check(s.Position(), interp.stats.Ops)
+	}
+	interp.yield()
+	if interp.debugger != nil && !isSemiTag(s) {
+		interp.debug(s)
+	}
+	if interp.coverage != nil {
+		interp.coverage.executed(s)
+	}
+	if interp.profiler != nil && !isSemiTag(s) {
+		interp.profile(nil, s.Position())
+	}
+	if interp.tracer != nil && !isSemiTag(s) {
+		interp.trace(&TraceEvent{Kind: TraceStatement, Position: s.Position()})
+	}
 	switch s := s.(type) {
 	case *parser.Assign:
 		switch target := s.Target.(type) {
 		case *parser.Variable:
-			interp.assign(target.Name, interp.evaluate(s.Value))
+			interp.store(target.Slot, target.Name, interp.evaluate(s.Value))
 		case *parser.Subscript:
 			container := interp.evaluate(target.Container)
 			subscript := interp.evaluate(target.Subscript)
 			value := interp.evaluate(s.Value)
+			interp.raising = nil
 			interp.assignSubscript(target.Subscript.Position(), container, subscript, value)
 		default:
 			// Parser should never get us here
@@ -603,41 +798,56 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 	case *parser.If:
 		cond := interp.evaluate(s.Condition)
 		if c, ok := cond.(bool); ok {
+			if interp.coverage != nil {
+				interp.coverage.branch(s, c)
+			}
 			if c {
-				interp.executeBlock(s.Body)
+				return interp.executeBlock(s.Body)
 			} else if len(s.Else) > 0 {
-				interp.executeBlock(s.Else)
+				return interp.executeBlock(s.Else)
 			}
 		} else {
+			interp.raising = nil
 			panic(typeError(s.Condition.Position(), "if condition must be bool, got %s", typeName(cond)))
 		}
 	case *parser.While:
 		for {
 			cond := interp.evaluate(s.Condition)
 			if c, ok := cond.(bool); ok {
+				if interp.coverage != nil {
+					interp.coverage.branch(s, c)
+				}
 				if !c {
 					break
 				}
-				interp.executeBlock(s.Body)
+				if c := interp.executeBlock(s.Body); c.kind != completedNormally {
+					return c
+				}
 			} else {
+				interp.raising = nil
 				panic(typeError(s.Condition.Position(), "while condition must be bool, got %T", cond))
 			}
 		}
 	case *parser.For:
 		iterable := interp.evaluate(s.Iterable)
+		interp.raising = nil
 		iterator := getIterator(s.Iterable.Position(), iterable)
 		for iterator.HasNext() {
-			interp.assign(s.Name, iterator.Value())
-			interp.executeBlock(s.Body)
+			interp.store(s.Slot, s.Name, iterator.Value())
+			if c := interp.executeBlock(s.Body); c.kind != completedNormally {
+				return c
+			}
 		}
 	case *parser.ExpressionStatement:
 		interp.evaluate(s.Expression)
 	case *parser.FunctionDefinition:
-		closure := interp.vars[len(interp.vars)-1]
-		interp.assign(s.Name, &userFunction{s.Name, s.Parameters, s.Ellipsis, s.Body, closure})
+		interp.store(s.Slot, s.Name, &userFunction{s.Name, s.Parameters, s.Ellipsis, s.Body, interp.env, s.Async, s.Locals})
 	case *parser.Return:
-		result := interp.evaluate(s.Result)
-		panic(returnResult{result, s.Position()})
+		return completion{completedReturn, interp.evaluate(s.Result), s.Position()}
+	case *parser.Spawn:
+		interp.executeSpawn(s)
+	case *parser.Select:
+		return interp.executeSelect(s)
 
 	case *parser.ClassDefinition:
 
@@ -663,7 +873,7 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 
 		//print("register className:" + className)
 
-		interp.assign(className, &ClassObject{
+		interp.store(s.Slot, className, &ClassObject{
 			Name:    className,
 			Methods: methods,
 			Fields:  fields,
@@ -673,33 +883,41 @@ func (interp *interpreter) executeStatement(s parser.Statement) {
 		// Parser should never get us here
 		panic(fmt.Sprintf("unexpected statement type %T", s))
 	}
+	return normal
 }
 
 func (interp *interpreter) createMethod(methodDef *parser.FunctionDefinition) functionType {
-	closure := interp.vars[len(interp.vars)-1] // Capture current environment
 	return &userFunction{
 		Name:       methodDef.Name,
 		Parameters: methodDef.Parameters,
 		Ellipsis:   methodDef.Ellipsis,
 		Body:       methodDef.Body,
-		Closure:    closure,
+		Closure:    interp.env, // Capture current environment
+		Locals:     methodDef.Locals,
 	}
 }
 
 func (interp *interpreter) execute(prog *parser.Program) {
 	for _, statement := range prog.Statements {
-		interp.executeStatement(statement)
+		if c := interp.executeStatement(statement); c.kind == completedReturn {
+			panic(runtimeError(c.pos, "can't return at top level"))
+		}
 	}
 }
 
 func newInterpreter(config *Config) *interpreter {
 	interp := new(interpreter)
-	interp.pushScope(make(map[string]Value))
+	interp.stats = new(Stats)
+	interp.shared = newSharedState()
+	interp.loop = newEventLoop(nil)
+	interp.limits = newLimits(config)
+	interp.permissions = config.Permissions
+	interp.globals = make(map[string]Value)
 	for k, v := range builtins {
-		interp.assign(k, v)
+		interp.globals[k] = v
 	}
 	for k, v := range config.Vars {
-		interp.assign(k, v)
+		interp.globals[k] = v
 	}
 	interp.args = config.Args
 	interp.stdin = config.Stdin
@@ -714,6 +932,10 @@ func newInterpreter(config *Config) *interpreter {
 	if interp.exit == nil {
 		interp.exit = os.Exit
 	}
+	interp.debugger = config.Debugger
+	interp.coverage = config.Coverage
+	interp.profiler = config.Profiler
+	interp.tracer = config.Tracer
 	return interp
 }
 
@@ -724,14 +946,17 @@ type ClassObject struct {
 	Fields  map[string]Value        // Default fields or class-level properties
 }
 
-func (interp *interpreter) newInstance(className string, args []Value) *ClassObject {
+func (interp *interpreter) newInstance(pos Position, className string, args []Value) *ClassObject {
 
 	// Retrieve the class definition from the environment
-	class, ok := interp.lookup(className)
+	class, ok := interp.lookupFrom(interp.env, className)
+	if !ok {
+		panic(nameError(pos, "class %s not found", className))
+	}
+	classObject, ok := class.(*ClassObject)
 	if !ok {
-		panic(fmt.Sprintf("class %s not found", className))
+		panic(typeError(pos, "can't instantiate non-class type %s", typeName(class)))
 	}
-	classObject := class.(*ClassObject)
 
 	// Initialize fields or invoke constructor if necessary
 	// Check if the class has a constructor method
@@ -749,36 +974,100 @@ func (interp *interpreter) newInstance(className string, args []Value) *ClassObj
 // and an error which is nil on success or an interpreter.Error if there's an
 // error.
 func Evaluate(expr parser.Expression, config *Config) (v Value, stats *Stats, err error) {
+	interp := newInterpreter(config)
 	defer func() {
 		if r := recover(); r != nil {
 			// Convert to interpreter.Error or re-panic
-			err = r.(Error)
+			err = interp.spanError(r).(Error)
 		}
 	}()
-	interp := newInterpreter(config)
+	interp.shared.lock.Lock()
+	defer interp.shared.lock.Unlock()
+	defer interp.startLoop()()
+	defer interp.limits.start()()
 	v = interp.evaluate(expr)
-	stats = &interp.stats
+	interp.drain()
+	stats = interp.stats
 	return
 }
 
 // Execute takes a parsed Program and interpreter config and interprets the
 // program. Return interpreter statistics, and an error which is nil on
 // success or an interpreter.Error if there's an error.
+//
+// The program is compiled to bytecode and run by a virtual machine, unless
+// the compiler doesn't support it or config has a Debugger, Coverage,
+// Profiler or Tracer, in which case it's run by the tree-walking evaluator.
+//
+// Goroutines the program spawned, and async calls it left waiting after
+// an error, are stopped when Execute returns.
 func Execute(prog *parser.Program, config *Config) (stats *Stats, err error) {
+	return execute(prog, config, config.compiles())
+}
+
+// compiles reports whether programs run with the config are compiled to
+// bytecode. They aren't if it has a Debugger, Coverage, Profiler or Tracer,
+// which only the tree-walking evaluator supports.
+func (config *Config) compiles() bool {
+	return config.Debugger == nil && config.Coverage == nil &&
+		config.Profiler == nil && config.Tracer == nil
+}
+
+// execute runs prog on the virtual machine if compiled is true and prog
+// compiles, otherwise on the tree-walking evaluator.
+func execute(prog *parser.Program, config *Config, compiled bool) (stats *Stats, err error) {
+	interp := newInterpreter(config)
+	if err := interp.runProgram(prog, compiled); err != nil {
+		return nil, err
+	}
+	return interp.stats, interp.shared.err
+}
+
+// runProgram runs prog in the interpreter's global scope, on the virtual
+// machine if compiled is true and prog compiles, otherwise on the
+// tree-walking evaluator.
+func (interp *interpreter) runProgram(prog *parser.Program, compiled bool) error {
+	var c *code
+	if compiled {
+		c, _ = compile(prog)
+	}
+	if interp.coverage != nil {
+		interp.coverage.add(prog)
+	}
+	return interp.runTopLevel(func() {
+		if c != nil {
+			interp.run(c, nil)
+		} else {
+			interp.execute(prog)
+		}
+	})
+}
+
+// runTopLevel calls f with the interpreter locked and its loop and limits
+// started, then waits for the async calls it left. It returns the
+// interpreter.Error f panics with, if any, and panics again with anything
+// else.
+func (interp *interpreter) runTopLevel(f func()) (err error) {
+	interp.shared.lock.Lock()
+	defer interp.shared.lock.Unlock()
+	defer interp.startLoop()()
+	defer interp.limits.start()()
+	if interp.profiler != nil {
+		// Charge the last line run
+		defer interp.profile(nil, Position{})
+	}
 	defer func() {
 		if r := recover(); r != nil {
-			switch e := r.(type) {
-			case Error:
-				err = e
-			case returnResult:
-				err = runtimeError(e.pos, "can't return at top level")
-			default:
+			// Forget the calls a runtime error unwound
+			interp.depth = 0
+			e, ok := interp.spanError(r).(Error)
+			if !ok {
 				panic(r)
 			}
+			err = e
 		}
 	}()
-	interp := newInterpreter(config)
-	interp.execute(prog)
-	stats = &interp.stats
-	return
+	f()
+	interp.drain()
+	return nil
 }
@@ -0,0 +1,156 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"io"
+	"math"
+	"time"
+)
+
+// How many ops run between checks of the execution context, which are too
+// slow to make on every op.
+const checkInterval = 1024
+
+// limits enforces the execution limits of a Config. It's shared by an
+// interpreter and every interpreter running a spawned goroutine or async
+// task, so the limits apply to the program as a whole.
+type limits struct {
+	maxOps    int
+	maxDepth  int
+	maxOutput int
+	output    int // bytes written so far
+	next      int // Stats.Ops count at which to call check
+
+	base    context.Context
+	timeout time.Duration
+	ctx     context.Context // base with the timeout of the current execution
+}
+
+func newLimits(config *Config) *limits {
+	l := &limits{
+		maxOps:    config.MaxOps,
+		maxDepth:  config.MaxDepth,
+		maxOutput: config.MaxOutput,
+		base:      config.Context,
+		timeout:   config.Timeout,
+	}
+	l.schedule(0)
+	return l
+}
+
+// start starts the wall-clock timeout of an Evaluate or Execute call (or
+// one Repl.Execute call). The returned function must be called when the
+// execution finishes.
+func (l *limits) start() (stop func()) {
+	ctx := l.base
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	stop = func() {}
+	if l.timeout > 0 {
+		ctx, stop = context.WithTimeout(ctx, l.timeout)
+	}
+	if ctx.Done() == nil {
+		// Never cancelled, so don't bother checking it
+		ctx = nil
+	}
+	l.ctx = ctx
+	l.schedule(0)
+	return stop
+}
+
+// schedule sets the op count at which to next call check, given the
+// current op count.
+func (l *limits) schedule(ops int) {
+	l.next = math.MaxInt
+	if l.ctx != nil {
+		l.next = ops + checkInterval
+	}
+	if l.maxOps > 0 && l.maxOps < l.next {
+		l.next = l.maxOps + 1
+	}
+}
+
+// check is called when Stats.Ops reaches l.next, and panics if the op
+// budget is used up or the execution context is done.
+func (l *limits) check(pos Position, ops int) {
+	if l.maxOps > 0 && ops > l.maxOps {
+		panic(runtimeError(pos, "op limit of %d exceeded", l.maxOps))
+	}
+	if l.ctx != nil && l.ctx.Err() != nil {
+		panic(l.cancelled(pos))
+	}
+	l.schedule(ops)
+}
+
+// exceeded reports whether a limit that stops the whole program has been
+// hit, so the error it raised must not be caught by assertThrows().
+func (l *limits) exceeded(ops int) bool {
+	return (l.maxOps > 0 && ops > l.maxOps) ||
+		(l.maxOutput > 0 && l.output >= l.maxOutput) ||
+		(l.ctx != nil && l.ctx.Err() != nil)
+}
+
+// done returns a channel that's closed when the execution context is done,
+// or nil if it can't be.
+func (l *limits) done() <-chan struct{} {
+	if l.ctx == nil {
+		return nil
+	}
+	return l.ctx.Done()
+}
+
+// context returns the execution context, for requests that should be
+// given up when it's done.
+func (l *limits) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// cancelled returns the error for the execution context being done.
+func (l *limits) cancelled(pos Position) error {
+	if errors.Is(l.ctx.Err(), context.DeadlineExceeded) && l.timeout > 0 {
+		return runtimeError(pos, "execution timed out after %s", l.timeout)
+	}
+	return runtimeError(pos, "execution cancelled: %v", l.ctx.Err())
+}
+
+// enter is called at the start of a user function call, and panics if the
+// call is nested too deeply. leave must be called when the call returns.
+func (interp *interpreter) enter(pos Position) {
+	interp.depth++
+	if interp.limits.maxDepth > 0 && interp.depth > interp.limits.maxDepth {
+		interp.depth--
+		panic(runtimeError(pos, "call depth limit of %d exceeded", interp.limits.maxDepth))
+	}
+}
+
+func (interp *interpreter) leave() {
+	interp.depth--
+}
+
+// println writes the operands to the standard output like fmt.Fprintln,
+// panicking if that goes over the output limit. Only the output that fits
+// in the limit is written.
+func (interp *interpreter) println(pos Position, a ...interface{}) {
+	l := interp.limits
+	if l.maxOutput <= 0 {
+		fmt.Fprintln(interp.stdout, a...)
+		return
+	}
+	s := fmt.Sprintln(a...)
+	if l.output+len(s) > l.maxOutput {
+		io.WriteString(interp.stdout, s[:l.maxOutput-l.output])
+		l.output = l.maxOutput
+		panic(runtimeError(pos, "output limit of %d bytes exceeded", l.maxOutput))
+	}
+	l.output += len(s)
+	io.WriteString(interp.stdout, s)
+}
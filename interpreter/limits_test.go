@@ -0,0 +1,111 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"github.com/DavinciScript/Davi/parser"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The server doesn't answer until the request is given up
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	tests := []struct {
+		source string
+		config Config
+		output string
+		err    string
+	}{
+		{`while (true) { }`, Config{MaxOps: 1000}, "", "op limit of 1000 exceeded"},
+		{`echo(1 + 2)`, Config{MaxOps: 1000}, "3\n", ""},
+		{`function f($n) { return f($n + 1); } f(0)`, Config{MaxDepth: 100}, "", "call depth limit of 100 exceeded"},
+		{`function f($n) { if ($n == 0) { return 0; } return f($n - 1); } echo(f(100))`, Config{MaxDepth: 101}, "0\n", ""},
+		{`while (true) { echo("abc") }`, Config{MaxOutput: 10}, "abc\nabc\nab", "output limit of 10 bytes exceeded"},
+		{`echo("abc")`, Config{MaxOutput: 4}, "abc\n", ""},
+		{`while (true) { }`, Config{Timeout: 10 * time.Millisecond}, "", "execution timed out after 10ms"},
+		{`recv(channel())`, Config{Timeout: 10 * time.Millisecond}, "", "execution timed out after 10ms"},
+		{`await sleep(10000)`, Config{Timeout: 10 * time.Millisecond}, "", "execution timed out after 10ms"},
+		{`fileGetContents("` + server.URL + `")`, Config{Timeout: 10 * time.Millisecond}, "", "execution timed out after 10ms"},
+		{`await fileGetContentsAsync("` + server.URL + `")`, Config{Timeout: 10 * time.Millisecond}, "", "execution timed out after 10ms"},
+		{`while (true) { }`, Config{Context: cancelled}, "", "execution cancelled: context canceled"},
+		{`echo(1)`, Config{Context: context.Background(), Timeout: time.Minute}, "1\n", ""},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			var out bytes.Buffer
+			config := test.config
+			config.Stdout = &out
+			_, err := execute(prog, &config, compiled)
+			if test.err == "" && err != nil {
+				t.Errorf("%s (compiled %v): unexpected error %v", test.source, compiled, err)
+			}
+			if test.err != "" {
+				if _, ok := err.(RuntimeError); !ok || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("%s (compiled %v): expected runtime error %q, got %v", test.source, compiled, test.err, err)
+				}
+			}
+			if out.String() != test.output {
+				t.Errorf("%s (compiled %v): expected output %q, got %q", test.source, compiled, test.output, out.String())
+			}
+		}
+	}
+}
+
+func TestStopsGoroutines(t *testing.T) {
+	tests := []struct {
+		source string
+		config Config
+	}{
+		// Suspended tasks and pending timers left by a timeout
+		{`async function f() { await sleep(20); } $p = f(); $q = sleep(20); while (true) { }`, Config{Timeout: 10 * time.Millisecond}},
+		{`async function f() { await sleep(20); } $p = f(); await sleep(1); while (true) { }`, Config{Timeout: 10 * time.Millisecond}},
+		// Spawned goroutines still running, or blocked, when the program ends
+		{`function f() { while (true) { } } spawn f()`, Config{}},
+		{`function f() { recv(channel()); } spawn f(); spawn f()`, Config{}},
+		{`async function g() { await sleep(20); } function f() { await g(); } spawn f()`, Config{}},
+		{`function f() { while (true) { } } spawn f(); while (true) { }`, Config{Timeout: 10 * time.Millisecond}},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			before := runtime.NumGoroutine()
+			for i := 0; i < 5; i++ {
+				config := test.config
+				config.Stdout = &bytes.Buffer{}
+				execute(prog, &config, compiled)
+			}
+			// Stopped goroutines exit once they next wake up
+			deadline := time.Now().Add(time.Second)
+			for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if n := runtime.NumGoroutine(); n > before {
+				t.Errorf("%s (compiled %v): %d goroutines left running", test.source, compiled, n-before)
+			}
+		}
+	}
+}
@@ -5,67 +5,560 @@ package interpreter
 import (
 	"fmt"
 	. "github.com/DavinciScript/Davi/lexer"
+	"math"
 	"reflect"
+	"strconv"
 )
 
+// nativeFunction is a Go function registered with RegisterFunc. Its
+// arguments are converted from Davi values to the types of its parameters,
+// and its result is converted back to a Davi value.
 type nativeFunction struct {
 	Function reflect.Value
 	Name     string
 }
 
+var (
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	functionPtrType = reflect.TypeOf((*Function)(nil))
+	bytesType       = reflect.TypeOf([]byte(nil))
+)
+
+// checkNativeFunction returns an error if f can't be called from Davi: it
+// must be a function returning at most one value, optionally followed by
+// an error.
+func checkNativeFunction(f reflect.Value) error {
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return fmt.Errorf("%s is not a function", f.Type())
+	}
+	t := f.Type()
+	switch {
+	case t.NumOut() > 2:
+		return fmt.Errorf("function %s returns more than 2 results", t)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return fmt.Errorf("function %s returns 2 results but the second isn't an error", t)
+	}
+	return nil
+}
+
 func (f nativeFunction) call(interp *interpreter, pos Position, args []Value) Value {
-	var getValue func(v reflect.Value) Value
-	getValue = func(v reflect.Value) Value {
-		x := v.Interface()
-		switch v.Kind() {
-		case reflect.Int:
-			return Value(x)
-		case reflect.Int8:
-			return Value(x.(int))
-		case reflect.Int16:
-			return Value(x.(int))
-		// case reflect.Int32:
-		// 	return Value(x.(int))
-		case reflect.Int64:
-			return Value(x.(int))
-		case reflect.Slice, reflect.Array:
-			values := make([]Value, v.Len())
-			for i := 0; i < v.Len(); i++ {
-				values[i] = getValue(v.Index(i))
-			}
-			return Value(&values)
-		case reflect.String:
-			return Value(x)
-		}
-		panic(runtimeError(pos, fmt.Sprintf("native function returned invalid type %s", v.Kind())))
-	}
-	// Uint
-	// Uint8
-	// Uint16
-	// Uint32
-	// Uint64
-	// Uintptr
-	// Interface
-	// Map
-	// String
-	// Struct
-
-	// TODO: catch panics, convert args
-	values := make([]reflect.Value, len(args))
-	for i, a := range args {
-		values[i] = reflect.ValueOf(a)
+	t := f.Function.Type()
+	numIn := t.NumIn()
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			panic(typeError(pos, "%s() requires at least %d args, got %d", f.Name, numIn-1, len(args)))
+		}
+	} else {
+		ensureNumArgs(pos, f.Name, args, numIn)
+	}
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			paramType = t.In(numIn - 1).Elem()
+		} else {
+			paramType = t.In(i)
+		}
+		v, err := interp.toGo(pos, arg, paramType)
+		if err != nil {
+			panic(typeError(pos, "%s() argument %d: %v", f.Name, i+1, err))
+		}
+		in[i] = v
 	}
 	interp.stats.BuiltinCalls++
-	results := f.Function.Call(values)
+	results := f.callGo(pos, in)
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1].Interface(); err != nil {
+			if e, ok := err.(Error); ok {
+				// An error from a Davi function the Go function called
+				panic(e)
+			}
+			panic(runtimeError(pos, "%s() error: %v", f.Name, err))
+		}
+		results = results[:n-1]
+	}
 	if len(results) == 0 {
 		return Value(nil)
-	} else if len(results) == 1 {
-		return getValue(results[0])
-	} else {
-		panic(runtimeError(pos, fmt.Sprintf("native function must return 0 or 1 result, not %d", len(results))))
 	}
+	v, err := fromGo(results[0])
+	if err != nil {
+		panic(typeError(pos, "%s() result: %v", f.Name, err))
+	}
+	return v
+}
+
+// callGo calls the Go function, turning a panic into a RuntimeError.
+func (f nativeFunction) callGo(pos Position, in []reflect.Value) []reflect.Value {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(Error); ok {
+				// An error from a Davi function the Go function called
+				panic(e)
+			}
+			panic(runtimeError(pos, "%s() panicked: %v", f.Name, r))
+		}
+	}()
+	return f.Function.Call(in)
 }
 
 func (f nativeFunction) name() string {
 	return fmt.Sprintf("<native %s>", f.Name)
 }
+
+// Function is a Davi function passed to a Go function, which can call it
+// back. A Go function parameter of type *Function accepts any Davi
+// function.
+type Function struct {
+	interp *interpreter
+	f      functionType
+	pos    Position
+}
+
+// Call calls the Davi function with args converted to Davi values (as a Go
+// function's result is), returning its result converted to a Go value (as
+// for an interface{} parameter). The error is nil on success or an
+// interpreter.Error. Call must only be used while the Go function the
+// Function was passed to is running.
+func (f *Function) Call(args ...interface{}) (result interface{}, err error) {
+	if f.interp == nil {
+		return nil, runtimeError(f.pos, "can't call %s after its program has finished", f.f.name())
+	}
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		v, err := fromGo(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, typeError(f.pos, "argument %d: %v", i+1, err)
+		}
+		values[i] = v
+	}
+	raising := f.interp.raising
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := f.interp.spanError(r).(Error)
+			f.interp.raising = raising
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	return toGoInterface(f.interp, f.pos, f.interp.callFunction(f.pos, f.f, values)), nil
+}
+
+func (f *Function) String() string {
+	return f.f.name()
+}
+
+// toGo converts a Davi value to a Go value of type t.
+func (interp *interpreter) toGo(pos Position, v Value, t reflect.Type) (reflect.Value, error) {
+	if t == functionPtrType {
+		if f, ok := v.(functionType); ok {
+			return reflect.ValueOf(&Function{interp, f, pos}), nil
+		}
+		return reflect.Value{}, cantConvert(v, t)
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		result := reflect.New(t).Elem()
+		if t.NumMethod() == 0 {
+			if v := toGoInterface(interp, pos, v); v != nil {
+				result.Set(reflect.ValueOf(v))
+			}
+			return result, nil
+		}
+		if v != nil && reflect.TypeOf(v).Implements(t) {
+			result.Set(reflect.ValueOf(v))
+			return result, nil
+		}
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			return reflect.ValueOf(b).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := v.(int); ok {
+			result := reflect.New(t).Elem()
+			if result.OverflowInt(int64(n)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows Go %s", n, t)
+			}
+			result.SetInt(int64(n))
+			return result, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := v.(int); ok {
+			result := reflect.New(t).Elem()
+			if n < 0 || result.OverflowUint(uint64(n)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows Go %s", n, t)
+			}
+			result.SetUint(uint64(n))
+			return result, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := v.(int); ok {
+			return reflect.ValueOf(float64(n)).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Slice:
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		if s, ok := v.(string); ok && bytesType.ConvertibleTo(t) {
+			return reflect.ValueOf([]byte(s)).Convert(t), nil
+		}
+		if list, ok := v.(*[]Value); ok {
+			slice := reflect.MakeSlice(t, len(*list), len(*list))
+			for i, item := range *list {
+				elem, err := interp.toGo(pos, item, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("item %d: %v", i, err)
+				}
+				slice.Index(i).Set(elem)
+			}
+			return slice, nil
+		}
+	case reflect.Array:
+		if list, ok := v.(*[]Value); ok {
+			if len(*list) != t.Len() {
+				return reflect.Value{}, fmt.Errorf("can't convert list of %d items to Go %s", len(*list), t)
+			}
+			array := reflect.New(t).Elem()
+			for i, item := range *list {
+				elem, err := interp.toGo(pos, item, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("item %d: %v", i, err)
+				}
+				array.Index(i).Set(elem)
+			}
+			return array, nil
+		}
+	case reflect.Map:
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		if m, ok := v.(map[string]Value); ok {
+			result := reflect.MakeMapWithSize(t, len(m))
+			for key, item := range m {
+				k, err := mapKeyToGo(key, t.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				elem, err := interp.toGo(pos, item, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %q: %v", key, err)
+				}
+				result.SetMapIndex(k, elem)
+			}
+			return result, nil
+		}
+	case reflect.Struct:
+		switch v := v.(type) {
+		case map[string]Value:
+			return interp.structFromMap(pos, v, t)
+		case *ClassObject:
+			return interp.structFromMap(pos, v.Fields, t)
+		}
+	case reflect.Ptr:
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		elem, err := interp.toGo(pos, v, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	case reflect.Func:
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		if f, ok := v.(functionType); ok {
+			return interp.goFunc(pos, f, t), nil
+		}
+	}
+	return reflect.Value{}, cantConvert(v, t)
+}
+
+func cantConvert(v Value, t reflect.Type) error {
+	return fmt.Errorf("can't convert %s to Go %s", typeName(v), t)
+}
+
+// mapKeyToGo converts the key of a Davi map to a Go map key of type t,
+// which may be a string or an integer type.
+func mapKeyToGo(key string, t reflect.Type) (reflect.Value, error) {
+	result := reflect.New(t).Elem()
+	var err error
+	switch t.Kind() {
+	case reflect.String:
+		result.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(key, 10, t.Bits()); err == nil {
+			result.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		if n, err = strconv.ParseUint(key, 10, t.Bits()); err == nil {
+			result.SetUint(n)
+		}
+	default:
+		return reflect.Value{}, fmt.Errorf("can't convert map to Go map with %s keys", t)
+	}
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("can't convert map key %q to Go %s", key, t)
+	}
+	return result, nil
+}
+
+// mapKeyFromGo converts a Go map key to the key of a Davi map.
+func mapKeyFromGo(key reflect.Value) (string, bool) {
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+	return "", false
+}
+
+// structFromMap converts a Davi map to a Go struct, by field name (or the
+// name in a `davi:"name"` field tag). Fields missing from the map are left
+// as zero values.
+func (interp *interpreter) structFromMap(pos Position, m map[string]Value, t reflect.Type) (reflect.Value, error) {
+	result := reflect.New(t).Elem()
+	fields := structFields(t)
+	for key, item := range m {
+		index, ok := fields[key]
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("%s has no field %q", t, key)
+		}
+		field, err := interp.toGo(pos, item, t.Field(index).Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field %s: %v", key, err)
+		}
+		result.Field(index).Set(field)
+	}
+	return result, nil
+}
+
+// structFields returns the indexes of the exported fields of struct type t
+// by their Davi names.
+func structFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag := field.Tag.Get("davi"); tag != "" {
+			name = tag
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+// goFunc wraps a Davi function as a Go function of type t. If the last
+// result of t is an error, Davi errors are returned as it; otherwise they
+// panic through the Go code that called the function.
+func (interp *interpreter) goFunc(pos Position, f functionType, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) (out []reflect.Value) {
+		out = make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		if n := t.NumOut(); n > 0 && t.Out(n-1) == errorType {
+			raising := interp.raising
+			defer func() {
+				if r := recover(); r != nil {
+					e, ok := interp.spanError(r).(Error)
+					interp.raising = raising
+					if !ok {
+						panic(r)
+					}
+					out[n-1] = reflect.New(errorType).Elem()
+					out[n-1].Set(reflect.ValueOf(e))
+				}
+			}()
+		}
+		args := make([]Value, len(in))
+		for i, arg := range in {
+			v, err := fromGo(arg)
+			if err != nil {
+				panic(typeError(pos, "argument %d of Go callback: %v", i+1, err))
+			}
+			args[i] = v
+		}
+		result := interp.callFunction(pos, f, args)
+		if t.NumOut() > 0 && t.Out(0) != errorType {
+			v, err := interp.toGo(pos, result, t.Out(0))
+			if err != nil {
+				panic(typeError(pos, "result of Go callback: %v", err))
+			}
+			out[0] = v
+		}
+		return out
+	})
+}
+
+// toGoInterface converts a Davi value to its natural Go form: lists become
+// []interface{}, maps map[string]interface{} and functions *Function
+// (which can only be called if interp isn't nil); other values are
+// returned as is.
+func toGoInterface(interp *interpreter, pos Position, v Value) interface{} {
+	switch v := v.(type) {
+	case *[]Value:
+		list := make([]interface{}, len(*v))
+		for i, item := range *v {
+			list[i] = toGoInterface(interp, pos, item)
+		}
+		return list
+	case map[string]Value:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = toGoInterface(interp, pos, item)
+		}
+		return m
+	case functionType:
+		return &Function{interp, v, pos}
+	default:
+		return v
+	}
+}
+
+// fromGo converts a Go value to a Davi value.
+func fromGo(v reflect.Value) (Value, error) {
+	return fromGoIn(v, map[goReference]bool{})
+}
+
+// goReference is a Go pointer, map or slice: its type, the address it
+// refers to and, for a slice, its length.
+type goReference struct {
+	t   reflect.Type
+	ptr uintptr
+	len int
+}
+
+// fromGoIn converts v, which is inside the pointers, maps and slices in
+// outer, to a Davi value. It returns an error if v refers back to one of
+// them, as following it would never end.
+func fromGoIn(v reflect.Value, outer map[goReference]bool) (Value, error) {
+	if !v.IsValid() {
+		return Value(nil), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			ref := goReference{t: v.Type(), ptr: v.Pointer()}
+			if v.Kind() == reflect.Slice {
+				ref.len = v.Len()
+			}
+			if outer[ref] {
+				return nil, fmt.Errorf("Go %s refers back to itself", v.Type())
+			}
+			outer[ref] = true
+			defer delete(outer, ref)
+		}
+	}
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case *[]Value, map[string]Value, functionType, *ClassObject, *Channel, *WaitGroup, *Mutex, *Promise:
+			return Value(x), nil
+		case *Function:
+			if x != nil {
+				return Value(x.f), nil
+			}
+		case error:
+			if v.Kind() != reflect.Ptr || !v.IsNil() {
+				return Value(x.Error()), nil
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return Value(nil), nil
+		}
+		return fromGoIn(v.Elem(), outer)
+	case reflect.Bool:
+		return Value(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n < math.MinInt || n > math.MaxInt {
+			return nil, fmt.Errorf("Go %s %d overflows int", v.Type(), n)
+		}
+		return Value(int(n)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt {
+			return nil, fmt.Errorf("Go %s %d overflows int", v.Type(), n)
+		}
+		return Value(int(n)), nil
+	case reflect.Float32, reflect.Float64:
+		// Davi has no floats, so only whole numbers can be converted
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt || f >= math.MaxInt {
+			return nil, fmt.Errorf("Go %s %v isn't a whole number in the range of int", v.Type(), f)
+		}
+		return Value(int(f)), nil
+	case reflect.String:
+		return Value(v.String()), nil
+	case reflect.Ptr:
+		if v.IsNil() {
+			return Value(nil), nil
+		}
+		return fromGoIn(v.Elem(), outer)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return Value(string(v.Bytes())), nil
+		}
+		list := make([]Value, v.Len())
+		for i := range list {
+			item, err := fromGoIn(v.Index(i), outer)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			list[i] = item
+		}
+		return Value(&list), nil
+	case reflect.Map:
+		m := make(map[string]Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, ok := mapKeyFromGo(iter.Key())
+			if !ok {
+				return nil, fmt.Errorf("can't convert Go map with %s keys to a Davi map", v.Type().Key())
+			}
+			item, err := fromGoIn(iter.Value(), outer)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %v", key, err)
+			}
+			m[key] = item
+		}
+		return Value(m), nil
+	case reflect.Struct:
+		m := make(map[string]Value)
+		for name, index := range structFields(v.Type()) {
+			item, err := fromGoIn(v.Field(index), outer)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", name, err)
+			}
+			m[name] = item
+		}
+		return Value(m), nil
+	case reflect.Func:
+		if v.IsNil() {
+			return Value(nil), nil
+		}
+		if err := checkNativeFunction(v); err != nil {
+			return nil, err
+		}
+		return Value(nativeFunction{v, "func"}), nil
+	}
+	return nil, fmt.Errorf("can't convert Go %s to a Davi value", v.Type())
+}
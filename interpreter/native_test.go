@@ -0,0 +1,146 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/DavinciScript/Davi/parser"
+	"math"
+	"strings"
+	"testing"
+)
+
+type account struct {
+	Name    string
+	Balance uint16
+	Owner   *account
+}
+
+func TestNativeConversions(t *testing.T) {
+	funcs := map[string]interface{}{
+		"int8":    func(n int8) int8 { return n },
+		"int64":   func(n int64) int64 { return n * 2 },
+		"uint8":   func(n uint8) uint8 { return n },
+		"uint64":  func(n uint64) uint64 { return n },
+		"bigUint": func() uint64 { return math.MaxUint64 },
+		"half":    func(n float64) float64 { return n / 2 },
+		"float32": func(n float32) float32 { return n },
+		"bytes":   func(b []byte) []byte { return bytes.ToUpper(b) },
+		"array":   func(a [3]int) [2]int { return [2]int{a[0] + a[1], a[2]} },
+		"intKeys": func(m map[int]string) map[uint8]string { return map[uint8]string{uint8(len(m)): m[-1]} },
+		"ptr": func(p *int) *int {
+			if p == nil {
+				return nil
+			}
+			n := *p + 1
+			return &n
+		},
+		"account":  func(a account) *account { a.Balance++; return &a },
+		"nested":   func(a *account) string { return a.Owner.Name },
+		"err":      func() error { return errors.New("bad") },
+		"errValue": func() interface{} { return errors.New("message") },
+		"boom":     func() int { panic("boom") },
+		"index":    func(list []int, i int) int { return list[i] },
+		"call":     func(f func() int) int { return f() },
+		"cycle": func() *account {
+			a := &account{Name: "a"}
+			a.Owner = a
+			return a
+		},
+		"shared": func() []*account {
+			a := &account{Name: "shared"}
+			return []*account{a, a}
+		},
+		"selfMap": func() map[string]interface{} {
+			m := map[string]interface{}{}
+			m["self"] = m
+			return m
+		},
+	}
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		{`echo(int8(-128), int64(21), uint8(255), uint64(7))`, "-128 42 255 7\n", ""},
+		{`int8(128)`, "", "int8() argument 1: 128 overflows Go int8"},
+		{`uint8(-1)`, "", "uint8() argument 1: -1 overflows Go uint8"},
+		{`bigUint()`, "", "bigUint() result: Go uint64 18446744073709551615 overflows int"},
+		{`echo(half(4), float32(3))`, "2 3\n", ""},
+		{`half(3)`, "", "half() result: Go float64 1.5 isn't a whole number"},
+		{`echo(bytes("abc"))`, "ABC\n", ""},
+		{`echo(array([1, 2, 3]))`, "[3, 3]\n", ""},
+		{`array([1, 2])`, "", "array() argument 1: can't convert list of 2 items to Go [3]int"},
+		{`echo(intKeys({"-1": "minus one", "2": "two"}))`, "{\"2\": \"minus one\"}\n", ""},
+		{`intKeys({"x": "y"})`, "", `intKeys() argument 1: can't convert map key "x" to Go int`},
+		{`echo(ptr(1), ptr(nil))`, "2 nil\n", ""},
+		{`$a = account({"Name": "a", "Balance": 1}); echo($a["Name"], $a["Balance"], $a["Owner"])`, "a 2 nil\n", ""},
+		{`echo(nested({"Owner": {"Name": "owner"}}))`, "owner\n", ""},
+		{`class Account { $Name = "object"; $Balance = 5; } $a = account(new Account()); echo($a["Name"], $a["Balance"])`, "object 6\n", ""},
+		{`err()`, "", "runtime error at 1:1: err() error: bad"},
+		{`echo(errValue())`, "message\n", ""},
+		{`boom()`, "", "runtime error at 1:1: boom() panicked: boom"},
+		{`index([1], 5)`, "", "runtime error at 1:1: index() panicked: runtime error: index out of range [5] with length 1"},
+		{`call(function() { return 1 + "a"; })`, "", "type error at 1:28: + requires"},
+		{`cycle()`, "", "cycle() result: field Owner: Go *interpreter.account refers back to itself"},
+		{`echo(shared()[1]["Name"])`, "shared\n", ""},
+		{`selfMap()`, "", `selfMap() result: key "self": Go map[string]interface {} refers back to itself`},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		i := New(&Config{Stdout: &out})
+		for name, f := range funcs {
+			if err := i.RegisterFunc(name, f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		_, err = i.Execute(prog)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.source, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.source, test.err, err)
+		}
+		if out.String() != test.output {
+			t.Errorf("%s: expected output %q, got %q", test.source, test.output, out.String())
+		}
+	}
+}
+
+func TestNativeSet(t *testing.T) {
+	n := 5
+	var out bytes.Buffer
+	i := New(&Config{Stdout: &out})
+	values := map[string]interface{}{
+		"ptr":    &n,
+		"nilPtr": (*int)(nil),
+		"floats": []float64{1, 2},
+		"keys":   map[int64]bool{3: true},
+		"fixed":  [2]string{"a", "b"},
+	}
+	for name, v := range values {
+		if err := i.Set(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := i.Set("complex", 1i); err == nil {
+		t.Error("expected error setting a complex number")
+	}
+	prog, err := parser.ParseProgram([]byte(`echo(ptr, nilPtr, floats, keys, fixed)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := i.Execute(prog); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintln(`5 nil [1, 2] {"3": true} ["a", "b"]`)
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
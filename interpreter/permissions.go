@@ -0,0 +1,138 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Permissions sandboxes a script for running untrusted code: the builtins
+// that reach outside the interpreter may only do what's allowed here. Set
+// Config.Permissions to a zero Permissions to deny everything.
+type Permissions struct {
+	// FileRoots are the directories whose files (including files in
+	// subdirectories) read() may read. Symbolic links are followed before
+	// checking, so a link can't be used to escape a root.
+	FileRoots []string
+
+	// Hosts are the hosts fileGetContents() and fileGetContentsAsync() may
+	// fetch from, as "example.com" (any port) or "example.com:8080". This
+	// also applies to any redirects.
+	Hosts []string
+
+	// Exit allows exit() to call Config.Exit, which by default exits the
+	// process.
+	Exit bool
+
+	// Listen allows httpRegister() and httpListen() to serve HTTP.
+	Listen bool
+}
+
+// permissionError returns the error for a builtin doing something the
+// sandbox doesn't allow.
+func permissionError(pos Position, format string, args ...interface{}) error {
+	return runtimeError(pos, "permission denied: "+format, args...)
+}
+
+// allowFile panics if the sandbox doesn't allow reading the named file.
+func (interp *interpreter) allowFile(pos Position, name string) {
+	p := interp.permissions
+	if p == nil {
+		return
+	}
+	path := resolvePath(name)
+	for _, root := range p.FileRoots {
+		rel, err := filepath.Rel(resolvePath(root), path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return
+		}
+	}
+	panic(permissionError(pos, "can't read %q outside the allowed file roots", name))
+}
+
+// resolvePath returns the absolute path of name with symbolic links
+// evaluated, as far as they can be (the file may not exist).
+func resolvePath(name string) string {
+	path, err := filepath.Abs(name)
+	if err != nil {
+		return filepath.Clean(name)
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// allowURL returns an error if the sandbox doesn't allow fetching u.
+func (p *Permissions) allowURL(u *url.URL) error {
+	if p == nil {
+		return nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs are allowed")
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	for _, allowed := range p.Hosts {
+		allowed = strings.ToLower(allowed)
+		if allowed == host || allowed == net.JoinHostPort(host, port) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not allowed", u.Host)
+}
+
+// httpClient returns the client for fetching rawURL, panicking if the
+// sandbox doesn't allow it. The client checks redirects too.
+func (interp *interpreter) httpClient(pos Position, name, rawURL string) *http.Client {
+	p := interp.permissions
+	if p == nil {
+		return http.DefaultClient
+	}
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		err = p.allowURL(u)
+	}
+	if err != nil {
+		panic(permissionError(pos, "%s() can't fetch %q: %v", name, rawURL, err))
+	}
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if err := p.allowURL(req.URL); err != nil {
+				return fmt.Errorf("permission denied: redirect to %s: %v", req.URL, err)
+			}
+			return nil
+		},
+	}
+}
+
+// allowExit panics if the sandbox doesn't allow exit().
+func (interp *interpreter) allowExit(pos Position) {
+	if p := interp.permissions; p != nil && !p.Exit {
+		panic(permissionError(pos, "exit() is not allowed in the sandbox"))
+	}
+}
+
+// allowListen panics if the sandbox doesn't allow the named builtin to
+// serve HTTP.
+func (interp *interpreter) allowListen(pos Position, name string) {
+	if p := interp.permissions; p != nil && !p.Listen {
+		panic(permissionError(pos, "%s() is not allowed in the sandbox", name))
+	}
+}
@@ -0,0 +1,88 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	inside := filepath.Join(allowed, "inside.txt")
+	outside := filepath.Join(dir, "outside.txt")
+	link := filepath.Join(allowed, "link.txt")
+	for _, name := range []string{inside, outside} {
+		if err := os.WriteFile(name, []byte("contents"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("other"))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			// Same host as the allowed server, but a different port
+			http.Redirect(w, r, other.URL, http.StatusFound)
+			return
+		}
+		w.Write([]byte("fetched"))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	sandbox := &Permissions{FileRoots: []string{allowed}, Hosts: []string{host}}
+	tests := []struct {
+		source      string
+		permissions *Permissions
+		output      string
+		err         string
+	}{
+		{`echo(read(` + strconv.Quote(inside) + `))`, sandbox, "contents\n", ""},
+		{`echo(read(` + strconv.Quote(outside) + `))`, sandbox, "", "permission denied: can't read"},
+		{`echo(read(` + strconv.Quote(link) + `))`, sandbox, "", "permission denied: can't read"},
+		{`echo(read(` + strconv.Quote(outside) + `))`, nil, "contents\n", ""},
+		{`echo(fileGetContents("` + server.URL + `"))`, sandbox, "fetched\n", ""},
+		{`echo(await fileGetContentsAsync("` + server.URL + `"))`, sandbox, "fetched\n", ""},
+		{`echo(fileGetContents("` + other.URL + `"))`, sandbox, "", "permission denied: fileGetContents() can't fetch"},
+		{`echo(fileGetContents("` + server.URL + `/redirect"))`, sandbox, "", "permission denied: redirect"},
+		{`echo(fileGetContents("` + other.URL + `"))`, nil, "other\n", ""},
+		{`exit(3)`, sandbox, "", "permission denied: exit() is not allowed"},
+		{`httpListen(":0")`, sandbox, "", "permission denied: httpListen() is not allowed"},
+		{`httpRegister("/", function() { return 1; })`, sandbox, "", "permission denied: httpRegister() is not allowed"},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		var out bytes.Buffer
+		config := &Config{Stdout: &out, Permissions: test.permissions}
+		_, err = Execute(prog, config)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.source, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got %v", test.source, test.err, err)
+		}
+		if out.String() != test.output {
+			t.Errorf("%s: expected output %q, got %q", test.source, test.output, out.String())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Profiler records where a program spends its time and ops, by call stack
+// and source line, for profiles such as "davi run --profile". Set
+// Config.Profiler to profile a program. Programs run with a Profiler are
+// run by the tree-walking evaluator, like programs run with a Debugger.
+//
+// Time is wall-clock time, so a line that waits (on a channel, a promise
+// or I/O) is charged for the time it waits. The time and ops between one
+// statement starting and the next are charged to the first.
+type Profiler struct {
+	mu   sync.Mutex
+	root *profileNode
+}
+
+// ProfileSample is the time and ops spent running one line with one call
+// stack.
+type ProfileSample struct {
+	Stack    []ProfileFrame // innermost first, ending with the top level
+	Duration time.Duration
+	Ops      int
+}
+
+// ProfileFrame is a function in a call stack and the position it's
+// running at: the line being run for the innermost frame, and otherwise
+// the call to the next frame in.
+type ProfileFrame struct {
+	// Function is "main" for the top level and "<anonymous>" for function
+	// expressions, as in DebugState.Stack and TraceEvent.
+	Function string
+	Position Position
+}
+
+// profileNode is a call stack in the profile's call tree.
+type profileNode struct {
+	function string
+	call     Position // where it was called from in the parent
+	parent   *profileNode
+	children map[profileCall]*profileNode
+	lines    map[Position]*profileCost // keyed by file and line
+}
+
+type profileCall struct {
+	function string
+	call     Position
+}
+
+type profileCost struct {
+	duration time.Duration
+	ops      int
+}
+
+// profileCursor is where an interpreter is in the call tree, and when it
+// got there. Each goroutine and async task has its own.
+type profileCursor struct {
+	node *profileNode
+	pos  Position // of the line being run
+	time time.Time
+	ops  int
+}
+
+// NewProfiler returns a new Profiler that hasn't recorded anything yet.
+func NewProfiler() *Profiler {
+	return &Profiler{root: newProfileNode("main", Position{}, nil)}
+}
+
+func newProfileNode(function string, call Position, parent *profileNode) *profileNode {
+	return &profileNode{
+		function: function,
+		call:     call,
+		parent:   parent,
+		children: make(map[profileCall]*profileNode),
+		lines:    make(map[Position]*profileCost),
+	}
+}
+
+// profile charges the time and ops since the interpreter's profile cursor
+// last moved to the line it was at, then moves it to pos in node, or to
+// pos in the same node if node is nil. Nothing is charged while the cursor
+// has no line, between a call and the first statement of the function.
+func (interp *interpreter) profile(node *profileNode, pos Position) {
+	c := &interp.profileCursor
+	now := time.Now()
+	if c.node != nil && c.pos.Line != 0 && !c.time.IsZero() {
+		p := interp.profiler
+		p.mu.Lock()
+		key := Position{Line: c.pos.Line, File: c.pos.File}
+		cost := c.node.lines[key]
+		if cost == nil {
+			cost = &profileCost{}
+			c.node.lines[key] = cost
+		}
+		cost.duration += now.Sub(c.time)
+		cost.ops += interp.stats.Ops - c.ops
+		p.mu.Unlock()
+	}
+	if node != nil {
+		c.node = node
+	} else if c.node == nil {
+		c.node = interp.profiler.root
+	}
+	c.pos = pos
+	c.time = now
+	c.ops = interp.stats.Ops
+}
+
+// profileCall moves the interpreter's profile cursor into a call of the
+// named function from pos.
+func (interp *interpreter) profileCall(name string, pos Position) {
+	if name == "" {
+		name = "<anonymous>"
+	}
+	p := interp.profiler
+	parent := interp.profileCursor.node
+	if parent == nil {
+		parent = p.root
+	}
+	key := profileCall{name, Position{Line: pos.Line, File: pos.File}}
+	p.mu.Lock()
+	node := parent.children[key]
+	if node == nil {
+		node = newProfileNode(name, key.call, parent)
+		parent.children[key] = node
+	}
+	p.mu.Unlock()
+	interp.profile(node, Position{})
+}
+
+// profileReturn moves the interpreter's profile cursor out of a call,
+// back to the line that made it.
+func (interp *interpreter) profileReturn() {
+	node := interp.profileCursor.node
+	interp.profile(node.parent, node.call)
+}
+
+// Samples returns the samples recorded so far, ordered by their stacks.
+func (p *Profiler) Samples() []ProfileSample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	samples := []ProfileSample{}
+	var walk func(node *profileNode)
+	walk = func(node *profileNode) {
+		for pos, cost := range node.lines {
+			stack := []ProfileFrame{{node.function, pos}}
+			for n := node; n.parent != nil; n = n.parent {
+				stack = append(stack, ProfileFrame{n.parent.function, n.call})
+			}
+			samples = append(samples, ProfileSample{stack, cost.duration, cost.ops})
+		}
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(p.root)
+	sort.Slice(samples, func(i, j int) bool {
+		return stackLess(samples[i].Stack, samples[j].Stack)
+	})
+	return samples
+}
+
+// stackLess orders stacks from the outermost frame in.
+func stackLess(a, b []ProfileFrame) bool {
+	for i, j := len(a)-1, len(b)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		fa, fb := a[i], b[j]
+		if fa.Function != fb.Function {
+			return fa.Function < fb.Function
+		}
+		if fa.Position.File != fb.Position.File {
+			return fa.Position.File < fb.Position.File
+		}
+		if fa.Position.Line != fb.Position.Line {
+			return fa.Position.Line < fb.Position.Line
+		}
+	}
+	return len(a) < len(b)
+}
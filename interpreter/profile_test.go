@@ -0,0 +1,69 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	source := `
+function double($n) {
+    return $n * 2;
+}
+function twice($n) {
+    return double(double($n));
+}
+$g = function() { return twice(1); };
+echo(twice(3) + $g());
+`
+	prog, err := parser.ParseFile("p.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiler := NewProfiler()
+	var out bytes.Buffer
+	if _, err := Execute(prog, &Config{Stdout: &out, Profiler: profiler}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "16\n" {
+		t.Fatalf("expected output 16, got %q", out.String())
+	}
+
+	stacks := []string{}
+	for _, s := range profiler.Samples() {
+		frames := []string{}
+		for _, frame := range s.Stack {
+			frames = append(frames, fmt.Sprintf("%s:%d", frame.Function, frame.Position.Line))
+		}
+		stacks = append(stacks, strings.Join(frames, " "))
+		if s.Duration < 0 {
+			t.Errorf("%s: negative duration %s", stacks[len(stacks)-1], s.Duration)
+		}
+	}
+	expected := []string{
+		"main:2",
+		"main:5",
+		"main:8",
+		"main:9",
+		"<anonymous>:8 main:9",
+		"twice:6 <anonymous>:8 main:9",
+		"double:3 twice:6 <anonymous>:8 main:9",
+		"twice:6 main:9",
+		"double:3 twice:6 main:9",
+	}
+	if strings.Join(stacks, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected stacks\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(stacks, "\n"))
+	}
+
+	// Both calls of double from a line of twice are charged to one stack
+	for _, s := range profiler.Samples() {
+		if s.Stack[0].Function == "double" && s.Ops == 0 {
+			t.Errorf("expected ops charged to double")
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"github.com/DavinciScript/Davi/parser"
+	"sort"
+)
+
+// Repl runs programs one after the other against the same global scope, for
+// an interactive read-eval-print loop.
+type Repl struct {
+	interp *interpreter
+}
+
+// NewRepl returns a Repl whose interpreter is configured by config.
+func NewRepl(config *Config) *Repl {
+	return &Repl{newInterpreter(config)}
+}
+
+// Execute runs prog in the Repl's global scope. If the last statement of
+// prog is an expression, its value is returned, otherwise the value is nil.
+// The error is nil on success or an interpreter.Error if there's an error.
+func (r *Repl) Execute(prog *parser.Program) (v Value, err error) {
+	interp := r.interp
+	// Trailing semicolons parse as separate statements, so skip them when
+	// looking for the last expression
+	statements := prog.Statements
+	for len(statements) > 0 && isSemiTag(statements[len(statements)-1]) {
+		statements = statements[:len(statements)-1]
+	}
+	err = interp.runTopLevel(func() {
+		for i, s := range statements {
+			if e, ok := s.(*parser.ExpressionStatement); ok && i == len(statements)-1 {
+				v = interp.evaluate(e.Expression)
+			} else if c := interp.executeStatement(s); c.kind == completedReturn {
+				interp.raising = nil
+				panic(runtimeError(c.pos, "can't return at top level"))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func isSemiTag(s parser.Statement) bool {
+	if e, ok := s.(*parser.ExpressionStatement); ok {
+		_, ok = e.Expression.(*parser.SemiTag)
+		return ok
+	}
+	return false
+}
+
+// Names returns the sorted names of all global variables, including
+// builtin functions.
+func (r *Repl) Names() []string {
+	r.interp.shared.lock.Lock()
+	defer r.interp.shared.lock.Unlock()
+	names := []string{}
+	for name := range r.interp.globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ToString returns the string representation of a value, with strings
+// quoted if quoteStr is true (as they are inside lists and maps).
+func ToString(value Value, quoteStr bool) string {
+	return toString(value, quoteStr)
+}
@@ -0,0 +1,160 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+)
+
+// Session runs programs one after the other in the same global scope, so a
+// Go program can load a library script once and then call into it many
+// times. Values are converted to and from Go as described on Interpreter.
+// A Session can be used from several goroutines, but runs only one thing
+// at a time; use Clone for sessions that can run in parallel.
+//
+// The limits in the Config apply to the Session as a whole, except for
+// Timeout, which applies to each Execute or Call.
+//
+// As with the Execute function, goroutines spawned by an Execute or Call are
+// stopped when it returns.
+type Session struct {
+	interp *interpreter
+	config *Config
+}
+
+// NewSession returns a new Session with the Interpreter's configuration,
+// functions and values.
+func (i *Interpreter) NewSession() *Session {
+	return &Session{newInterpreter(&i.config), &i.config}
+}
+
+// Execute runs prog in the Session's global scope. The error is nil on
+// success or an interpreter.Error if there's an error.
+func (s *Session) Execute(prog *parser.Program) error {
+	return s.interp.runProgram(prog, s.config.compiles())
+}
+
+// Call calls the global function called name with args, returning its
+// result. The error is nil on success or an interpreter.Error if there's
+// an error, including if there's no such function.
+func (s *Session) Call(name string, args ...interface{}) (result interface{}, err error) {
+	pos := Position{}
+	err = s.interp.runTopLevel(func() {
+		value, ok := s.interp.globals[name]
+		if !ok {
+			panic(nameError(pos, "name %q not found", name))
+		}
+		f, ok := value.(functionType)
+		if !ok {
+			panic(typeError(pos, "can't call non-function type %s", typeName(value)))
+		}
+		values := make([]Value, len(args))
+		for i, arg := range args {
+			v, err := fromGo(reflect.ValueOf(arg))
+			if err != nil {
+				panic(typeError(pos, "%s() argument %d: %v", name, i+1, err))
+			}
+			values[i] = v
+		}
+		v := s.interp.callFunction(pos, f, values)
+		result = toGoInterface(nil, pos, s.interp.await(pos, v))
+	})
+	return result, err
+}
+
+// Get returns the value of the global variable called name, and false if
+// there's no such variable.
+func (s *Session) Get(name string) (interface{}, bool) {
+	s.interp.shared.lock.Lock()
+	defer s.interp.shared.lock.Unlock()
+	v, ok := s.interp.globals[name]
+	return toGoInterface(nil, Position{}, v), ok
+}
+
+// Set sets the global variable called name to the Go value v.
+func (s *Session) Set(name string, v interface{}) error {
+	value, err := fromGo(reflect.ValueOf(v))
+	if err != nil {
+		return fmt.Errorf("can't set %s: %v", name, err)
+	}
+	s.interp.shared.lock.Lock()
+	defer s.interp.shared.lock.Unlock()
+	s.interp.globals[name] = value
+	return nil
+}
+
+// Stats returns the interpreter statistics of everything the Session has
+// run so far.
+func (s *Session) Stats() Stats {
+	s.interp.shared.lock.Lock()
+	defer s.interp.shared.lock.Unlock()
+	return *s.interp.stats
+}
+
+// Clone returns a new Session with a copy of the Session's globals, for
+// running a request in isolation from the others. Lists, maps and objects
+// are copied, so changes to them in one Session aren't seen by the other.
+// Channels, wait groups, mutexes and promises are shared, as are the local
+// variables captured by closures. The clone has its own statistics and
+// limits.
+//
+// Cloning copies only the globals, so it's much cheaper than running the
+// programs that set them up again. A Session that's never run can be kept
+// as a snapshot to clone sessions from.
+func (s *Session) Clone() *Session {
+	s.interp.shared.lock.Lock()
+	defer s.interp.shared.lock.Unlock()
+	interp := newInterpreter(s.config)
+	copies := make(map[uintptr]Value)
+	for name, v := range s.interp.globals {
+		interp.globals[name] = copyValue(v, copies)
+	}
+	return &Session{interp, s.config}
+}
+
+// copyValue returns a deep copy of the lists, maps and objects in v,
+// sharing other values. copies holds the values already copied by their
+// address, so references between values are kept.
+func copyValue(v Value, copies map[uintptr]Value) Value {
+	switch v := v.(type) {
+	case *[]Value:
+		key := reflect.ValueOf(v).Pointer()
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		list := make([]Value, len(*v))
+		copies[key] = &list
+		for i, item := range *v {
+			list[i] = copyValue(item, copies)
+		}
+		return &list
+	case map[string]Value:
+		if v == nil {
+			return v
+		}
+		key := reflect.ValueOf(v).Pointer()
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		m := make(map[string]Value, len(v))
+		copies[key] = m
+		for k, item := range v {
+			m[k] = copyValue(item, copies)
+		}
+		return m
+	case *ClassObject:
+		key := reflect.ValueOf(v).Pointer()
+		if c, ok := copies[key]; ok {
+			return c
+		}
+		object := &ClassObject{Name: v.Name, Parent: v.Parent, Methods: v.Methods}
+		copies[key] = object
+		object.Fields = copyValue(v.Fields, copies).(map[string]Value)
+		return object
+	default:
+		return v
+	}
+}
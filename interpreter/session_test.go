@@ -0,0 +1,159 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const librarySource = `
+$state = {"counter": 0};
+$items = [];
+$alias = $items;
+function increment($by) {
+    $state["counter"] = $state["counter"] + $by;
+    return $state["counter"];
+}
+function remember($item) {
+    append($items, $item);
+    return len($alias);
+}
+async function later($x) {
+    await sleep(1);
+    return $x * 2;
+}
+function fail() {
+    return 1 + "a";
+}
+`
+
+func newTestSession(t *testing.T, out *bytes.Buffer) *Session {
+	i := New(&Config{Stdout: out})
+	if err := i.RegisterFunc("double", func(n int) int { return n * 2 }); err != nil {
+		t.Fatal(err)
+	}
+	s := i.NewSession()
+	prog, err := parser.ParseProgram([]byte(librarySource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Execute(prog); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func call(t *testing.T, s *Session, name string, args ...interface{}) interface{} {
+	result, err := s.Call(name, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return result
+}
+
+func TestSession(t *testing.T) {
+	var out bytes.Buffer
+	s := newTestSession(t, &out)
+	for i := 1; i <= 3; i++ {
+		if n := call(t, s, "increment", 2); n != 2*i {
+			t.Errorf("expected increment to return %d, got %v", 2*i, n)
+		}
+	}
+	if state, ok := s.Get("state"); !ok || !reflect.DeepEqual(state, map[string]interface{}{"counter": 6}) {
+		t.Errorf("expected counter 6, got %v, %v", state, ok)
+	}
+	if err := s.Set("state", map[string]int{"counter": 100}); err != nil {
+		t.Fatal(err)
+	}
+	if n := call(t, s, "increment", 1); n != 101 {
+		t.Errorf("expected 101 after Set, got %v", n)
+	}
+	if n := call(t, s, "later", 21); n != 42 {
+		t.Errorf("expected async function result 42, got %v", n)
+	}
+	if n := call(t, s, "double", 4); n != 8 {
+		t.Errorf("expected registered function result 8, got %v", n)
+	}
+
+	prog, err := parser.ParseProgram([]byte(`echo(increment(0))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Execute(prog); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "101\n" {
+		t.Errorf("expected output 101, got %q", out.String())
+	}
+	if stats := s.Stats(); stats.UserCalls == 0 || stats.BuiltinCalls == 0 {
+		t.Errorf("expected calls in stats, got %+v", stats)
+	}
+
+	errors := []struct {
+		name string
+		args []interface{}
+		err  string
+	}{
+		{"missing", nil, `name "missing" not found`},
+		{"state", nil, "can't call non-function type map"},
+		{"increment", []interface{}{"x"}, "+ requires two ints"},
+		{"increment", []interface{}{make(chan int)}, "increment() argument 1: can't convert Go chan int"},
+		{"fail", nil, "+ requires two ints"},
+	}
+	for _, test := range errors {
+		_, err := s.Call(test.name, test.args...)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+	// The session still works after an error
+	if n := call(t, s, "increment", 0); n != 101 {
+		t.Errorf("expected 101 after errors, got %v", n)
+	}
+}
+
+func TestSessionClone(t *testing.T) {
+	snapshot := newTestSession(t, &bytes.Buffer{})
+	call(t, snapshot, "remember", "base")
+
+	var wg sync.WaitGroup
+	results := make([][]interface{}, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := snapshot.Clone()
+			for j := 0; j <= i; j++ {
+				s.Call("remember", j)
+			}
+			s.Call("increment", i)
+			items, _ := s.Get("items")
+			state, _ := s.Get("state")
+			results[i] = []interface{}{items, state.(map[string]interface{})["counter"]}
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		items := result[0].([]interface{})
+		if len(items) != i+2 || items[0] != "base" {
+			t.Errorf("clone %d: expected base and %d items, got %v", i, i+1, items)
+		}
+		if result[1] != i {
+			t.Errorf("clone %d: expected counter %d, got %v", i, i, result[1])
+		}
+	}
+	items, _ := snapshot.Get("items")
+	if !reflect.DeepEqual(items, []interface{}{"base"}) {
+		t.Errorf("expected snapshot to be unchanged, got %v", items)
+	}
+	// $items and $alias are still the same list in a clone
+	s := snapshot.Clone()
+	if n := call(t, s, "remember", "x"); n != 2 {
+		t.Errorf("expected aliased list to have 2 items, got %v", n)
+	}
+}
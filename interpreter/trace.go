@@ -0,0 +1,103 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	. "github.com/DavinciScript/Davi/lexer"
+)
+
+// TraceKind is the kind of a TraceEvent.
+type TraceKind int
+
+const (
+	TraceStatement TraceKind = iota // a statement is about to be executed
+	TraceCall                       // a user function is being called
+	TraceReturn                     // a user function returned or raised an error
+	TraceBuiltin                    // a builtin function is being called
+)
+
+func (k TraceKind) String() string {
+	switch k {
+	case TraceStatement:
+		return "statement"
+	case TraceCall:
+		return "call"
+	case TraceReturn:
+		return "return"
+	case TraceBuiltin:
+		return "builtin"
+	default:
+		return "unknown"
+	}
+}
+
+// TraceEvent is something a program did, passed to Config.Tracer. It's
+// only valid until the Tracer call returns.
+type TraceEvent struct {
+	Kind TraceKind
+
+	// Position is the position of the statement, or of the call for
+	// calls, returns and builtin calls.
+	Position Position
+
+	// Function is the function the event happened in: "main" at the top
+	// level and "<anonymous>" for function expressions, as in
+	// DebugState.Stack.
+	Function string
+
+	// Callee is the function called, for calls, returns and builtin calls.
+	Callee string
+
+	// Depth is the number of user function calls on the call stack, 0 at
+	// the top level of the program.
+	Depth int
+
+	// Args are the arguments of a call or builtin call.
+	Args []Value
+
+	// Result is the value a user function returned, and Error the error
+	// it raised instead, if any.
+	Result Value
+	Error  error
+}
+
+// trace fills in the function and depth of event and passes it to the
+// tracer. Nothing is traced once the run has finished, while the calls of a
+// stopped goroutine unwind.
+func (interp *interpreter) trace(event *TraceEvent) {
+	if interp.loop.stopped() {
+		return
+	}
+	event.Function = "main"
+	if n := len(interp.frames); n > 0 {
+		event.Function = traceName(interp.frames[n-1].function)
+	}
+	event.Depth = len(interp.frames)
+	interp.tracer(event)
+}
+
+// traceReturn traces the return from a call of the named function from
+// pos, with the result it's returning. It must be deferred, so it can see
+// whether the call is returning or raising an error.
+func (interp *interpreter) traceReturn(name string, pos Position, result *Value) {
+	event := &TraceEvent{Kind: TraceReturn, Position: pos, Callee: traceName(name)}
+	r := recover()
+	if r != nil {
+		if e, ok := r.(Error); ok {
+			event.Error = e
+		}
+	} else {
+		event.Result = *result
+	}
+	interp.trace(event)
+	if r != nil {
+		panic(r)
+	}
+}
+
+func traceName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
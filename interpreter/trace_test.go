@@ -0,0 +1,74 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	source := `
+function inc($n) {
+    return $n + 1;
+}
+function fail() {
+    return 1 / 0;
+}
+$f = function($s) { return len($s); };
+echo(inc($f("ab")));
+fail();
+`
+	prog, err := parser.ParseFile("t.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []string{}
+	tracer := func(e *TraceEvent) {
+		s := fmt.Sprintf("%s %d %s %d", e.Kind, e.Position.Line, e.Function, e.Depth)
+		if e.Callee != "" {
+			s += " " + e.Callee
+		}
+		for _, arg := range e.Args {
+			s += " " + ToString(arg, true)
+		}
+		if e.Kind == TraceReturn {
+			if e.Error != nil {
+				s += " error: " + e.Error.Error()
+			} else {
+				s += " = " + ToString(e.Result, true)
+			}
+		}
+		events = append(events, s)
+	}
+	var out bytes.Buffer
+	_, err = Execute(prog, &Config{Stdout: &out, Tracer: tracer})
+	if err == nil || out.String() != "3\n" {
+		t.Fatalf("expected output 3 and an error, got %q and %v", out.String(), err)
+	}
+
+	expected := []string{
+		"statement 2 main 0",
+		"statement 5 main 0",
+		"statement 8 main 0",
+		"statement 9 main 0",
+		`call 9 main 0 <anonymous> "ab"`,
+		"statement 8 <anonymous> 1",
+		`builtin 8 <anonymous> 1 len "ab"`,
+		"return 9 main 0 <anonymous> = 2",
+		"call 9 main 0 inc 2",
+		"statement 3 inc 1",
+		"return 9 main 0 inc = 3",
+		"builtin 9 main 0 echo 3",
+		"statement 10 main 0",
+		"call 10 main 0 fail",
+		"statement 6 fail 1",
+		"return 10 main 0 fail error: value error at t.davi:6:14: can't divide by zero",
+	}
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected events\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(events, "\n"))
+	}
+}
@@ -0,0 +1,369 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"reflect"
+)
+
+// binaryEvalTable and unaryEvalTable index the operator functions by token
+// so the virtual machine doesn't need a map lookup per operation.
+var (
+	binaryEvalTable []binaryEvalFunc
+	unaryEvalTable  []unaryEvalFunc
+)
+
+func init() {
+	size := 0
+	for tok := range binaryEvalFuncs {
+		size = max(size, int(tok)+1)
+	}
+	for tok := range unaryEvalFuncs {
+		size = max(size, int(tok)+1)
+	}
+	binaryEvalTable = make([]binaryEvalFunc, size)
+	unaryEvalTable = make([]unaryEvalFunc, size)
+	for tok, f := range binaryEvalFuncs {
+		binaryEvalTable[tok] = f
+	}
+	for tok, f := range unaryEvalFuncs {
+		unaryEvalTable[tok] = f
+	}
+}
+
+// logicalNames are the names of the operators of opAnd and opOr.
+var logicalNames = []string{"and", "or"}
+
+// spreadArgs holds the items of the last argument of f(...$x).
+type spreadArgs []Value
+
+// compiledFunction is a function or method compiled to bytecode, with the
+// environment it was defined in (nil at the top level).
+type compiledFunction struct {
+	code *code
+	env  *environment
+}
+
+func (f *compiledFunction) call(interp *interpreter, pos Position, args []Value) Value {
+	if f.code.async {
+		return Value(interp.callAsync(pos, f, args))
+	}
+	return f.callBody(interp, pos, args)
+}
+
+func (f *compiledFunction) callBody(interp *interpreter, pos Position, args []Value) Value {
+	c := f.code
+	args = packEllipsis(c.parameters, c.ellipsis, args)
+	ensureNumArgs(pos, c.name, args, len(c.parameters))
+	env := newEnvironment(c.locals, args, f.env)
+	interp.enter(pos)
+	interp.frames = append(interp.frames, frame{c.name, pos, env})
+	defer func() {
+		interp.frames = interp.frames[:len(interp.frames)-1]
+		interp.leave()
+	}()
+	interp.stats.UserCalls++
+	return interp.run(c, env)
+}
+
+func (f *compiledFunction) name() string {
+	return (&userFunction{Name: f.code.name, Async: f.code.async}).name()
+}
+
+// run executes compiled code in env (nil for the top level) and returns the
+// value of its return statement, or nil if it doesn't return.
+func (interp *interpreter) run(c *code, env *environment) Value {
+	var slots []Value
+	if env != nil {
+		slots = env.slots
+	}
+	globals := interp.globals
+	stack := make([]Value, 0, 8)
+	instructions := c.instructions
+	pc := 0
+	defer func() {
+		if r := recover(); r != nil {
+			panic(withSpan(r, c.positions[pc], c.spans[pc]))
+		}
+	}()
+
+	for ; pc < len(instructions); pc++ {
+		interp.stats.Ops++
+		if interp.stats.Ops >= interp.limits.next {
+			interp.limits.check(c.positions[pc], interp.stats.Ops)
+		}
+		interp.yield()
+		in := instructions[pc]
+		switch in.op {
+		case opConst:
+			stack = append(stack, c.constants[in.arg])
+		case opPop:
+			stack = stack[:len(stack)-1]
+		case opLoadLocal:
+			v := slots[in.arg]
+			if v == undefined {
+				name := c.locals[in.arg]
+				var ok bool
+				if v, ok = interp.lookupFrom(env.parent, name); !ok {
+					panic(nameError(c.positions[pc], "name %q not found", name))
+				}
+			}
+			stack = append(stack, v)
+		case opStoreLocal:
+			slots[in.arg] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+		case opLoadOuter:
+			o := c.outers[in.arg]
+			e := env
+			for d := 0; d < o.depth; d++ {
+				e = e.parent
+			}
+			v := e.slots[o.slot]
+			if v == undefined {
+				var ok bool
+				if v, ok = interp.lookupFrom(e.parent, o.name); !ok {
+					panic(nameError(c.positions[pc], "name %q not found", o.name))
+				}
+			}
+			stack = append(stack, v)
+		case opLoadGlobal:
+			v, ok := globals[c.names[in.arg]]
+			if !ok {
+				if v, ok = interp.lookupCallers(c.names[in.arg]); !ok {
+					panic(nameError(c.positions[pc], "name %q not found", c.names[in.arg]))
+				}
+			}
+			stack = append(stack, v)
+		case opStoreGlobal:
+			globals[c.names[in.arg]] = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+		case opBinary:
+			n := len(stack)
+			stack[n-2] = binaryEvalTable[in.arg](c.positions[pc], stack[n-2], stack[n-1])
+			stack = stack[:n-1]
+		case opUnary:
+			n := len(stack)
+			stack[n-1] = unaryEvalTable[in.arg](c.positions[pc], stack[n-1])
+		case opAnd, opOr:
+			l, ok := stack[len(stack)-1].(bool)
+			if !ok {
+				panic(typeError(c.positions[pc], "%s requires two bools", logicalNames[in.op-opAnd]))
+			}
+			if l == (in.op == opOr) {
+				// Short circuit: leave the left value as the result
+				pc = in.arg - 1
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+		case opCheckBool:
+			if _, ok := stack[len(stack)-1].(bool); !ok {
+				panic(typeError(c.positions[pc], "%s requires two bools", logicalNames[in.arg]))
+			}
+		case opJump:
+			pc = in.arg - 1
+		case opIfFalse:
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			b, ok := cond.(bool)
+			if !ok {
+				panic(typeError(c.positions[pc], "if condition must be bool, got %s", typeName(cond)))
+			}
+			if !b {
+				pc = in.arg - 1
+			}
+		case opWhileFalse:
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			b, ok := cond.(bool)
+			if !ok {
+				panic(typeError(c.positions[pc], "while condition must be bool, got %T", cond))
+			}
+			if !b {
+				pc = in.arg - 1
+			}
+		case opIter:
+			stack[len(stack)-1] = getIterator(c.positions[pc], stack[len(stack)-1])
+		case opForNext:
+			iterator := stack[len(stack)-1].(iteratorType)
+			if iterator.HasNext() {
+				stack = append(stack, iterator.Value())
+			} else {
+				stack = stack[:len(stack)-1]
+				pc = in.arg - 1
+			}
+
+		case opList:
+			values := make([]Value, in.arg)
+			copy(values, stack[len(stack)-in.arg:])
+			stack = stack[:len(stack)-in.arg]
+			stack = append(stack, Value(&values))
+		case opMapKey:
+			key := stack[len(stack)-1]
+			if _, ok := key.(string); !ok {
+				panic(typeError(c.positions[pc], "map key must be str, not %s", typeName(key)))
+			}
+		case opMap:
+			value := make(map[string]Value, in.arg)
+			items := stack[len(stack)-2*in.arg:]
+			for i := 0; i < len(items); i += 2 {
+				value[items[i].(string)] = items[i+1]
+			}
+			stack = stack[:len(stack)-2*in.arg]
+			stack = append(stack, Value(value))
+		case opSubscript:
+			n := len(stack)
+			stack[n-2] = evalSubscript(c.positions[pc], stack[n-2], stack[n-1])
+			stack = stack[:n-1]
+		case opStoreSubscript:
+			n := len(stack)
+			interp.assignSubscript(c.positions[pc], stack[n-3], stack[n-2], stack[n-1])
+			stack = stack[:n-3]
+
+		case opCallable, opSpawnable:
+			function := stack[len(stack)-1]
+			if _, ok := function.(functionType); !ok {
+				verb := "call"
+				if in.op == opSpawnable {
+					verb = "spawn"
+				}
+				panic(typeError(c.positions[pc], "can't %s non-function type %s", verb, typeName(function)))
+			}
+		case opSpread:
+			iterator := getIterator(c.positions[pc], stack[len(stack)-1])
+			values := spreadArgs{}
+			for iterator.HasNext() {
+				values = append(values, iterator.Value())
+			}
+			stack[len(stack)-1] = values
+		case opCall, opCallSpread, opSpawn, opSpawnSpread:
+			n := in.arg
+			args := make([]Value, n, n+1)
+			copy(args, stack[len(stack)-n:])
+			if in.op == opCallSpread || in.op == opSpawnSpread {
+				args = append(args[:n-1], args[n-1].(spreadArgs)...)
+			}
+			f := stack[len(stack)-n-1].(functionType)
+			stack = stack[:len(stack)-n-1]
+			if in.op == opSpawn || in.op == opSpawnSpread {
+				interp.goCall(c.positions[pc], f, args)
+			} else {
+				stack = append(stack, f.call(interp, c.positions[pc], args))
+			}
+		case opMethod:
+			object := stack[len(stack)-1]
+			instance, ok := object.(*ClassObject)
+			if !ok {
+				panic(typeError(c.positions[pc], "cannot call method on non-instance type %s", typeName(object)))
+			}
+			method, ok := instance.Methods[c.names[in.arg]]
+			if !ok {
+				panic(nameError(c.positions[pc], "method %q not found in class %s", c.names[in.arg], instance.Name))
+			}
+			stack[len(stack)-1] = method
+		case opFunction:
+			stack = append(stack, &compiledFunction{c.functions[in.arg], env})
+		case opClass:
+			cc := c.classes[in.arg]
+			fields := make(map[string]Value)
+			values := stack[len(stack)-len(cc.fields):]
+			for i, name := range cc.fields {
+				fields[name] = values[i]
+			}
+			stack = stack[:len(stack)-len(cc.fields)]
+			methods := make(map[string]functionType)
+			for _, m := range cc.methods {
+				methods[m.name] = &compiledFunction{m, env}
+			}
+			stack = append(stack, &ClassObject{Name: cc.name, Methods: methods, Fields: fields})
+		case opNew:
+			stack = append(stack, interp.newCompiledInstance(c.positions[pc], env, c.names[in.arg]))
+		case opAwait:
+			stack[len(stack)-1] = interp.await(c.positions[pc], stack[len(stack)-1])
+		case opChannel:
+			if _, ok := stack[len(stack)-1].(*Channel); !ok {
+				panic(typeError(c.positions[pc], "select case requires a channel, got %s", typeName(stack[len(stack)-1])))
+			}
+		case opSelect:
+			var target int
+			stack, target = interp.runSelect(c.positions[pc], c.selects[in.arg], stack)
+			pc = target - 1
+		case opReturn:
+			if c.topLevel {
+				panic(runtimeError(c.positions[pc], "can't return at top level"))
+			}
+			return stack[len(stack)-1]
+		default:
+			// Compiler should never give us this
+			panic(fmt.Sprintf("unknown opcode %d", in.op))
+		}
+	}
+	return Value(nil)
+}
+
+// newCompiledInstance is newInstance for compiled code, looking the class
+// up in env.
+func (interp *interpreter) newCompiledInstance(pos Position, env *environment, className string) *ClassObject {
+	class, ok := interp.lookupFrom(env, className)
+	if !ok {
+		panic(nameError(pos, "class %s not found", className))
+	}
+	classObject, ok := class.(*ClassObject)
+	if !ok {
+		panic(typeError(pos, "can't instantiate non-class type %s", typeName(class)))
+	}
+	return classObject
+}
+
+// runSelect pops the channels (and values to send) of a select statement
+// from the stack, waits for a case to proceed and returns the stack and the
+// address to jump to. For a case, the received value (or nil) is pushed.
+func (interp *interpreter) runSelect(pos Position, s *selectCode, stack []Value) ([]Value, int) {
+	size := len(s.sends)
+	for _, send := range s.sends {
+		if send {
+			size++
+		}
+	}
+	values := stack[len(stack)-size:]
+	stack = stack[:len(stack)-size]
+	cases := make([]reflect.SelectCase, len(s.sends))
+	for i, send := range s.sends {
+		channel := values[0].(*Channel)
+		if send {
+			value := values[1]
+			cases[i] = reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(channel.ch),
+				Send: reflect.ValueOf(&value).Elem(),
+			}
+			values = values[2:]
+		} else {
+			cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.ch)}
+			values = values[1:]
+		}
+	}
+
+	var chosen int
+	var recv reflect.Value
+	var recvOK bool
+	if s.hasDefault {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+		chosen, recv, recvOK = selectCases(pos, cases)
+		if chosen == len(s.sends) {
+			return stack, s.fallback
+		}
+	} else {
+		if len(cases) == 0 {
+			panic(runtimeError(pos, "select with no cases blocks forever"))
+		}
+		chosen, recv, recvOK = interp.block(pos, cases)
+	}
+	var value Value
+	if recvOK {
+		value = recv.Interface()
+	}
+	return append(stack, value), s.targets[chosen]
+}
@@ -0,0 +1,319 @@
+// DaVinci Script
+
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exitCode is panicked by the test's exit() to stop the program.
+type exitCode int
+
+// result is what a program did when run by one of the engines.
+type result struct {
+	output string
+	err    string
+	exit   int
+}
+
+// fixedTime replaces the time() builtin so output is reproducible.
+func fixedTime(interp *interpreter, pos Position, args []Value) Value {
+	ensureNumArgs(pos, "time", args, 0)
+	return Value("2006-01-02 15:04:05 +0000 UTC")
+}
+
+func runEngine(prog *parser.Program, compiled bool) (r result) {
+	var out bytes.Buffer
+	config := &Config{
+		Vars:   map[string]Value{"time": builtinFunction{fixedTime, "time", 0, 0}},
+		Args:   []string{"one", "two"},
+		Stdin:  strings.NewReader("input\n"),
+		Stdout: &out,
+		Exit:   func(code int) { panic(exitCode(code)) },
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			code, ok := e.(exitCode)
+			if !ok {
+				panic(e)
+			}
+			r.exit = int(code)
+		}
+		r.output = out.String()
+	}()
+	_, err := execute(prog, config, compiled)
+	if err != nil {
+		r.err = err.Error()
+	}
+	return r
+}
+
+// checkSame runs source with the tree-walking evaluator and the virtual
+// machine and checks they do the same thing.
+func checkSame(t *testing.T, name string, source []byte) {
+	prog, err := parser.ParseProgram(parser.StripTags(source))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if _, err := compile(prog); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	walked := runEngine(prog, false)
+	compiled := runEngine(prog, true)
+	if walked.output != compiled.output {
+		t.Errorf("%s: output differs\ntree walker:\n%s\nvirtual machine:\n%s", name, walked.output, compiled.output)
+	}
+	if walked.err != compiled.err {
+		t.Errorf("%s: error differs\ntree walker:     %s\nvirtual machine: %s", name, walked.err, compiled.err)
+	}
+	if walked.exit != compiled.exit {
+		t.Errorf("%s: exit code differs: tree walker %d, virtual machine %d", name, walked.exit, compiled.exit)
+	}
+}
+
+func TestCompiledScripts(t *testing.T) {
+	files, err := filepath.Glob("../tests/*.davi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scripts found in tests/")
+	}
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSame(t, file, source)
+	}
+}
+
+func TestCompiledErrors(t *testing.T) {
+	tests := []string{
+		`$x = 1 + "a"`,
+		`echo($missing)`,
+		`function f() { return $missing; } f()`,
+		`function f($a) { return $a; } f(1, 2)`,
+		`$x = 1; $x()`,
+		`spawn 1()`,
+		`if (1) { echo(1) }`,
+		`while ("x") { echo(1) }`,
+		`$l = [1]; echo($l[5])`,
+		`$m = {"a": 1}; echo($m["b"])`,
+		`$m = {1: 2}`,
+		`$x = true and 1`,
+		`$x = 1 or true`,
+		`$x = false and 1`,
+		`for ($x in 5) { echo($x) }`,
+		`return 1`,
+		`if (true) { while (true) { return 1; } }`,
+		`for ($x in [1, 2]) { echo($x); return $x; }`,
+		`function f() { for ($x in [1, 2, 3]) { if ($x == 2) { return $x; } echo($x); } } echo(f())`,
+		`function f($n) { while (true) { if ($n > 3) { return $n; } $n = $n + 1; } } echo(f(0))`,
+		`function f() { $c = channel(1); send($c, 7); select { case $v = recv($c) { return $v; } } } echo(f())`,
+		`echo(1); exit(3); echo(2)`,
+		`$o = 1; $o->method()`,
+		`class C { function m() { return 1; } } $c = new C(); $c->other()`,
+		`$c = new Missing()`,
+		`select { }`,
+		`select { case $v = recv(1) { } }`,
+		`function f($a...) { return $a; } echo(f(1, 2, 3), f())`,
+		`echo([1, 2]...)`,
+		`echo(5...)`,
+		`$f = function() { return $later; }; $later = "late"; echo($f())`,
+		`async function f() { return 1 + "a"; } echo(await f())`,
+	}
+	for i, test := range tests {
+		checkSame(t, fmt.Sprintf("test %d: %s", i, test), []byte(test))
+	}
+}
+
+func TestScoping(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		// A function can use the local variables of the functions that
+		// called it
+		{`function g() { return $x; } function f() { $x = 1; return g(); } echo(f())`, "1\n", ""},
+		{`function h() { return $x; } function g() { return h(); } function f() { $x = 2; return g(); } echo(f())`, "2\n", ""},
+		{`function g() { return $x; } function f($x) { return g(); } echo(f(3))`, "3\n", ""},
+		{`function g() { return $x; } function f() { $x = 1; $h = function() { return g(); }; return $h(); } echo(f())`, "1\n", ""},
+		{`class C { function m() { echo($x); } } function f() { $x = 4; $c = new C(); $c->m(); } f()`, "4\n", ""},
+		// but globals and enclosing functions come first
+		{`$x = "global"; function g() { return $x; } function f() { $x = "local"; return g(); } echo(f())`, "global\n", ""},
+		{`function f() { $x = "f"; return function() { return $x; }; } function k() { $x = "k"; $c = f(); return $c(); } echo(k())`, "f\n", ""},
+		// and assigning to a name makes a new local
+		{`function g() { $x = 2; } function f() { $x = 1; g(); return $x; } echo(f())`, "1\n", ""},
+		{`function g() { $y = $x + 1; return $y; } function f() { $x = 1; return g(); } echo(f())`, "2\n", ""},
+		// Locals of calls that have returned, and of the caller of an
+		// async function, aren't visible
+		{`function f() { $x = 1; } function g() { return $x; } f(); g()`, "", `name error at 1:49: name "x" not found`},
+		{`async function g() { return $x; } function f() { $x = 1; return await g(); } f()`, "", `name error at 1:30: name "x" not found`},
+		{`function g() { return $x; } g()`, "", `name error at 1:24: name "x" not found`},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			r := runEngine(prog, compiled)
+			if r.output != test.output || r.err != test.err {
+				t.Errorf("%s (compiled %v): expected %q and error %q, got %q and error %q",
+					test.source, compiled, test.output, test.err, r.output, r.err)
+			}
+		}
+	}
+}
+
+func TestClasses(t *testing.T) {
+	tests := []struct {
+		source string
+		output string
+		err    string
+	}{
+		{`class C { function m($a) { echo("m", $a); } } $c = new C(); $c->m(1);`, "m 1\n", ""},
+		{`class C { } $c = new C(); echo($c, str($c), [$c]);`, "<object C> <object C> [<object C>]\n", ""},
+		// Errors are raised at the call or new expression
+		{`class C { } $c = new C(); $c->nope();`, "", `name error at 1:35: method "nope" not found in class C`},
+		{`class C { function m($a) { } } $c = new C(); $c->m(1, 2);`, "", `type error at 1:51: ensure num args: m() requires 1 arg, got 2`},
+		{`class C { function m() { return 1 / 0; } } $c = new C(); $c->m();`, "", `value error at 1:35: can't divide by zero`},
+		{`$c = new Nope();`, "", `name error at 1:6: class Nope not found`},
+		{`$n = 1; $c = new n();`, "", `type error at 1:14: can't instantiate non-class type int`},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			r := runEngine(prog, compiled)
+			if r.output != test.output || r.err != test.err {
+				t.Errorf("%s (compiled %v): expected %q and error %q, got %q and error %q",
+					test.source, compiled, test.output, test.err, r.output, r.err)
+			}
+		}
+	}
+}
+
+func TestErrorSpans(t *testing.T) {
+	tests := []struct {
+		source string
+		span   string
+	}{
+		{`$x = [1, 2] + 3`, `[1, 2] + 3`},
+		{`len([1, 2], 3)`, `len([1, 2], 3)`},
+		{`echo(1 + $nope * 2)`, `$nope`},
+		{`$f = 1; $f(2)`, `$f(2)`},
+		{`$x = -"a"`, `-"a"`},
+		{`$l = [1]; echo($l["a"])`, `$l["a"]`},
+		{`function f($a) { return $a / 0; } echo(f(1) + 1)`, `$a / 0`},
+		{`assert(1 > 2)`, `assert(1 > 2)`},
+		{`$nope(2)`, `$nope`},
+		{`assertThrows(function() { })`, `assertThrows(function() { })`},
+		{`async function f() { return 1 + "a"; } echo(await f())`, `1 + "a"`},
+		{`class C { } $c = new C(); $c->nope()`, `$c->nope()`},
+		{`if (1) { }`, ``},
+		{`if (1 + 1) { }`, ``},
+		{`$l = [1]; echo($l[2 - 1])`, `$l[2 - 1]`},
+		{`$l = [1]; echo($l[1 - "a"])`, `1 - "a"`},
+		{`echo(true and 1)`, `true and 1`},
+		{`$l = [1]; $l[2 - 1] = 0`, ``},
+		{`for ($x in 1 + 1) { }`, ``},
+		{`echo({1 + 1: 2})`, ``},
+		{`sort([2, 1], function($x) { return {"k": $x + 1}; })`, `sort([2, 1], function($x) { return {"k": $x + 1}; })`},
+	}
+	for _, test := range tests {
+		prog, err := parser.ParseProgram([]byte(test.source))
+		if err != nil {
+			t.Fatalf("%s: %v", test.source, err)
+		}
+		for _, compiled := range []bool{false, true} {
+			_, err := execute(prog, &Config{Stdout: &bytes.Buffer{}}, compiled)
+			e, ok := err.(Error)
+			if !ok {
+				t.Errorf("%s (compiled %v): expected an error, got %v", test.source, compiled, err)
+				continue
+			}
+			span := test.source[e.Start().Offset:e.End().Offset]
+			if span != test.span {
+				t.Errorf("%s (compiled %v): expected span %q, got %q", test.source, compiled, test.span, span)
+			}
+		}
+	}
+}
+
+// TestCompilesEveryNode checks that the scripts in tests/, which
+// TestCompiledScripts checks compile, use every kind of statement and
+// expression the parser produces.
+func TestCompilesEveryNode(t *testing.T) {
+	// OuterAssign and PropertyAccess aren't produced by the parser
+	expected := []string{
+		"Assign", "If", "While", "For", "Return", "ExpressionStatement",
+		"FunctionDefinition", "Spawn", "Select", "ClassDefinition",
+		"Binary", "Unary", "Call", "Literal", "List", "Map", "NewExpression",
+		"MethodCall", "FunctionExpression", "Await", "Subscript", "Variable",
+		"SemiTag",
+	}
+	files, err := filepath.Glob("../tests/*.davi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog, err := parser.ParseProgram(parser.StripTags(source))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		nodeTypes(reflect.ValueOf(prog.Statements), seen)
+	}
+	for _, name := range expected {
+		if !seen[name] {
+			t.Errorf("no %s in the scripts in tests/", name)
+		}
+	}
+}
+
+// nodeTypes adds the names of the types of the AST nodes in v to seen.
+func nodeTypes(v reflect.Value, seen map[string]bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			nodeTypes(v.Elem(), seen)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if _, ok := v.Interface().(parser.Statement); ok {
+			seen[v.Elem().Type().Name()] = true
+		} else if _, ok := v.Interface().(parser.Expression); ok {
+			seen[v.Elem().Type().Name()] = true
+		}
+		nodeTypes(v.Elem(), seen)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			nodeTypes(v.Index(i), seen)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				nodeTypes(v.Field(i), seen)
+			}
+		}
+	}
+}
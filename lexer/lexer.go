@@ -4,6 +4,8 @@ package lexer
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -71,11 +73,20 @@ const (
 	FINAL
 	CONST
 	NEW
+	SPAWN
+	SELECT
+	CASE
+	DEFAULT
+	ASYNC
+	AWAIT
 
 	// Literals and identifiers
 	INT
 	NAME
 	STR
+
+	// Comments, only returned by lexers created with NewLexerWithComments
+	COMMENT
 )
 
 var keywordTokens = map[string]Token{
@@ -102,6 +113,12 @@ var keywordTokens = map[string]Token{
 	"final":     FINAL,
 	"const":     CONST,
 	"new":       NEW,
+	"spawn":     SPAWN,
+	"select":    SELECT,
+	"case":      CASE,
+	"default":   DEFAULT,
+	"async":     ASYNC,
+	"await":     AWAIT,
 }
 
 var tokenNames = map[Token]string{
@@ -163,19 +180,53 @@ var tokenNames = map[Token]string{
 	CONST:     "const",
 	NEW:       "new",
 
+	// Concurrency
+	SPAWN:   "spawn",
+	SELECT:  "select",
+	CASE:    "case",
+	DEFAULT: "default",
+	ASYNC:   "async",
+	AWAIT:   "await",
+
 	INT:  "int",
 	NAME: "name",
 	STR:  "str",
+
+	COMMENT: "comment",
 }
 
 func (t Token) String() string {
 	return tokenNames[t]
 }
 
-// Position stores the line and column a token starts at
+// Keywords returns the language's keywords in sorted order.
+func Keywords() []string {
+	keywords := make([]string, 0, len(keywordTokens))
+	for keyword := range keywordTokens {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	return keywords
+}
+
+// Position stores the line and column a token starts at, its byte offset
+// from the start of the input, and the name of the file the input came
+// from. Lines and columns count from 1 and columns count characters, not
+// bytes; offsets count from 0. File is empty if the input isn't a file.
 type Position struct {
 	Line   int
 	Column int
+	Offset int
+	File   string
+}
+
+// String returns the position as "file:line:column", or "line:column" if
+// it has no file, as used in error messages.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 // Lexer parses input source code to a stream of tokens. Use
@@ -188,6 +239,8 @@ type Lexer struct {
 	errorMsg string
 	pos      Position
 	nextPos  Position
+	end      Position
+	comments bool
 }
 
 // NewLexer returns a new tokenizer that works off the given input.
@@ -200,6 +253,24 @@ func NewLexer(input []byte) *Lexer {
 	return l
 }
 
+// NewLexerWithComments returns a new tokenizer like NewLexer, except that
+// it returns a COMMENT token for each comment instead of skipping it. The
+// token value is the comment text including the leading //, with trailing
+// whitespace removed.
+func NewLexerWithComments(input []byte) *Lexer {
+	l := NewLexer(input)
+	l.comments = true
+	return l
+}
+
+// SetFile sets the name of the file the input came from, which is
+// recorded in the positions of the tokens returned by Next.
+func (l *Lexer) SetFile(file string) {
+	l.pos.File = file
+	l.nextPos.File = file
+	l.end.File = file
+}
+
 func (l *Lexer) next() {
 	l.pos = l.nextPos
 	ch, size := utf8.DecodeRune(l.input[l.offset:])
@@ -220,6 +291,7 @@ func (l *Lexer) next() {
 	}
 	l.ch = ch
 	l.offset += size
+	l.nextPos.Offset = l.offset
 }
 
 func (l *Lexer) skipWhitespaceAndComments() {
@@ -227,7 +299,7 @@ func (l *Lexer) skipWhitespaceAndComments() {
 		for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' || l.ch == '\n' {
 			l.next()
 		}
-		if !(l.ch == '/' && l.offset < len(l.input) && l.input[l.offset] == '/') {
+		if l.comments || !l.atComment() {
 			break
 		}
 		// Skip //-prefixed comment (to end of line or end of input)
@@ -240,27 +312,57 @@ func (l *Lexer) skipWhitespaceAndComments() {
 	}
 }
 
+func (l *Lexer) atComment() bool {
+	return l.ch == '/' && l.offset < len(l.input) && l.input[l.offset] == '/'
+}
+
+// comment reads a //-prefixed comment up to the end of the line
+func (l *Lexer) comment() string {
+	runes := []rune{}
+	for l.ch != '\n' && l.ch >= 0 {
+		runes = append(runes, l.ch)
+		l.next()
+	}
+	return strings.TrimRight(string(runes), " \t\r")
+}
+
 func isNameStart(ch rune) bool {
 	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
 }
 
 // Next() returns the position, token type, and token value of the next token
 // in the source. For ordinary tokens, the token value is empty. For INT,
-// NAME, and STR tokens, it's the number or string value. For an ILLEGAL
-// token, it's the error message.
-func (l *Lexer) Next() (Position, Token, string, string) {
+// NAME, and STR tokens, it's the number or string value. For a COMMENT
+// token, it's the comment text. For an ILLEGAL token, it's the error message.
+func (l *Lexer) Next() (Position, Token, string) {
+	pos, token, value := l.token()
+	l.end = l.pos
+	return pos, token, value
+}
+
+// End returns the position just after the end of the token last returned
+// by Next. For an ILLEGAL token, it's where the lexer stopped.
+func (l *Lexer) End() Position {
+	return l.end
+}
+
+func (l *Lexer) token() (Position, Token, string) {
 	l.skipWhitespaceAndComments()
 	if l.ch < 0 {
 		if l.errorMsg != "" {
-			return l.pos, ILLEGAL, l.errorMsg, ""
+			return l.pos, ILLEGAL, l.errorMsg
 		}
-		return l.pos, EOF, "", ""
+		return l.pos, EOF, ""
 	}
 
 	pos := l.pos
 	token := ILLEGAL
 	value := ""
 
+	if l.comments && l.atComment() {
+		return pos, COMMENT, l.comment()
+	}
+
 	ch := l.ch
 	l.next()
 
@@ -277,7 +379,7 @@ func (l *Lexer) Next() (Position, Token, string, string) {
 			token = NAME
 			value = name
 		}
-		return pos, token, value, string(ch)
+		return pos, token, value
 	}
 
 	switch ch {
@@ -353,7 +455,7 @@ func (l *Lexer) Next() (Position, Token, string, string) {
 		if l.ch == '.' {
 			l.next()
 			if l.ch != '.' {
-				return pos, ILLEGAL, "unexpected ..", string(ch)
+				return pos, ILLEGAL, "unexpected .."
 			}
 			l.next()
 			token = ELLIPSIS
@@ -375,10 +477,10 @@ func (l *Lexer) Next() (Position, Token, string, string) {
 		for l.ch != '"' {
 			c := l.ch
 			if c < 0 {
-				return pos, ILLEGAL, "didn't find end quote in string", string(ch)
+				return pos, ILLEGAL, "didn't find end quote in string"
 			}
 			if c == '\r' || c == '\n' {
-				return pos, ILLEGAL, "can't have newline in string", string(ch)
+				return pos, ILLEGAL, "can't have newline in string"
 			}
 			if c == '\\' {
 				l.next()
@@ -392,7 +494,7 @@ func (l *Lexer) Next() (Position, Token, string, string) {
 				case 'n':
 					c = '\n'
 				default:
-					return pos, ILLEGAL, fmt.Sprintf("invalid string escape \\%c", l.ch), string(ch)
+					return pos, ILLEGAL, fmt.Sprintf("invalid string escape \\%c", l.ch)
 				}
 			}
 			runes = append(runes, c)
@@ -406,5 +508,5 @@ func (l *Lexer) Next() (Position, Token, string, string) {
 		token = ILLEGAL
 		value = fmt.Sprintf("unexpected %c", ch)
 	}
-	return pos, token, value, string(ch)
+	return pos, token, value
 }
@@ -0,0 +1,144 @@
+// DaVinci Script
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DavinciScript/Davi/lint"
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+const lintUsage = `Usage: davi lint [--json] [path...]
+
+Reports likely mistakes in Davi source without running it. Directories are
+searched for .davi files. With no paths, the source is read from standard
+input. Exits with status 1 if any problems are found.
+
+Options:
+  --json    print problems as a JSON array for tools
+
+Problems on a line can be suppressed with a comment at the end of the line
+or on the line above:
+
+  // davi-lint-ignore [rule-id, ...]
+
+Rules:
+`
+
+// lintProblem is the JSON form of a lint diagnostic.
+type lintProblem struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// runLint implements the "davi lint" command.
+func runLint(args []string) int {
+	jsonOutput := false
+	paths := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--json":
+			jsonOutput = true
+		case "-h", "--help":
+			fmt.Print(lintUsage + lintRules())
+			return exitOK
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "davi lint: unknown option %s\n\n%s", arg, lintUsage+lintRules())
+				return exitUsageError
+			}
+			paths = append(paths, arg)
+		}
+	}
+
+	type source struct {
+		name  string
+		input []byte
+	}
+	sources := []source{}
+	if len(paths) == 0 {
+		input, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading standard input: %s\n", err)
+			return exitUsageError
+		}
+		sources = append(sources, source{"<stdin>", input})
+	} else {
+		files, err := daviFiles(paths)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsageError
+		}
+		for _, file := range files {
+			input, err := ioutil.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading file %s. Please check the file path and try again.\n", file)
+				return exitUsageError
+			}
+			sources = append(sources, source{file, input})
+		}
+	}
+
+	status := exitOK
+	problems := []lintProblem{}
+	for _, s := range sources {
+		diagnostics, err := lint.Source(s.input)
+		if err != nil {
+			e, ok := err.(parser.Error)
+			if !ok {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", s.name, err)
+				return exitRuntimeError
+			}
+			// Report syntax errors like any other problem
+			diagnostics = []lint.Diagnostic{{Position: e.Position, Rule: "syntax-error", Message: e.Message}}
+			status = exitParseError
+		}
+		for _, d := range diagnostics {
+			problems = append(problems, lintProblem{s.name, d.Position.Line, d.Position.Column, d.Rule, d.Message})
+		}
+	}
+
+	writeLintProblems(os.Stdout, problems, jsonOutput)
+	if status == exitOK && len(problems) > 0 {
+		status = exitRuntimeError
+	}
+	return status
+}
+
+// writeLintProblems prints problems to w, one per line or as a JSON array
+// if jsonOutput is true.
+func writeLintProblems(w io.Writer, problems []lintProblem, jsonOutput bool) {
+	if jsonOutput {
+		out, _ := json.MarshalIndent(problems, "", "  ")
+		fmt.Fprintln(w, string(out))
+		return
+	}
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s:%d:%d: %s (%s)\n", p.File, p.Line, p.Column, p.Message, p.Rule)
+	}
+}
+
+func lintRules() string {
+	ids := []string{}
+	for id := range lint.Rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	lines := ""
+	for _, id := range ids {
+		lines += fmt.Sprintf("  %-20s %s\n", id, lint.Rules[id])
+	}
+	return lines
+}
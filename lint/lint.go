@@ -0,0 +1,449 @@
+// DaVinci Script
+
+// Package lint finds likely mistakes in Davi programs without running them.
+//
+// Each problem is reported as a Diagnostic tagged with the ID of the rule
+// that found it. A problem can be suppressed with a comment of the form
+//
+//	// davi-lint-ignore [rule-id, ...]
+//
+// either at the end of the offending line or on a line of its own just
+// above it. Without rule IDs, every rule is suppressed for that line.
+package lint
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"sort"
+	"strings"
+)
+
+// Rule IDs
+const (
+	UnusedVariable   = "unused-variable"
+	UndefinedName    = "undefined-name"
+	BuiltinArity     = "builtin-arity"
+	UnreachableCode  = "unreachable-code"
+	NonBoolCondition = "non-bool-condition"
+)
+
+// suppressionPrefix starts a comment that suppresses problems on a line.
+const suppressionPrefix = "// davi-lint-ignore"
+
+// Rules maps each rule ID to a short description of what it reports.
+var Rules = map[string]string{
+	UnusedVariable:   "a variable is assigned but its value is never used",
+	UndefinedName:    "a variable, function or class is used but never defined",
+	BuiltinArity:     "a builtin function is called with the wrong number of arguments",
+	UnreachableCode:  "a statement follows a return in the same block",
+	NonBoolCondition: "an if or while condition made of literals isn't a bool",
+}
+
+// Diagnostic is a single problem found by the linter.
+type Diagnostic struct {
+	Position Position
+	Rule     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s (%s)", d.Position, d.Message, d.Rule)
+}
+
+// Source parses and lints a Davi program, leaving out problems suppressed
+// by davi-lint-ignore comments. The <?davi and ?> tags around the program
+// are allowed. It returns a parser.Error if src has a syntax error.
+func Source(src []byte) ([]Diagnostic, error) {
+	code := parser.StripTags(src)
+	prog, err := parser.ParseProgram(code)
+	if err != nil {
+		return nil, err
+	}
+	suppressed := suppressions(code)
+	diagnostics := []Diagnostic{}
+	for _, d := range Program(prog) {
+		rules, ok := suppressed[d.Position.Line]
+		if ok && (len(rules) == 0 || rules[d.Rule]) {
+			continue
+		}
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics, nil
+}
+
+// suppressions returns the rules suppressed on each line by comments. An
+// empty set means every rule is suppressed.
+func suppressions(src []byte) map[int]map[string]bool {
+	suppressed := map[int]map[string]bool{}
+	l := NewLexerWithComments(src)
+	lastLine := 0
+	for {
+		pos, tok, val := l.Next()
+		if tok == EOF || tok == ILLEGAL {
+			return suppressed
+		}
+		if tok == COMMENT && strings.HasPrefix(val, suppressionPrefix) {
+			line := pos.Line
+			if lastLine != pos.Line {
+				// A comment on its own line applies to the next line
+				line++
+			}
+			rules := map[string]bool{}
+			for _, rule := range strings.FieldsFunc(val[len(suppressionPrefix):], func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			}) {
+				rules[rule] = true
+			}
+			suppressed[line] = rules
+		}
+		lastLine = pos.Line
+	}
+}
+
+// Program lints a parsed program and returns the problems found, sorted by
+// position.
+func Program(prog *parser.Program) []Diagnostic {
+	l := &linter{}
+	global := newScope(nil)
+	l.declare(global, prog.Statements)
+	l.block(global, prog.Statements)
+	l.reportUnused(global)
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		a, b := l.diagnostics[i].Position, l.diagnostics[j].Position
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.diagnostics
+}
+
+type linter struct {
+	diagnostics []Diagnostic
+}
+
+func (l *linter) report(pos Position, rule string, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{pos, rule, fmt.Sprintf(format, args...)})
+}
+
+// scope holds the names defined by one function body (or the top level of
+// the program). Blocks of if, while, for and select statements don't have
+// their own scope, as in the interpreter.
+type scope struct {
+	parent *scope
+	names  map[string]bool     // every name defined in this scope
+	vars   map[string]Position // variables defined by assignment, first assignment
+	used   map[string]bool
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent, map[string]bool{}, map[string]Position{}, map[string]bool{}}
+}
+
+func (s *scope) assign(name string, pos Position) {
+	s.names[name] = true
+	if _, ok := s.vars[name]; !ok {
+		s.vars[name] = pos
+	}
+}
+
+// lookup marks name as used in the innermost scope that defines it, and
+// returns that scope, or nil if no enclosing scope defines it.
+func (s *scope) lookup(name string) *scope {
+	for ; s != nil; s = s.parent {
+		if s.names[name] {
+			s.used[name] = true
+			return s
+		}
+	}
+	return nil
+}
+
+// declare adds the names defined by the statements of a function body to
+// its scope, so that names can be used before the statement that defines
+// them, as in a function that uses a global assigned further down.
+func (l *linter) declare(s *scope, block parser.Block) {
+	for _, stmt := range block {
+		switch stmt := stmt.(type) {
+		case *parser.Assign:
+			if v, ok := stmt.Target.(*parser.Variable); ok {
+				s.assign(v.Name, v.Position())
+			}
+		case *parser.For:
+			s.names[stmt.Name] = true
+			l.declare(s, stmt.Body)
+		case *parser.FunctionDefinition:
+			s.names[stmt.Name] = true
+		case *parser.ClassDefinition:
+			s.names[stmt.ClassName] = true
+		case *parser.If:
+			l.declare(s, stmt.Body)
+			l.declare(s, stmt.Else)
+		case *parser.While:
+			l.declare(s, stmt.Body)
+		case *parser.Select:
+			for _, c := range stmt.Cases {
+				if v, ok := c.Target.(*parser.Variable); ok {
+					s.assign(v.Name, v.Position())
+				}
+				l.declare(s, c.Body)
+			}
+			l.declare(s, stmt.Default)
+		}
+	}
+}
+
+// function lints the body of a function in a new scope.
+func (l *linter) function(parent *scope, params []string, body parser.Block) {
+	s := newScope(parent)
+	for _, param := range params {
+		s.names[param] = true
+	}
+	l.declare(s, body)
+	l.block(s, body)
+	l.reportUnused(s)
+}
+
+func (l *linter) reportUnused(s *scope) {
+	for name, pos := range s.vars {
+		if !s.used[name] && !strings.HasPrefix(name, "_") {
+			l.report(pos, UnusedVariable, "$%s is assigned but never used", name)
+		}
+	}
+}
+
+func (l *linter) block(s *scope, block parser.Block) {
+	returned := false
+	reported := false
+	for _, stmt := range block {
+		if isSemiTag(stmt) {
+			continue
+		}
+		if returned && !reported {
+			// Only report the first unreachable statement of a block
+			l.report(stmt.Position(), UnreachableCode, "unreachable code after return")
+			reported = true
+		}
+		l.statement(s, stmt)
+		if _, ok := stmt.(*parser.Return); ok {
+			returned = true
+		}
+	}
+}
+
+func isSemiTag(stmt parser.Statement) bool {
+	if e, ok := stmt.(*parser.ExpressionStatement); ok {
+		_, ok = e.Expression.(*parser.SemiTag)
+		return ok
+	}
+	return false
+}
+
+func (l *linter) statement(s *scope, stmt parser.Statement) {
+	switch stmt := stmt.(type) {
+	case *parser.Assign:
+		if sub, ok := stmt.Target.(*parser.Subscript); ok {
+			l.expression(s, sub.Container)
+			l.expression(s, sub.Subscript)
+		}
+		l.expression(s, stmt.Value)
+	case *parser.OuterAssign:
+		l.expression(s, stmt.Value)
+	case *parser.If:
+		l.condition(s, "if", stmt.Condition)
+		l.block(s, stmt.Body)
+		l.block(s, stmt.Else)
+	case *parser.While:
+		l.condition(s, "while", stmt.Condition)
+		l.block(s, stmt.Body)
+	case *parser.For:
+		l.expression(s, stmt.Iterable)
+		l.block(s, stmt.Body)
+	case *parser.Return:
+		l.expression(s, stmt.Result)
+	case *parser.ExpressionStatement:
+		l.expression(s, stmt.Expression)
+	case *parser.FunctionDefinition:
+		l.function(s, stmt.Parameters, stmt.Body)
+	case *parser.Spawn:
+		l.expression(s, stmt.Call)
+	case *parser.Select:
+		for _, c := range stmt.Cases {
+			l.expression(s, c.Channel)
+			if c.Value != nil {
+				l.expression(s, c.Value)
+			}
+			l.block(s, c.Body)
+		}
+		l.block(s, stmt.Default)
+	case *parser.ClassDefinition:
+		for _, member := range stmt.Body {
+			switch member := member.(type) {
+			case *parser.FunctionDefinition:
+				l.function(s, member.Parameters, member.Body)
+			case *parser.Assign:
+				// Fields aren't variables, so only check the value
+				l.expression(s, member.Value)
+			default:
+				l.statement(s, member)
+			}
+		}
+	}
+}
+
+// condition lints the condition of an if or while statement.
+func (l *linter) condition(s *scope, keyword string, cond parser.Expression) {
+	if typ := literalType(cond); typ != "" && typ != "bool" {
+		l.report(cond.Position(), NonBoolCondition, "%s condition is always %s, not bool", keyword, typ)
+	}
+	l.expression(s, cond)
+}
+
+// literalType returns the type of expr if it's made up only of literals,
+// otherwise "".
+func literalType(expr parser.Expression) string {
+	switch e := expr.(type) {
+	case *parser.Literal:
+		switch e.Value.(type) {
+		case int:
+			return "int"
+		case string:
+			return "str"
+		case bool:
+			return "bool"
+		case nil:
+			return "nil"
+		}
+	case *parser.List:
+		return "list"
+	case *parser.Map:
+		return "map"
+	case *parser.FunctionExpression:
+		return "func"
+	case *parser.Unary:
+		operand := literalType(e.Operand)
+		if operand == "" {
+			return ""
+		}
+		if e.Operator == NOT {
+			return "bool"
+		}
+		return operand
+	case *parser.Binary:
+		left, right := literalType(e.Left), literalType(e.Right)
+		if left == "" || right == "" {
+			return ""
+		}
+		switch e.Operator {
+		case EQUAL, NOTEQUAL, LT, LTE, GT, GTE, IN, AND, OR:
+			return "bool"
+		case PLUS, MINUS, TIMES, DIVIDE, MODULO:
+			if e.Operator == TIMES && left != right {
+				// "ab" * 3 and [1] * 3 are a str and a list
+				if left == "int" {
+					return right
+				}
+				return left
+			}
+			return left
+		}
+	}
+	return ""
+}
+
+func (l *linter) expressions(s *scope, exprs []parser.Expression) {
+	for _, expr := range exprs {
+		l.expression(s, expr)
+	}
+}
+
+func (l *linter) expression(s *scope, expr parser.Expression) {
+	switch e := expr.(type) {
+	case *parser.Variable:
+		// A variable written with a $ starts before its name
+		l.name(s, e.Position(), e.Name, e.Span().Start.Offset < e.Position().Offset)
+	case *parser.Binary:
+		l.expression(s, e.Left)
+		l.expression(s, e.Right)
+	case *parser.Unary:
+		l.expression(s, e.Operand)
+	case *parser.Await:
+		l.expression(s, e.Value)
+	case *parser.Call:
+		l.call(s, e)
+	case *parser.MethodCall:
+		l.expression(s, e.Object)
+		l.expressions(s, e.Arguments)
+	case *parser.PropertyAccess:
+		l.expression(s, e.Object)
+	case *parser.Subscript:
+		l.expression(s, e.Container)
+		l.expression(s, e.Subscript)
+	case *parser.List:
+		l.expressions(s, e.Values)
+	case *parser.Map:
+		for _, item := range e.Items {
+			l.expression(s, item.Key)
+			l.expression(s, item.Value)
+		}
+	case *parser.FunctionExpression:
+		l.function(s, e.Parameters, e.Body)
+	case *parser.NewExpression:
+		l.name(s, e.Position(), e.ClassName, false)
+		for _, arg := range e.Arguments {
+			l.name(s, e.Position(), arg, true)
+		}
+	}
+}
+
+// name checks that a variable, function or class name is defined. dollar
+// is whether it was written with a $, as variables are.
+func (l *linter) name(s *scope, pos Position, name string, dollar bool) {
+	if s.lookup(name) != nil {
+		return
+	}
+	if _, _, ok := interpreter.BuiltinArgs(name); ok {
+		return
+	}
+	if dollar {
+		name = "$" + name
+	}
+	l.report(pos, UndefinedName, "%s is not defined", name)
+}
+
+func (l *linter) call(s *scope, call *parser.Call) {
+	l.expression(s, call.Function)
+	l.expressions(s, call.Arguments)
+
+	v, ok := call.Function.(*parser.Variable)
+	if !ok || call.Ellipsis || s.lookup(v.Name) != nil {
+		return
+	}
+	min, max, ok := interpreter.BuiltinArgs(v.Name)
+	if !ok {
+		return
+	}
+	n := len(call.Arguments)
+	if n >= min && (max < 0 || n <= max) {
+		return
+	}
+	expected := ""
+	switch {
+	case max < 0:
+		expected = fmt.Sprintf("at least %s", plural(min, "arg"))
+	case min == max:
+		expected = plural(min, "arg")
+	default:
+		expected = fmt.Sprintf("%d to %d args", min, max)
+	}
+	l.report(v.Position(), BuiltinArity, "%s() requires %s, got %d", v.Name, expected, n)
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
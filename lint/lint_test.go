@@ -0,0 +1,77 @@
+// DaVinci Script
+
+package lint
+
+import (
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		source      string
+		diagnostics []string
+	}{
+		// unused-variable
+		{"$x = 1", []string{"1:2: $x is assigned but never used (unused-variable)"}},
+		{"$x = 1\necho($x)", nil},
+		{"function f() { $y = 1; $y = 2 }\nf()", []string{"1:17: $y is assigned but never used (unused-variable)"}},
+		{"function f($a) { $_unused = 1 }\nf(1)", nil},
+		{"function f() { return $g }\n$g = 1\nf()", nil},
+
+		// undefined-name
+		{"echo($nope)", []string{"1:7: $nope is not defined (undefined-name)"}},
+		{"nope()", []string{"1:1: nope is not defined (undefined-name)"}},
+		{"$c = new Nope($q)\necho($c)", []string{"1:6: Nope is not defined (undefined-name)", "1:6: $q is not defined (undefined-name)"}},
+		{"class C { }\nfunction f($a) { return new C($a) }\nf(1)", nil},
+		{"for ($x in [1]) { echo($x) }", nil},
+
+		// builtin-arity
+		{"len()", []string{"1:1: len() requires 1 arg, got 0 (builtin-arity)"}},
+		{"len([1], [2])", []string{"1:1: len() requires 1 arg, got 2 (builtin-arity)"}},
+		{"echo(1, 2, 3)", nil},
+		{"$l = [1]\nlen($l...)", nil},
+
+		// unreachable-code
+		{"function f() { return 1; echo(2); echo(3) }\nf()", []string{"1:26: unreachable code after return (unreachable-code)"}},
+		{"function f() { if (true) { return 1 } echo(2) }\nf()", nil},
+
+		// non-bool-condition
+		{"if (1) { }", []string{"1:5: if condition is always int, not bool (non-bool-condition)"}},
+		{"while (\"a\" + \"b\") { }", []string{"1:12: while condition is always str, not bool (non-bool-condition)"}},
+		{"if (1 < 2) { }\nwhile (true) { }", nil},
+
+		// Suppression comments, at the end of a line or on the line above
+		{"$x = 1 // davi-lint-ignore", nil},
+		{"$x = 1 // davi-lint-ignore unused-variable", nil},
+		{"// davi-lint-ignore unused-variable\n$x = 1", nil},
+		{"$x = 1 // davi-lint-ignore undefined-name", []string{"1:2: $x is assigned but never used (unused-variable)"}},
+		{"$x = $y // davi-lint-ignore undefined-name, unused-variable", nil},
+		{"// davi-lint-ignore\n\n$x = 1", []string{"3:2: $x is assigned but never used (unused-variable)"}},
+
+		// Tags and sorting by position
+		{"<?davi\necho($b, $a)\n?>", []string{"2:7: $b is not defined (undefined-name)", "2:11: $a is not defined (undefined-name)"}},
+	}
+	for _, test := range tests {
+		diagnostics, err := Source([]byte(test.source))
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+			continue
+		}
+		got := []string{}
+		for _, d := range diagnostics {
+			got = append(got, d.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.diagnostics, "\n") {
+			t.Errorf("%q: expected\n%s\ngot\n%s", test.source, strings.Join(test.diagnostics, "\n"), strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source([]byte("$x = (1"))
+	if _, ok := err.(parser.Error); !ok {
+		t.Fatalf("expected a parser.Error, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// DaVinci Script
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteLintProblems(t *testing.T) {
+	problems := []lintProblem{
+		{"a.davi", 1, 2, "unused-variable", "$x is assigned but never used"},
+		{"b.davi", 3, 7, "undefined-name", "$nope is not defined"},
+	}
+	tests := []struct {
+		problems   []lintProblem
+		jsonOutput bool
+		output     string
+	}{
+		{problems, false, "a.davi:1:2: $x is assigned but never used (unused-variable)\nb.davi:3:7: $nope is not defined (undefined-name)\n"},
+		{problems, true, `[
+  {
+    "file": "a.davi",
+    "line": 1,
+    "column": 2,
+    "rule": "unused-variable",
+    "message": "$x is assigned but never used"
+  },
+  {
+    "file": "b.davi",
+    "line": 3,
+    "column": 7,
+    "rule": "undefined-name",
+    "message": "$nope is not defined"
+  }
+]
+`},
+		{[]lintProblem{}, false, ""},
+		{[]lintProblem{}, true, "[]\n"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		writeLintProblems(&out, test.problems, test.jsonOutput)
+		if out.String() != test.output {
+			t.Errorf("%d problems, json %v: expected\n%s\ngot\n%s", len(test.problems), test.jsonOutput, test.output, out.String())
+		}
+	}
+}
@@ -0,0 +1,522 @@
+// DaVinci Script
+
+package lsp
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	. "github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/lint"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is an open text document and what the server knows about it.
+// Definitions are found from the tokens rather than the AST, so that they
+// have the positions of their names and still work while the rest of the
+// document has syntax errors.
+type document struct {
+	uri         string
+	text        string
+	lines       []string
+	tokens      []token
+	match       map[int]int // index of the matching bracket of each bracket token
+	definitions []*definition
+}
+
+type token struct {
+	pos Position
+	end Position
+	tok Token
+	val string
+}
+
+// definition is a function, method, class or variable defined in a document.
+type definition struct {
+	name      string
+	kind      int      // symbolFunction, symbolMethod, symbolClass or symbolVariable
+	pos       Position // position of the name
+	start     Position // start of the definition, for functions and classes
+	end       Position // end of the definition's body, for functions and classes
+	detail    string
+	class     string // class of a method
+	topLevel  bool
+	parameter bool
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, text: text, lines: strings.Split(text, "\n"), match: map[int]int{}}
+	l := NewLexer(parser.StripTags([]byte(text)))
+	for {
+		pos, tok, val := l.Next()
+		if tok == EOF || tok == ILLEGAL {
+			break
+		}
+		d.tokens = append(d.tokens, token{pos, l.End(), tok, val})
+	}
+	d.matchBrackets()
+	d.findDefinitions()
+	return d
+}
+
+func (d *document) matchBrackets() {
+	stack := []int{}
+	for i, t := range d.tokens {
+		switch t.tok {
+		case LPAREN, LBRACKET, LBRACE:
+			stack = append(stack, i)
+		case RPAREN, RBRACKET, RBRACE:
+			if len(stack) > 0 {
+				open := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				d.match[open] = i
+				d.match[i] = open
+			}
+		}
+	}
+}
+
+// tokenAt returns the token at index i, or an ILLEGAL token if there is
+// none.
+func (d *document) tokenAt(i int) token {
+	if i < 0 || i >= len(d.tokens) {
+		return token{tok: ILLEGAL}
+	}
+	return d.tokens[i]
+}
+
+// blockEnd returns the position just after the block whose { is at index i.
+func (d *document) blockEnd(i int) Position {
+	if d.tokenAt(i).tok != LBRACE {
+		return d.tokenAt(i - 1).pos
+	}
+	end, ok := d.match[i]
+	if !ok {
+		end = len(d.tokens) - 1
+	}
+	pos := d.tokens[end].pos
+	pos.Column++
+	return pos
+}
+
+func (d *document) findDefinitions() {
+	type class struct {
+		name string
+		end  int
+	}
+	classes := []class{}
+	depth := 0
+	seen := map[string]bool{}
+	for i, t := range d.tokens {
+		for len(classes) > 0 && i > classes[len(classes)-1].end {
+			classes = classes[:len(classes)-1]
+		}
+		switch t.tok {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		case CLASS:
+			name := d.tokenAt(i + 1)
+			if name.tok != NAME {
+				continue
+			}
+			end, ok := d.match[i+2]
+			if !ok {
+				end = len(d.tokens)
+			}
+			classes = append(classes, class{name.val, end})
+			d.definitions = append(d.definitions, &definition{
+				name: name.val, kind: symbolClass, pos: name.pos, start: t.pos, end: d.blockEnd(i + 2),
+				detail: "class " + name.val, topLevel: depth == 0,
+			})
+		case FUNCTION:
+			name := d.tokenAt(i + 1)
+			params := i + 1
+			if name.tok == NAME {
+				params++
+			}
+			if d.tokenAt(params).tok != LPAREN {
+				continue
+			}
+			closeParams, ok := d.match[params]
+			if !ok {
+				continue
+			}
+			// Parameters are variables defined in the function
+			names := []string{}
+			for j := params + 1; j < closeParams; j++ {
+				if d.tokens[j].tok == DOLLAR && d.tokenAt(j+1).tok == NAME {
+					param := d.tokens[j+1]
+					names = append(names, "$"+param.val)
+					if d.tokenAt(j+2).tok == ELLIPSIS {
+						names[len(names)-1] += "..."
+					}
+					d.definitions = append(d.definitions, &definition{
+						name: param.val, kind: symbolVariable, pos: param.pos,
+						detail: "parameter $" + param.val, parameter: true,
+					})
+				}
+			}
+			if name.tok != NAME {
+				continue
+			}
+			def := &definition{
+				name: name.val, kind: symbolFunction, pos: name.pos, start: t.pos, end: d.blockEnd(closeParams + 1),
+				detail:   fmt.Sprintf("function %s(%s)", name.val, strings.Join(names, ", ")),
+				topLevel: depth == 0,
+			}
+			if async := d.tokenAt(i - 1); async.tok == ASYNC {
+				def.start = async.pos
+				def.detail = "async " + def.detail
+			}
+			if len(classes) > 0 && depth == d.classDepth(classes[len(classes)-1].end) {
+				def.kind = symbolMethod
+				def.class = classes[len(classes)-1].name
+				def.detail = def.class + "->" + strings.TrimPrefix(def.detail, "function ")
+			}
+			d.definitions = append(d.definitions, def)
+		case DOLLAR:
+			name := d.tokenAt(i + 1)
+			if name.tok != NAME {
+				continue
+			}
+			next := d.tokenAt(i + 2)
+			isFor := d.tokenAt(i-1).tok == LPAREN && d.tokenAt(i-2).tok == FOR
+			if next.tok != ASSIGN && !isFor {
+				continue
+			}
+			d.definitions = append(d.definitions, &definition{
+				name: name.val, kind: symbolVariable, pos: name.pos,
+				detail: "$" + name.val, topLevel: depth == 0 && !seen[name.val],
+			})
+			if depth == 0 {
+				seen[name.val] = true
+			}
+		}
+	}
+}
+
+// classDepth returns the brace depth of the statements in the body of the
+// class whose closing brace is at index end.
+func (d *document) classDepth(end int) int {
+	depth := 0
+	for i := 0; i < end && i < len(d.tokens); i++ {
+		switch d.tokens[i].tok {
+		case LBRACE:
+			depth++
+		case RBRACE:
+			depth--
+		}
+	}
+	return depth
+}
+
+// diagnostics returns the syntax error or the lint problems of the document.
+func (d *document) diagnostics() []diagnostic {
+	diagnostics := []diagnostic{}
+	problems, err := lint.Source([]byte(d.text))
+	if err != nil {
+		r := d.tokenRange(Position{Line: 1, Column: 1})
+		message := err.Error()
+		if e, ok := err.(parser.Error); ok {
+			r = d.tokenRange(e.Position)
+			if e.End.Offset > e.Position.Offset {
+				r = d.toRange(e.Position, e.End)
+			}
+			message = e.Message
+		}
+		return append(diagnostics, diagnostic{r, severityError, "", "davi", message})
+	}
+	for _, p := range problems {
+		diagnostics = append(diagnostics, diagnostic{d.tokenRange(p.Position), severityWarning, p.Rule, "davi lint", p.Message})
+	}
+	return diagnostics
+}
+
+// tokenRange returns the range of the token starting at pos, or of the
+// single character at pos if there's no token there.
+func (d *document) tokenRange(pos Position) rangeT {
+	end := pos
+	end.Column++
+	for _, t := range d.tokens {
+		if t.pos == pos {
+			end = t.end
+			break
+		}
+	}
+	return rangeT{d.toLSP(pos), d.toLSP(end)}
+}
+
+// toLSP converts a Davi position (1-based line and column in runes) to an
+// LSP position (0-based line and character in UTF-16 code units).
+func (d *document) toLSP(pos Position) position {
+	line := pos.Line - 1
+	if line >= len(d.lines) {
+		line = len(d.lines) - 1
+	}
+	if line < 0 {
+		return position{0, 0}
+	}
+	runes := []rune(d.lines[line])
+	column := pos.Column - 1
+	if column > len(runes) {
+		column = len(runes)
+	}
+	if column < 0 {
+		column = 0
+	}
+	return position{line, len(utf16.Encode(runes[:column]))}
+}
+
+// fromLSP converts an LSP position to a Davi position.
+func (d *document) fromLSP(pos position) Position {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return Position{Line: pos.Line + 1, Column: 1}
+	}
+	units := utf16.Encode([]rune(d.lines[pos.Line]))
+	if pos.Character < len(units) {
+		units = units[:pos.Character]
+	}
+	return Position{Line: pos.Line + 1, Column: len(utf16.Decode(units)) + 1}
+}
+
+func (d *document) toRange(start, end Position) rangeT {
+	return rangeT{d.toLSP(start), d.toLSP(end)}
+}
+
+// nameAt returns the index of the NAME token at or just before pos, or -1.
+func (d *document) nameAt(pos Position) int {
+	for i, t := range d.tokens {
+		if t.pos.Line != pos.Line || t.tok != NAME {
+			continue
+		}
+		end := t.pos.Column + utf8.RuneCountInString(t.val)
+		if pos.Column >= t.pos.Column && pos.Column <= end {
+			return i
+		}
+	}
+	return -1
+}
+
+// lookup returns the definitions that the NAME token at index i may refer
+// to, best match first.
+func (d *document) lookup(i int) []*definition {
+	t := d.tokens[i]
+	prev := d.tokenAt(i - 1).tok
+	var kinds []int
+	switch prev {
+	case OBJECT_OPERATOR:
+		kinds = []int{symbolMethod}
+	case DOLLAR:
+		kinds = []int{symbolVariable, symbolFunction, symbolClass}
+	default:
+		kinds = []int{symbolFunction, symbolClass, symbolVariable}
+	}
+	for _, kind := range kinds {
+		defs := []*definition{}
+		for _, def := range d.definitions {
+			if def.name == t.val && def.kind == kind {
+				defs = append(defs, def)
+			}
+		}
+		if len(defs) == 0 {
+			continue
+		}
+		if kind == symbolVariable {
+			// Prefer the closest definition before the use
+			best := -1
+			for j, def := range defs {
+				if before(def.pos, t.pos) || def.pos == t.pos {
+					best = j
+				}
+			}
+			if best > 0 {
+				defs[0], defs[best] = defs[best], defs[0]
+			}
+		}
+		return defs
+	}
+	return nil
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+// hover returns the hover text for the name at pos, if any.
+func (d *document) hover(pos Position) *hover {
+	i := d.nameAt(pos)
+	if i < 0 {
+		return nil
+	}
+	t := d.tokens[i]
+	r := d.tokenRange(t.pos)
+	defs := d.lookup(i)
+	if len(defs) > 0 && (defs[0].kind != symbolVariable || d.tokenAt(i-1).tok == DOLLAR) {
+		return &hover{markupContent{"markdown", "```php\n" + defs[0].detail + "\n```"}, &r}
+	}
+	if doc, ok := interpreter.FunctionDocs()[t.val]; ok && d.tokenAt(i-1).tok != DOLLAR {
+		return &hover{markupContent{"markdown", builtinMarkdown(doc)}, &r}
+	}
+	return nil
+}
+
+func builtinMarkdown(doc interpreter.FunctionDoc) string {
+	args := doc.Args
+	if args == "none" {
+		args = ""
+	}
+	s := fmt.Sprintf("```php\n%s(%s)\n```\n\n%s", doc.Name, args, doc.Description)
+	if doc.Return != "" {
+		s += fmt.Sprintf("\n\nReturns `%s`.", doc.Return)
+	}
+	if doc.Example != "" {
+		s += fmt.Sprintf("\n\n```php\n%s\n// output: %s\n```", doc.Example, doc.Output)
+	}
+	return s
+}
+
+// definition returns the location of the definition of the name at pos.
+func (d *document) definition(pos Position) []location {
+	i := d.nameAt(pos)
+	if i < 0 {
+		return nil
+	}
+	locations := []location{}
+	for _, def := range d.lookup(i) {
+		end := def.pos
+		end.Column += utf8.RuneCountInString(def.name)
+		locations = append(locations, location{d.uri, d.toRange(def.pos, end)})
+	}
+	return locations
+}
+
+// symbols returns the document's classes (with their methods), functions
+// and global variables.
+func (d *document) symbols() []documentSymbol {
+	symbols := []documentSymbol{}
+	classes := map[string]int{}
+	for _, def := range d.definitions {
+		nameEnd := def.pos
+		nameEnd.Column += utf8.RuneCountInString(def.name)
+		start, end := def.start, def.end
+		if end.Line == 0 {
+			start, end = def.pos, nameEnd
+		}
+		symbol := documentSymbol{
+			Name:           def.name,
+			Detail:         def.detail,
+			Kind:           def.kind,
+			Range:          d.toRange(start, end),
+			SelectionRange: d.toRange(def.pos, nameEnd),
+		}
+		switch {
+		case def.kind == symbolMethod:
+			if i, ok := classes[def.class]; ok {
+				symbols[i].Children = append(symbols[i].Children, symbol)
+			}
+		case def.kind == symbolClass && def.topLevel:
+			classes[def.name] = len(symbols)
+			symbols = append(symbols, symbol)
+		case def.topLevel && !def.parameter:
+			if def.kind == symbolVariable {
+				symbol.Name = "$" + def.name
+			}
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// completions returns the completions for the word being typed at pos.
+func (d *document) completions(pos Position) []completionItem {
+	line := ""
+	if pos.Line-1 < len(d.lines) {
+		line = d.lines[pos.Line-1]
+	}
+	runes := []rune(line)
+	if pos.Column-1 < len(runes) {
+		runes = runes[:pos.Column-1]
+	}
+	start := len(runes)
+	for start > 0 && isNameRune(runes[start-1]) {
+		start--
+	}
+	prefix := string(runes[start:])
+	before := string(runes[:start])
+
+	items := []completionItem{}
+	seen := map[string]bool{}
+	add := func(item completionItem) {
+		if strings.HasPrefix(item.Label, prefix) && !seen[item.Label] {
+			seen[item.Label] = true
+			items = append(items, item)
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(before, "->"):
+		class := d.classOf(strings.TrimSuffix(before, "->"))
+		for _, def := range d.definitions {
+			if def.kind == symbolMethod && (class == "" || def.class == class) {
+				add(completionItem{Label: def.name, Kind: completionMethod, Detail: def.detail})
+			}
+		}
+	case strings.HasSuffix(before, "$"):
+		for _, def := range d.definitions {
+			if def.kind == symbolVariable {
+				add(completionItem{Label: def.name, Kind: completionVariable, Detail: def.detail})
+			}
+		}
+	default:
+		for _, def := range d.definitions {
+			switch def.kind {
+			case symbolFunction:
+				add(completionItem{Label: def.name, Kind: completionFunction, Detail: def.detail})
+			case symbolClass:
+				add(completionItem{Label: def.name, Kind: completionClass, Detail: def.detail})
+			}
+		}
+		for name, doc := range interpreter.FunctionDocs() {
+			add(completionItem{
+				Label:         name,
+				Kind:          completionFunction,
+				Detail:        fmt.Sprintf("%s(%s)", name, doc.Args),
+				Documentation: &markupContent{"markdown", builtinMarkdown(doc)},
+			})
+		}
+		for _, keyword := range Keywords() {
+			add(completionItem{Label: keyword, Kind: completionKeyword})
+		}
+	}
+	return items
+}
+
+// classOf returns the class of the object expression at the end of s, if
+// it's a variable assigned with new, otherwise "".
+func (d *document) classOf(s string) string {
+	end := len(s)
+	start := end
+	for start > 0 && isNameRune(rune(s[start-1])) {
+		start--
+	}
+	if start == 0 || s[start-1] != '$' {
+		return ""
+	}
+	name := s[start:end]
+	class := ""
+	for i, t := range d.tokens {
+		if t.tok == DOLLAR && d.tokenAt(i+1).val == name && d.tokenAt(i+2).tok == ASSIGN &&
+			d.tokenAt(i+3).tok == NEW && d.tokenAt(i+4).tok == NAME {
+			class = d.tokens[i+4].val
+		}
+	}
+	return class
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
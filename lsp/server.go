@@ -0,0 +1,206 @@
+// DaVinci Script
+
+// Package lsp implements a Language Server Protocol server for Davi, which
+// gives editors diagnostics, hover docs, go-to-definition, document symbols
+// and completion.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/DavinciScript/Davi/lexer"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// server holds the state of one client connection.
+type server struct {
+	in        *bufio.Reader
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+}
+
+// Serve runs a language server that reads JSON-RPC messages from in and
+// writes responses and notifications to out, until the client sends the
+// exit notification or in is closed.
+func Serve(in io.Reader, out io.Writer) error {
+	s := &server{in: bufio.NewReader(in), out: out, documents: map[string]*document{}}
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.reply(nil, nil, &responseError{codeParseError, err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(req)
+		if req.ID != nil {
+			s.reply(req.ID, result, rerr)
+		}
+	}
+}
+
+// read reads the body of the next message.
+func (s *server) read() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(s.in, body)
+	return body, err
+}
+
+func (s *server) write(message interface{}) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (s *server) reply(id *json.RawMessage, result interface{}, err *responseError) {
+	s.write(response{"2.0", id, result, err})
+}
+
+func (s *server) notify(method string, params interface{}) {
+	s.write(notification{"2.0", method, params})
+}
+
+// handle handles a request or notification and returns the result of a
+// request.
+func (s *server) handle(req request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // Full
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"$", ">"},
+				},
+			},
+			"serverInfo": map[string]string{"name": "davi"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}})
+
+	case "textDocument/hover":
+		d, pos, err := s.position(req.Params)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		if h := d.hover(pos); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/definition":
+		d, pos, err := s.position(req.Params)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return d.definition(pos), nil
+	case "textDocument/completion":
+		d, pos, err := s.position(req.Params)
+		if err != nil || d == nil {
+			return nil, err
+		}
+		return d.completions(pos), nil
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		d := s.documents[params.TextDocument.URI]
+		if d == nil {
+			return []documentSymbol{}, nil
+		}
+		return d.symbols(), nil
+
+	default:
+		if req.ID != nil {
+			return nil, &responseError{codeMethodNotFound, "method not found: " + req.Method}
+		}
+	}
+	return nil, nil
+}
+
+// update replaces the text of a document and publishes its diagnostics.
+func (s *server) update(uri, text string) {
+	d := newDocument(uri, text)
+	s.documents[uri] = d
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{uri, d.diagnostics()})
+}
+
+// position returns the document and position of a text document position
+// request.
+func (s *server) position(raw json.RawMessage) (*document, lexer.Position, *responseError) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, lexer.Position{}, invalidParams(err)
+	}
+	d := s.documents[params.TextDocument.URI]
+	if d == nil {
+		return nil, lexer.Position{}, nil
+	}
+	return d, d.fromLSP(params.Position), nil
+}
+
+func invalidParams(err error) *responseError {
+	return &responseError{codeInvalidParams, err.Error()}
+}
@@ -0,0 +1,162 @@
+// DaVinci Script
+
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testText = `<?davi
+$count = 1
+function add($a, $b) {
+    return $a + $b
+}
+class Greeter {
+    function greet($name) {
+        echo("Hi " + $name)
+    }
+}
+$g = new Greeter()
+$g->greet("Ann")
+echo(add($count, len([])))
+$unused = 2
+?>`
+
+// frame returns messages framed with Content-Length headers.
+func frame(messages ...string) *bytes.Buffer {
+	var b bytes.Buffer
+	for _, m := range messages {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	return &b
+}
+
+// unframe splits the output of the server into message bodies.
+func unframe(out []byte) []string {
+	s := &server{in: bufio.NewReader(bytes.NewReader(out))}
+	messages := []string{}
+	for {
+		body, err := s.read()
+		if err != nil {
+			return messages
+		}
+		messages = append(messages, string(body))
+	}
+}
+
+func positionRequest(id int, method string, line, character int) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":{"textDocument":{"uri":"file:///a.davi"},"position":{"line":%d,"character":%d}}}`,
+		id, method, line, character)
+}
+
+func TestServe(t *testing.T) {
+	open, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "textDocument/didOpen",
+		"params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": "file:///a.davi", "version": 1, "text": testText},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		message   string
+		responses []string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, []string{
+			`{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"completionProvider":{"triggerCharacters":["$","\u003e"]},"definitionProvider":true,"documentSymbolProvider":true,"hoverProvider":true,"textDocumentSync":1},"serverInfo":{"name":"davi"}}}`,
+		}},
+		// Opening a document publishes its lint problems
+		{string(open), []string{
+			`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.davi","diagnostics":[{"range":{"start":{"line":13,"character":1},"end":{"line":13,"character":7}},"severity":2,"code":"unused-variable","source":"davi lint","message":"$unused is assigned but never used"}]}}`,
+		}},
+		// Hover on a user function and on a builtin
+		{positionRequest(2, "textDocument/hover", 12, 6), []string{
+			`{"jsonrpc":"2.0","id":2,"result":{"contents":{"kind":"markdown","value":"` + "```php\\nfunction add($a, $b)\\n```" + `"},"range":{"start":{"line":12,"character":5},"end":{"line":12,"character":8}}}}`,
+		}},
+		{positionRequest(3, "textDocument/hover", 12, 18), []string{
+			`{"jsonrpc":"2.0","id":3,"result":{"contents":{"kind":"markdown","value":"` + "```php\\nlen(value)\\n```\\n\\nGet the length of a string, list, or map.\\n\\nReturns `int`.\\n\\n```php\\nlen(\\\"hello\\\")\\n// output: 5\\n```" + `"},"range":{"start":{"line":12,"character":17},"end":{"line":12,"character":20}}}}`,
+		}},
+		{positionRequest(4, "textDocument/hover", 3, 1), []string{
+			`{"jsonrpc":"2.0","id":4,"result":null}`,
+		}},
+		// Definition of a global variable and of a method
+		{positionRequest(5, "textDocument/definition", 12, 11), []string{
+			`{"jsonrpc":"2.0","id":5,"result":[{"uri":"file:///a.davi","range":{"start":{"line":1,"character":1},"end":{"line":1,"character":6}}}]}`,
+		}},
+		{positionRequest(6, "textDocument/definition", 11, 6), []string{
+			`{"jsonrpc":"2.0","id":6,"result":[{"uri":"file:///a.davi","range":{"start":{"line":6,"character":13},"end":{"line":6,"character":18}}}]}`,
+		}},
+		// Symbols, with methods inside their class
+		{`{"jsonrpc":"2.0","id":7,"method":"textDocument/documentSymbol","params":{"textDocument":{"uri":"file:///a.davi"}}}`, []string{
+			`{"jsonrpc":"2.0","id":7,"result":[` +
+				`{"name":"$count","detail":"$count","kind":13,"range":{"start":{"line":1,"character":1},"end":{"line":1,"character":6}},"selectionRange":{"start":{"line":1,"character":1},"end":{"line":1,"character":6}}},` +
+				`{"name":"add","detail":"function add($a, $b)","kind":12,"range":{"start":{"line":2,"character":0},"end":{"line":4,"character":1}},"selectionRange":{"start":{"line":2,"character":9},"end":{"line":2,"character":12}}},` +
+				`{"name":"Greeter","detail":"class Greeter","kind":5,"range":{"start":{"line":5,"character":0},"end":{"line":9,"character":1}},"selectionRange":{"start":{"line":5,"character":6},"end":{"line":5,"character":13}},"children":[` +
+				`{"name":"greet","detail":"Greeter-\u003egreet($name)","kind":6,"range":{"start":{"line":6,"character":4},"end":{"line":8,"character":5}},"selectionRange":{"start":{"line":6,"character":13},"end":{"line":6,"character":18}}}]},` +
+				`{"name":"$g","detail":"$g","kind":13,"range":{"start":{"line":10,"character":1},"end":{"line":10,"character":2}},"selectionRange":{"start":{"line":10,"character":1},"end":{"line":10,"character":2}}},` +
+				`{"name":"$unused","detail":"$unused","kind":13,"range":{"start":{"line":13,"character":1},"end":{"line":13,"character":7}},"selectionRange":{"start":{"line":13,"character":1},"end":{"line":13,"character":7}}}]}`,
+		}},
+		// Completion of variables after a $ and of methods after ->
+		{positionRequest(8, "textDocument/completion", 12, 12), []string{
+			`{"jsonrpc":"2.0","id":8,"result":[{"label":"count","kind":6,"detail":"$count"}]}`,
+		}},
+		{positionRequest(9, "textDocument/completion", 11, 6), []string{
+			`{"jsonrpc":"2.0","id":9,"result":[{"label":"greet","kind":2,"detail":"Greeter-\u003egreet($name)"}]}`,
+		}},
+		// Changing the document publishes its syntax error
+		{`{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.davi"},"contentChanges":[{"text":"$x = (1"}]}}`, []string{
+			`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.davi","diagnostics":[{"range":{"start":{"line":0,"character":7},"end":{"line":0,"character":7}},"severity":1,"source":"davi","message":"expected ) and not EOF call_from: [primary]"}]}}`,
+		}},
+		// and closing it clears its diagnostics
+		{`{"jsonrpc":"2.0","method":"textDocument/didClose","params":{"textDocument":{"uri":"file:///a.davi"}}}`, []string{
+			`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a.davi","diagnostics":[]}}`,
+		}},
+		{positionRequest(10, "textDocument/hover", 0, 1), []string{
+			`{"jsonrpc":"2.0","id":10,"result":null}`,
+		}},
+		{`{"jsonrpc":"2.0","id":11,"method":"bogus"}`, []string{
+			`{"jsonrpc":"2.0","id":11,"result":null,"error":{"code":-32601,"message":"method not found: bogus"}}`,
+		}},
+		{`{"jsonrpc":"2.0","id":12,"method":"shutdown"}`, []string{
+			`{"jsonrpc":"2.0","id":12,"result":null}`,
+		}},
+		{`{"jsonrpc":"2.0","method":"exit"}`, nil},
+	}
+
+	messages := []string{}
+	expected := []string{}
+	for _, test := range tests {
+		messages = append(messages, test.message)
+		expected = append(expected, test.responses...)
+	}
+	var out bytes.Buffer
+	if err := Serve(frame(messages...), &out); err != nil {
+		t.Fatal(err)
+	}
+	got := unframe(out.Bytes())
+	for i := 0; i < len(expected) || i < len(got); i++ {
+		switch {
+		case i >= len(got):
+			t.Errorf("expected\n%s\ngot nothing", expected[i])
+		case i >= len(expected):
+			t.Errorf("unexpected\n%s", got[i])
+		case got[i] != expected[i]:
+			t.Errorf("expected\n%s\ngot\n%s", expected[i], got[i])
+		}
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	var out bytes.Buffer
+	err := Serve(frame(`{"jsonrpc":"2.0","method":"exit"}`), &out)
+	if err == nil || !strings.Contains(err.Error(), "exit without shutdown") {
+		t.Fatalf("expected exit without shutdown error, got %v", err)
+	}
+}
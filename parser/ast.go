@@ -26,19 +26,31 @@ func (b Block) String() string {
 	return strings.Join(lines, "\n")
 }
 
+// Span is the range of source a node was parsed from: Start is the
+// position of its first character and End the position just after its
+// last. A node's Position, where errors in it are reported, may be inside
+// its Span; for example, a Binary's Position is that of its operator.
+type Span struct {
+	Start Position
+	End   Position
+}
+
 type Statement interface {
 	Position() Position
+	Span() Span
 	statementNode()
 }
 
 type Assign struct {
 	pos    Position
+	span   Span
 	Target Expression
 	Value  Expression
 }
 
 func (s *Assign) statementNode()     {}
 func (s *Assign) Position() Position { return s.pos }
+func (s *Assign) Span() Span         { return s.span }
 
 func (s *Assign) String() string {
 	return fmt.Sprintf("%s = %s", s.Target, s.Value)
@@ -46,12 +58,14 @@ func (s *Assign) String() string {
 
 type OuterAssign struct {
 	pos   Position
+	span  Span
 	Name  string
 	Value Expression
 }
 
 func (s *OuterAssign) statementNode()     {}
 func (s *OuterAssign) Position() Position { return s.pos }
+func (s *OuterAssign) Span() Span         { return s.span }
 
 func (s *OuterAssign) String() string {
 	return fmt.Sprintf("outer %s = %s", s.Name, s.Value)
@@ -59,6 +73,7 @@ func (s *OuterAssign) String() string {
 
 type If struct {
 	pos       Position
+	span      Span
 	Condition Expression
 	Body      Block
 	Else      Block
@@ -66,6 +81,7 @@ type If struct {
 
 func (s *If) statementNode()     {}
 func (s *If) Position() Position { return s.pos }
+func (s *If) Span() Span         { return s.span }
 
 func indent(s string) string {
 	input := strings.Split(s, "\n")
@@ -86,12 +102,14 @@ func (s *If) String() string {
 
 type While struct {
 	pos       Position
+	span      Span
 	Condition Expression
 	Body      Block
 }
 
 func (s *While) statementNode()     {}
 func (s *While) Position() Position { return s.pos }
+func (s *While) Span() Span         { return s.span }
 
 func (s *While) String() string {
 	return fmt.Sprintf("while %s {\n%s\n}", s.Condition, indent(s.Body.String()))
@@ -99,13 +117,16 @@ func (s *While) String() string {
 
 type For struct {
 	pos      Position
+	span     Span
 	Name     string
 	Iterable Expression
 	Body     Block
+	Slot     Slot // where the loop variable is stored
 }
 
 func (s *For) statementNode()     {}
 func (s *For) Position() Position { return s.pos }
+func (s *For) Span() Span         { return s.span }
 
 func (s *For) String() string {
 	return fmt.Sprintf("for %s in %s {\n%s\n}", s.Name, s.Iterable, indent(s.Body.String()))
@@ -113,11 +134,13 @@ func (s *For) String() string {
 
 type Return struct {
 	pos    Position
+	span   Span
 	Result Expression
 }
 
 func (s *Return) statementNode()     {}
 func (s *Return) Position() Position { return s.pos }
+func (s *Return) Span() Span         { return s.span }
 
 func (s *Return) String() string {
 	return fmt.Sprintf("return %s", s.Result)
@@ -125,11 +148,13 @@ func (s *Return) String() string {
 
 type ExpressionStatement struct {
 	pos        Position
+	span       Span
 	Expression Expression
 }
 
 func (s *ExpressionStatement) statementNode()     {}
 func (s *ExpressionStatement) Position() Position { return s.pos }
+func (s *ExpressionStatement) Span() Span         { return s.span }
 
 func (s *ExpressionStatement) String() string {
 	return fmt.Sprintf("%s", s.Expression)
@@ -137,14 +162,19 @@ func (s *ExpressionStatement) String() string {
 
 type FunctionDefinition struct {
 	pos        Position
+	span       Span
 	Name       string
 	Parameters []string
 	Ellipsis   bool
 	Body       Block
+	Async      bool
+	Slot       Slot     // where the function is stored
+	Locals     []string // names of the function's local slots
 }
 
 func (s *FunctionDefinition) statementNode()     {}
 func (s *FunctionDefinition) Position() Position { return s.pos }
+func (s *FunctionDefinition) Span() Span         { return s.span }
 
 func (s *FunctionDefinition) String() string {
 	ellipsisStr := ""
@@ -155,17 +185,94 @@ func (s *FunctionDefinition) String() string {
 	if len(s.Body) != 0 {
 		bodyStr = "\n" + indent(s.Body.String()) + "\n"
 	}
-	return fmt.Sprintf("function %s(%s%s) {%s}",
-		s.Name, strings.Join(s.Parameters, ", "), ellipsisStr, bodyStr)
+	asyncStr := ""
+	if s.Async {
+		asyncStr = "async "
+	}
+	return fmt.Sprintf("%sfunction %s(%s%s) {%s}",
+		asyncStr, s.Name, strings.Join(s.Parameters, ", "), ellipsisStr, bodyStr)
+}
+
+// Spawn runs a function call on its own goroutine, e.g. `spawn worker($ch)`
+type Spawn struct {
+	pos  Position
+	span Span
+	Call *Call
+}
+
+func (s *Spawn) statementNode()     {}
+func (s *Spawn) Position() Position { return s.pos }
+func (s *Spawn) Span() Span         { return s.span }
+
+func (s *Spawn) String() string {
+	return fmt.Sprintf("spawn %s", s.Call)
+}
+
+// SelectCase is a single send or receive case of a select statement. For a
+// receive case Target is the optional variable the received value is
+// assigned to and Value is nil; for a send case Value is the value sent.
+type SelectCase struct {
+	pos     Position
+	span    Span
+	Target  Expression
+	Channel Expression
+	Value   Expression
+	Body    Block
+}
+
+func (c *SelectCase) Position() Position { return c.pos }
+func (c *SelectCase) Span() Span         { return c.span }
+
+// IsSend reports whether the case sends on its channel (otherwise it receives).
+func (c *SelectCase) IsSend() bool { return c.Value != nil }
+
+func (c *SelectCase) String() string {
+	op := ""
+	if c.IsSend() {
+		op = fmt.Sprintf("send(%s, %s)", c.Channel, c.Value)
+	} else {
+		op = fmt.Sprintf("recv(%s)", c.Channel)
+		if c.Target != nil {
+			op = fmt.Sprintf("%s = %s", c.Target, op)
+		}
+	}
+	return fmt.Sprintf("case %s {\n%s\n}", op, indent(c.Body.String()))
+}
+
+// Select waits on several channel operations, e.g.
+// `select { case $v = recv($ch) { ... } default { ... } }`
+type Select struct {
+	pos        Position
+	span       Span
+	Cases      []*SelectCase
+	HasDefault bool
+	Default    Block
+}
+
+func (s *Select) statementNode()     {}
+func (s *Select) Position() Position { return s.pos }
+func (s *Select) Span() Span         { return s.span }
+
+func (s *Select) String() string {
+	cases := []string{}
+	for _, c := range s.Cases {
+		cases = append(cases, c.String())
+	}
+	if s.HasDefault {
+		cases = append(cases, fmt.Sprintf("default {\n%s\n}", indent(s.Default.String())))
+	}
+	return fmt.Sprintf("select {\n%s\n}", indent(strings.Join(cases, "\n")))
 }
 
 type Expression interface {
 	Position() Position
+	Span() Span
 	expressionNode()
 }
 
 type Binary struct {
 	pos      Position
+	span     Span
 	Left     Expression
 	Operator Token
 	Right    Expression
@@ -173,6 +280,7 @@ type Binary struct {
 
 func (e *Binary) expressionNode()    {}
 func (e *Binary) Position() Position { return e.pos }
+func (e *Binary) Span() Span         { return e.span }
 
 func (e *Binary) String() string {
 	return fmt.Sprintf("(%s %s %s)", e.Left, e.Operator, e.Right)
@@ -180,12 +288,14 @@ func (e *Binary) String() string {
 
 type Unary struct {
 	pos      Position
+	span     Span
 	Operator Token
 	Operand  Expression
 }
 
 func (e *Unary) expressionNode()    {}
 func (e *Unary) Position() Position { return e.pos }
+func (e *Unary) Span() Span         { return e.span }
 
 func (e *Unary) String() string {
 	space := ""
@@ -197,6 +307,7 @@ func (e *Unary) String() string {
 
 type Call struct {
 	pos       Position
+	span      Span
 	Function  Expression
 	Arguments []Expression
 	Ellipsis  bool
@@ -204,6 +315,7 @@ type Call struct {
 
 func (e *Call) expressionNode()    {}
 func (e *Call) Position() Position { return e.pos }
+func (e *Call) Span() Span         { return e.span }
 
 func (e *Call) String() string {
 	args := []string{}
@@ -219,11 +331,13 @@ func (e *Call) String() string {
 
 type Literal struct {
 	pos   Position
+	span  Span
 	Value interface{}
 }
 
 func (e *Literal) expressionNode()    {}
 func (e *Literal) Position() Position { return e.pos }
+func (e *Literal) Span() Span         { return e.span }
 
 func (e *Literal) String() string {
 	if e.Value == nil {
@@ -237,11 +351,13 @@ func (e *Literal) String() string {
 
 type List struct {
 	pos    Position
+	span   Span
 	Values []Expression
 }
 
 func (e *List) expressionNode()    {}
 func (e *List) Position() Position { return e.pos }
+func (e *List) Span() Span         { return e.span }
 
 func (e *List) String() string {
 	values := []string{}
@@ -258,11 +374,13 @@ type MapItem struct {
 
 type Map struct {
 	pos   Position
+	span  Span
 	Items []MapItem
 }
 
 func (e *Map) expressionNode()    {}
 func (e *Map) Position() Position { return e.pos }
+func (e *Map) Span() Span         { return e.span }
 
 func (e *Map) String() string {
 	items := []string{}
@@ -274,47 +392,54 @@ func (e *Map) String() string {
 
 type ClassDefinition struct {
 	pos       Position // The position in the source code where the class is defined
-	ClassName string   // The name of the class
-	Parent    *string  // Optional parent class (for inheritance)
+	span      Span
+	ClassName string  // The name of the class
+	Parent    *string // Optional parent class (for inheritance)
 	Body      []Statement
+	Slot      Slot // Where the class is stored
 }
 
 func (e *ClassDefinition) statementNode()     {}
 func (e *ClassDefinition) Position() Position { return e.pos }
+func (e *ClassDefinition) Span() Span         { return e.span }
 func (e *ClassDefinition) String() string {
 	bodyStr := ""
 	if len(e.Body) != 0 {
-		//bodyStr = "\n" + indent(e.Body.String()) + "\n"
+		bodyStr = "\n" + indent(Block(e.Body).String()) + "\n"
 	}
-	print("class %s {%s}", e.ClassName, bodyStr)
 	return fmt.Sprintf("class %s {%s}", e.ClassName, bodyStr)
 }
 
 type NewExpression struct {
 	pos       Position
+	span      Span
 	ClassName string
 	Arguments []string
 }
 
 func (e *NewExpression) expressionNode()    {}
 func (e *NewExpression) Position() Position { return e.pos }
+func (e *NewExpression) Span() Span         { return e.span }
 func (e *NewExpression) String() string {
 	return fmt.Sprintf("new %s(%s)", e.ClassName, strings.Join(e.Arguments, ", "))
 }
 
 // PropertyAccess represents accessing a property of an object, e.g., `$object->property`
 type PropertyAccess struct {
-	pos      Position   // Position of the `->` operator in the source code
+	pos      Position // Position of the `->` operator in the source code
+	span     Span
 	Object   Expression // The object whose property is being accessed
 	Property string     // The name of the property being accessed
 }
 
 func (e *PropertyAccess) expressionNode()    {}
 func (e *PropertyAccess) Position() Position { return e.pos }
+func (e *PropertyAccess) Span() Span         { return e.span }
 
 // MethodCall represents a method call on an object, e.g., `$object->method(arg1, arg2)`
 type MethodCall struct {
-	pos       Position     // Position of the `->` operator in the source code
+	pos       Position // Position of the `->` operator in the source code
+	span      Span
 	Object    Expression   // The object on which the method is being called
 	Method    string       // The name of the method being called
 	Arguments []Expression // Arguments passed to the method
@@ -322,16 +447,21 @@ type MethodCall struct {
 
 func (e *MethodCall) expressionNode()    {}
 func (e *MethodCall) Position() Position { return e.pos }
+func (e *MethodCall) Span() Span         { return e.span }
 
 type FunctionExpression struct {
 	pos        Position
+	span       Span
 	Parameters []string
 	Ellipsis   bool
 	Body       Block
+	Async      bool
+	Locals     []string // names of the function's local slots
 }
 
 func (e *FunctionExpression) expressionNode()    {}
 func (e *FunctionExpression) Position() Position { return e.pos }
+func (e *FunctionExpression) Span() Span         { return e.span }
 
 func (e *FunctionExpression) String() string {
 	ellipsisStr := ""
@@ -342,17 +472,38 @@ func (e *FunctionExpression) String() string {
 	if len(e.Body) != 0 {
 		bodyStr = "\n" + indent(e.Body.String()) + "\n"
 	}
-	return fmt.Sprintf("function(%s%s) {%s}", strings.Join(e.Parameters, ", "), ellipsisStr, bodyStr)
+	asyncStr := ""
+	if e.Async {
+		asyncStr = "async "
+	}
+	return fmt.Sprintf("%sfunction(%s%s) {%s}", asyncStr, strings.Join(e.Parameters, ", "), ellipsisStr, bodyStr)
+}
+
+// Await waits for a promise to settle, e.g. `await fileGetContentsAsync($url)`
+type Await struct {
+	pos   Position
+	span  Span
+	Value Expression
+}
+
+func (e *Await) expressionNode()    {}
+func (e *Await) Position() Position { return e.pos }
+func (e *Await) Span() Span         { return e.span }
+
+func (e *Await) String() string {
+	return fmt.Sprintf("(await %s)", e.Value)
 }
 
 type Subscript struct {
 	pos       Position
+	span      Span
 	Container Expression
 	Subscript Expression
 }
 
 func (e *Subscript) expressionNode()    {}
 func (e *Subscript) Position() Position { return e.pos }
+func (e *Subscript) Span() Span         { return e.span }
 
 func (e *Subscript) String() string {
 	return fmt.Sprintf("%s[%s]", e.Container, e.Subscript)
@@ -360,19 +511,24 @@ func (e *Subscript) String() string {
 
 type Variable struct {
 	pos  Position
+	span Span
 	Name string
+	Slot Slot // where the variable is stored
 }
 
 func (e *Variable) expressionNode()    {}
 func (e *Variable) Position() Position { return e.pos }
+func (e *Variable) Span() Span         { return e.span }
 
 func (e *Variable) String() string {
 	return e.Name
 }
 
 type SemiTag struct {
-	pos Position
+	pos  Position
+	span Span
 }
 
 func (e *SemiTag) expressionNode()    {}
 func (e *SemiTag) Position() Position { return e.pos }
+func (e *SemiTag) Span() Span         { return e.span }
@@ -0,0 +1,648 @@
+// DaVinci Script
+
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "github.com/DavinciScript/Davi/lexer"
+)
+
+// encodingVersion is bumped whenever the encoding of a Program changes.
+const encodingVersion = 2
+
+const encodingMagic = "davi-ast"
+
+// Node kinds in the encoding
+const (
+	kindNil byte = iota
+	kindAssign
+	kindOuterAssign
+	kindIf
+	kindWhile
+	kindFor
+	kindReturn
+	kindExpressionStatement
+	kindFunctionDefinition
+	kindSpawn
+	kindSelect
+	kindClassDefinition
+	kindBinary
+	kindUnary
+	kindCall
+	kindLiteral
+	kindList
+	kindMap
+	kindNewExpression
+	kindPropertyAccess
+	kindMethodCall
+	kindFunctionExpression
+	kindAwait
+	kindSubscript
+	kindVariable
+	kindSemiTag
+)
+
+// Literal value kinds in the encoding
+const (
+	literalNil byte = iota
+	literalFalse
+	literalTrue
+	literalInt
+	literalStr
+)
+
+// Encode returns a binary encoding of prog, including its positions and
+// the Slots and Locals set by Resolve, that Decode turns back into the
+// same Program. The file name isn't included in the positions; it's given
+// to Decode instead, so the encoding of a file doesn't depend on its name.
+// It's used to cache parsed programs, so the encoding can change between
+// versions of davi.
+func Encode(prog *Program) []byte {
+	e := &encoder{}
+	e.buf = append(e.buf, encodingMagic...)
+	e.int(encodingVersion)
+	e.block(prog.Statements)
+	return e.buf
+}
+
+// Decode returns the Program encoded in data by Encode, with file as the
+// file name of its positions. It returns an error if data isn't an
+// encoding made by this version of davi.
+func Decode(file string, data []byte) (prog *Program, err error) {
+	d := &decoder{data: data, file: file}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			prog = nil
+			err = e
+		}
+	}()
+	if len(data) < len(encodingMagic) || string(data[:len(encodingMagic)]) != encodingMagic {
+		return nil, errors.New("not an encoded program")
+	}
+	d.data = data[len(encodingMagic):]
+	if v := d.int(); v != encodingVersion {
+		return nil, fmt.Errorf("program encoding version %d, expected %d", v, encodingVersion)
+	}
+	prog = &Program{d.block()}
+	if len(d.data) != 0 {
+		d.error("%d bytes after program", len(d.data))
+	}
+	return prog, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *encoder) int(n int) {
+	e.buf = binary.AppendVarint(e.buf, int64(n))
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.byte(1)
+	} else {
+		e.byte(0)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.int(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+// length writes the length of a slice, keeping nil slices distinct from
+// empty ones.
+func (e *encoder) length(n int, isNil bool) {
+	if isNil {
+		e.int(0)
+	} else {
+		e.int(n + 1)
+	}
+}
+
+func (e *encoder) strings(s []string) {
+	e.length(len(s), s == nil)
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) pos(pos Position) {
+	e.int(pos.Line)
+	e.int(pos.Column)
+	e.int(pos.Offset)
+}
+
+func (e *encoder) span(s Span) {
+	e.pos(s.Start)
+	e.pos(s.End)
+}
+
+func (e *encoder) slot(s Slot) {
+	e.bool(s.Local)
+	e.int(s.Depth)
+	e.int(s.Index)
+}
+
+func (e *encoder) block(b Block) {
+	e.length(len(b), b == nil)
+	for _, s := range b {
+		e.node(s)
+	}
+}
+
+func (e *encoder) expressions(exprs []Expression) {
+	e.length(len(exprs), exprs == nil)
+	for _, expr := range exprs {
+		e.node(expr)
+	}
+}
+
+// node writes a statement or expression, which may be nil.
+func (e *encoder) node(n interface{}) {
+	switch n := n.(type) {
+	case nil:
+		e.byte(kindNil)
+	case *Assign:
+		e.byte(kindAssign)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Target)
+		e.node(n.Value)
+	case *OuterAssign:
+		e.byte(kindOuterAssign)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.Name)
+		e.node(n.Value)
+	case *If:
+		e.byte(kindIf)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Condition)
+		e.block(n.Body)
+		e.block(n.Else)
+	case *While:
+		e.byte(kindWhile)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Condition)
+		e.block(n.Body)
+	case *For:
+		e.byte(kindFor)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.Name)
+		e.node(n.Iterable)
+		e.block(n.Body)
+		e.slot(n.Slot)
+	case *Return:
+		e.byte(kindReturn)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Result)
+	case *ExpressionStatement:
+		e.byte(kindExpressionStatement)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Expression)
+	case *FunctionDefinition:
+		e.byte(kindFunctionDefinition)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.Name)
+		e.strings(n.Parameters)
+		e.bool(n.Ellipsis)
+		e.block(n.Body)
+		e.bool(n.Async)
+		e.slot(n.Slot)
+		e.strings(n.Locals)
+	case *Spawn:
+		e.byte(kindSpawn)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Call)
+	case *Select:
+		e.byte(kindSelect)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.length(len(n.Cases), n.Cases == nil)
+		for _, c := range n.Cases {
+			e.pos(c.pos)
+			e.span(c.span)
+			e.node(c.Target)
+			e.node(c.Channel)
+			e.node(c.Value)
+			e.block(c.Body)
+		}
+		e.bool(n.HasDefault)
+		e.block(n.Default)
+	case *ClassDefinition:
+		e.byte(kindClassDefinition)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.ClassName)
+		e.bool(n.Parent != nil)
+		if n.Parent != nil {
+			e.string(*n.Parent)
+		}
+		e.block(n.Body)
+		e.slot(n.Slot)
+	case *Binary:
+		e.byte(kindBinary)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Left)
+		e.int(int(n.Operator))
+		e.node(n.Right)
+	case *Unary:
+		e.byte(kindUnary)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.int(int(n.Operator))
+		e.node(n.Operand)
+	case *Call:
+		e.byte(kindCall)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Function)
+		e.expressions(n.Arguments)
+		e.bool(n.Ellipsis)
+	case *Literal:
+		e.byte(kindLiteral)
+		e.pos(n.pos)
+		e.span(n.span)
+		switch v := n.Value.(type) {
+		case nil:
+			e.byte(literalNil)
+		case bool:
+			if v {
+				e.byte(literalTrue)
+			} else {
+				e.byte(literalFalse)
+			}
+		case int:
+			e.byte(literalInt)
+			e.int(v)
+		case string:
+			e.byte(literalStr)
+			e.string(v)
+		default:
+			panic(fmt.Sprintf("can't encode literal of type %T", v))
+		}
+	case *List:
+		e.byte(kindList)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.expressions(n.Values)
+	case *Map:
+		e.byte(kindMap)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.length(len(n.Items), n.Items == nil)
+		for _, item := range n.Items {
+			e.node(item.Key)
+			e.node(item.Value)
+		}
+	case *NewExpression:
+		e.byte(kindNewExpression)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.ClassName)
+		e.strings(n.Arguments)
+	case *PropertyAccess:
+		e.byte(kindPropertyAccess)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Object)
+		e.string(n.Property)
+	case *MethodCall:
+		e.byte(kindMethodCall)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Object)
+		e.string(n.Method)
+		e.expressions(n.Arguments)
+	case *FunctionExpression:
+		e.byte(kindFunctionExpression)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.strings(n.Parameters)
+		e.bool(n.Ellipsis)
+		e.block(n.Body)
+		e.bool(n.Async)
+		e.strings(n.Locals)
+	case *Await:
+		e.byte(kindAwait)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Value)
+	case *Subscript:
+		e.byte(kindSubscript)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.node(n.Container)
+		e.node(n.Subscript)
+	case *Variable:
+		e.byte(kindVariable)
+		e.pos(n.pos)
+		e.span(n.span)
+		e.string(n.Name)
+		e.slot(n.Slot)
+	case *SemiTag:
+		e.byte(kindSemiTag)
+		e.pos(n.pos)
+		e.span(n.span)
+	default:
+		panic(fmt.Sprintf("can't encode node of type %T", n))
+	}
+}
+
+// decodeError is panicked by the decoder on malformed input, and returned
+// by Decode.
+type decodeError struct {
+	message string
+}
+
+func (e decodeError) Error() string {
+	return "invalid program encoding: " + e.message
+}
+
+type decoder struct {
+	data []byte
+	file string
+}
+
+func (d *decoder) error(format string, args ...interface{}) {
+	panic(decodeError{fmt.Sprintf(format, args...)})
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) == 0 {
+		d.error("unexpected end of data")
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) int() int {
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		d.error("bad integer")
+	}
+	d.data = d.data[size:]
+	return int(n)
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) string() string {
+	n := d.int()
+	if n < 0 || n > len(d.data) {
+		d.error("bad string length %d", n)
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+// length reads a slice length written by encoder.length, returning -1 for
+// a nil slice.
+func (d *decoder) length() int {
+	n := d.int() - 1
+	if n < -1 || n > len(d.data) {
+		d.error("bad length %d", n)
+	}
+	return n
+}
+
+func (d *decoder) strings() []string {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	s := make([]string, n)
+	for i := range s {
+		s[i] = d.string()
+	}
+	return s
+}
+
+func (d *decoder) pos() Position {
+	line := d.int()
+	column := d.int()
+	offset := d.int()
+	return Position{Line: line, Column: column, Offset: offset, File: d.file}
+}
+
+func (d *decoder) span() Span {
+	start := d.pos()
+	return Span{start, d.pos()}
+}
+
+func (d *decoder) slot() Slot {
+	local := d.bool()
+	depth := d.int()
+	index := d.int()
+	return Slot{local, depth, index}
+}
+
+func (d *decoder) block() Block {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	b := make(Block, n)
+	for i := range b {
+		b[i] = d.statement()
+	}
+	return b
+}
+
+func (d *decoder) expressions() []Expression {
+	n := d.length()
+	if n < 0 {
+		return nil
+	}
+	exprs := make([]Expression, n)
+	for i := range exprs {
+		exprs[i] = d.expression()
+	}
+	return exprs
+}
+
+func (d *decoder) statement() Statement {
+	s, ok := d.node().(Statement)
+	if !ok {
+		d.error("expected statement")
+	}
+	return s
+}
+
+// expression reads an expression, which may be nil.
+func (d *decoder) expression() Expression {
+	n := d.node()
+	if n == nil {
+		return nil
+	}
+	e, ok := n.(Expression)
+	if !ok {
+		d.error("expected expression")
+	}
+	return e
+}
+
+// node reads a statement or expression, returning nil for kindNil.
+func (d *decoder) node() interface{} {
+	kind := d.byte()
+	if kind == kindNil {
+		return nil
+	}
+	pos := d.pos()
+	span := d.span()
+	switch kind {
+	case kindAssign:
+		target := d.expression()
+		value := d.expression()
+		return &Assign{pos, span, target, value}
+	case kindOuterAssign:
+		name := d.string()
+		return &OuterAssign{pos, span, name, d.expression()}
+	case kindIf:
+		condition := d.expression()
+		body := d.block()
+		return &If{pos, span, condition, body, d.block()}
+	case kindWhile:
+		condition := d.expression()
+		return &While{pos, span, condition, d.block()}
+	case kindFor:
+		name := d.string()
+		iterable := d.expression()
+		body := d.block()
+		return &For{pos, span, name, iterable, body, d.slot()}
+	case kindReturn:
+		return &Return{pos, span, d.expression()}
+	case kindExpressionStatement:
+		return &ExpressionStatement{pos, span, d.expression()}
+	case kindFunctionDefinition:
+		name := d.string()
+		params := d.strings()
+		ellipsis := d.bool()
+		body := d.block()
+		async := d.bool()
+		slot := d.slot()
+		return &FunctionDefinition{pos, span, name, params, ellipsis, body, async, slot, d.strings()}
+	case kindSpawn:
+		call, ok := d.expression().(*Call)
+		if !ok {
+			d.error("expected call in spawn")
+		}
+		return &Spawn{pos, span, call}
+	case kindSelect:
+		var cases []*SelectCase
+		if n := d.length(); n >= 0 {
+			cases = make([]*SelectCase, n)
+			for i := range cases {
+				casePos := d.pos()
+				caseSpan := d.span()
+				target := d.expression()
+				channel := d.expression()
+				value := d.expression()
+				cases[i] = &SelectCase{casePos, caseSpan, target, channel, value, d.block()}
+			}
+		}
+		hasDefault := d.bool()
+		return &Select{pos, span, cases, hasDefault, d.block()}
+	case kindClassDefinition:
+		name := d.string()
+		var parent *string
+		if d.bool() {
+			s := d.string()
+			parent = &s
+		}
+		body := d.block()
+		return &ClassDefinition{pos, span, name, parent, body, d.slot()}
+	case kindBinary:
+		left := d.expression()
+		operator := Token(d.int())
+		return &Binary{pos, span, left, operator, d.expression()}
+	case kindUnary:
+		operator := Token(d.int())
+		return &Unary{pos, span, operator, d.expression()}
+	case kindCall:
+		function := d.expression()
+		args := d.expressions()
+		return &Call{pos, span, function, args, d.bool()}
+	case kindLiteral:
+		var value interface{}
+		switch kind := d.byte(); kind {
+		case literalNil:
+		case literalFalse:
+			value = false
+		case literalTrue:
+			value = true
+		case literalInt:
+			value = d.int()
+		case literalStr:
+			value = d.string()
+		default:
+			d.error("bad literal kind %d", kind)
+		}
+		return &Literal{pos, span, value}
+	case kindList:
+		return &List{pos, span, d.expressions()}
+	case kindMap:
+		var items []MapItem
+		if n := d.length(); n >= 0 {
+			items = make([]MapItem, n)
+			for i := range items {
+				key := d.expression()
+				items[i] = MapItem{key, d.expression()}
+			}
+		}
+		return &Map{pos, span, items}
+	case kindNewExpression:
+		name := d.string()
+		return &NewExpression{pos, span, name, d.strings()}
+	case kindPropertyAccess:
+		object := d.expression()
+		return &PropertyAccess{pos, span, object, d.string()}
+	case kindMethodCall:
+		object := d.expression()
+		method := d.string()
+		return &MethodCall{pos, span, object, method, d.expressions()}
+	case kindFunctionExpression:
+		params := d.strings()
+		ellipsis := d.bool()
+		body := d.block()
+		async := d.bool()
+		return &FunctionExpression{pos, span, params, ellipsis, body, async, d.strings()}
+	case kindAwait:
+		return &Await{pos, span, d.expression()}
+	case kindSubscript:
+		container := d.expression()
+		return &Subscript{pos, span, container, d.expression()}
+	case kindVariable:
+		name := d.string()
+		return &Variable{pos, span, name, d.slot()}
+	case kindSemiTag:
+		return &SemiTag{pos, span}
+	default:
+		d.error("bad node kind %d", kind)
+		return nil
+	}
+}
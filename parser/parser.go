@@ -3,9 +3,9 @@
 package parser
 
 import (
+	"bytes"
 	"fmt"
 	. "github.com/DavinciScript/Davi/lexer"
-	"github.com/hokaccha/go-prettyjson"
 	"strconv"
 )
 
@@ -14,22 +14,27 @@ import (
 // and column) of where the error occurred, as well as the error message.
 type Error struct {
 	Position Position
+	End      Position // just after the offending token or expression
 	Message  string
 }
 
 func (e Error) Error() string {
-	return fmt.Sprintf("parse error at %d:%d: %s", e.Position.Line, e.Position.Column, e.Message)
+	return fmt.Sprintf("parse error at %s: %s", e.Position, e.Message)
 }
 
 type parser struct {
 	lexer *Lexer
 	pos   Position
+	end   Position // end of the current token
+	last  Position // end of the token before it
 	tok   Token
 	val   string
 }
 
 func (p *parser) next() {
-	p.pos, p.tok, p.val, _ = p.lexer.Next()
+	p.last = p.end
+	p.pos, p.tok, p.val = p.lexer.Next()
+	p.end = p.lexer.End()
 	if p.tok == ILLEGAL {
 		p.error("%s", p.val)
 	}
@@ -37,7 +42,12 @@ func (p *parser) next() {
 
 func (p *parser) error(format string, args ...interface{}) {
 	message := fmt.Sprintf(format, args...)
-	panic(Error{p.pos, message})
+	panic(Error{p.pos, p.end, message})
+}
+
+// span returns the Span from start to the end of the last token parsed.
+func (p *parser) span(start Position) Span {
+	return Span{start, p.last}
 }
 
 func (p *parser) expect(tok Token, context ...string) {
@@ -70,7 +80,10 @@ func (p *parser) statements(end Token) Block {
 	return statements
 }
 
-// statement = if | while | for | return | function | assign | expression
+// statement = if | while | for | return | function | async | class |
+//
+//	spawn | select | assign | expression
+//
 // assign    = NAME ASSIGN expression |
 //
 //	call subscript ASSIGN expression |
@@ -87,10 +100,17 @@ func (p *parser) statement() Statement {
 		return p.return_()
 	case FUNCTION:
 		return p.function_()
+	case ASYNC:
+		return p.async()
 	case CLASS:
 		return p.class_()
+	case SPAWN:
+		return p.spawn()
+	case SELECT:
+		return p.select_()
 	}
 	pos := p.pos
+	start := p.pos
 	expr := p.expression()
 	if p.tok == OBJECT_OPERATOR {
 		pos = p.pos
@@ -127,10 +147,10 @@ func (p *parser) statement() Statement {
 			}
 			p.expect(RPAREN, "object_operator")
 
-			expr = &MethodCall{pos, expr, methodName, args}
+			expr = &MethodCall{pos, p.span(start), expr, methodName, args}
 		}
 
-		return &ExpressionStatement{pos, expr}
+		return &ExpressionStatement{pos, p.span(start), expr}
 
 	}
 	if p.tok == ASSIGN {
@@ -139,12 +159,12 @@ func (p *parser) statement() Statement {
 		case *Variable, *Subscript:
 			p.next()
 			value := p.expression()
-			return &Assign{pos, expr, value}
+			return &Assign{pos, p.span(start), expr, value}
 		default:
 			p.error("expected name, subscript, or dot expression on left side of =")
 		}
 	}
-	return &ExpressionStatement{pos, expr}
+	return &ExpressionStatement{pos, p.span(start), expr}
 }
 
 // block = LBRACE statement* RBRACE
@@ -175,7 +195,7 @@ func (p *parser) if_() Statement {
 			p.error("expected { or if after else, not %s", p.tok)
 		}
 	}
-	return &If{pos, condition, body, elseBody}
+	return &If{pos, p.span(pos), condition, body, elseBody}
 }
 
 // while = WHILE expression block
@@ -184,7 +204,7 @@ func (p *parser) while() Statement {
 	p.expect(WHILE, "while")
 	condition := p.expression()
 	body := p.block()
-	return &While{pos, condition, body}
+	return &While{pos, p.span(pos), condition, body}
 }
 
 // for = FOR NAME IN expression block
@@ -199,7 +219,7 @@ func (p *parser) for_() Statement {
 	iterable := p.expression()
 	p.expect(RPAREN, "for_")
 	body := p.block()
-	return &For{pos, name, iterable, body}
+	return &For{pos, p.span(pos), name, iterable, body, Slot{}}
 }
 
 // return = RETURN expression
@@ -207,12 +227,79 @@ func (p *parser) return_() Statement {
 	pos := p.pos
 	p.expect(RETURN, "return_")
 	result := p.expression()
-	return &Return{pos, result}
+	return &Return{pos, p.span(pos), result}
+}
+
+// spawn = SPAWN call
+func (p *parser) spawn() Statement {
+	pos := p.pos
+	p.expect(SPAWN, "spawn")
+	expr := p.expression()
+	call, ok := expr.(*Call)
+	if !ok {
+		panic(Error{expr.Position(), expr.Span().End, "spawn requires a function call"})
+	}
+	return &Spawn{pos, p.span(pos), call}
+}
+
+// select     = SELECT LBRACE selectCase* (DEFAULT block)? RBRACE
+// selectCase = CASE (variable ASSIGN)? call block
+func (p *parser) select_() Statement {
+	pos := p.pos
+	p.expect(SELECT, "select_")
+	p.expect(LBRACE, "select_")
+	cases := []*SelectCase{}
+	hasDefault := false
+	var defaultBody Block
+	for p.tok != RBRACE && p.tok != EOF {
+		if p.tok == DEFAULT {
+			if hasDefault {
+				p.error("select can only have one default case")
+			}
+			p.next()
+			hasDefault = true
+			defaultBody = p.block()
+			continue
+		}
+		cases = append(cases, p.selectCase())
+	}
+	p.expect(RBRACE, "select_")
+	return &Select{pos, p.span(pos), cases, hasDefault, defaultBody}
+}
+
+func (p *parser) selectCase() *SelectCase {
+	pos := p.pos
+	p.expect(CASE, "selectCase")
+	var target Expression
+	expr := p.expression()
+	if p.tok == ASSIGN {
+		if _, ok := expr.(*Variable); !ok {
+			p.error("expected variable on left side of = in select case")
+		}
+		p.next()
+		target = expr
+		expr = p.expression()
+	}
+	call, ok := expr.(*Call)
+	if ok {
+		if name, isVar := call.Function.(*Variable); isVar {
+			switch {
+			case name.Name == "recv" && len(call.Arguments) == 1 && !call.Ellipsis:
+				body := p.block()
+				return &SelectCase{pos, p.span(pos), target, call.Arguments[0], nil, body}
+			case name.Name == "send" && len(call.Arguments) == 2 && !call.Ellipsis && target == nil:
+				body := p.block()
+				return &SelectCase{pos, p.span(pos), nil, call.Arguments[0], call.Arguments[1], body}
+			}
+		}
+	}
+	panic(Error{expr.Position(), expr.Span().End, "select case must be recv($channel) or send($channel, $value)"})
 }
 
 // class = CLASS NAME block
 func (p *parser) class_() Statement {
 
+	start := p.pos
 	p.next()
 	pos := p.pos
 	name := p.val
@@ -229,7 +316,7 @@ func (p *parser) class_() Statement {
 
 	p.expect(RBRACE, "class_")
 
-	return &ClassDefinition{pos, name, nil, body}
+	return &ClassDefinition{pos, p.span(start), name, nil, body, Slot{}}
 }
 
 // function = FUNCTION NAME params block |
@@ -243,13 +330,37 @@ func (p *parser) function_() Statement {
 		p.next()
 		params, ellipsis := p.params()
 		body := p.block()
-		return &FunctionDefinition{pos, name, params, ellipsis, body}
+		return &FunctionDefinition{pos, p.span(pos), name, params, ellipsis, body, false, Slot{}, nil}
 	} else {
 		params, ellipsis := p.params()
 		body := p.block()
-		expr := &FunctionExpression{pos, params, ellipsis, body}
-		return &ExpressionStatement{pos, expr}
+		expr := &FunctionExpression{pos, p.span(pos), params, ellipsis, body, false, nil}
+		return &ExpressionStatement{pos, p.span(pos), expr}
+	}
+}
+
+// async = ASYNC function
+func (p *parser) async() Statement {
+	pos := p.pos
+	p.expect(ASYNC, "async")
+	if p.tok != FUNCTION {
+		p.error("expected function after async, not %s", p.tok)
+	}
+	s := p.function_()
+	switch s := s.(type) {
+	case *FunctionDefinition:
+		s.pos = pos
+		s.span.Start = pos
+		s.Async = true
+	case *ExpressionStatement:
+		s.pos = pos
+		s.span.Start = pos
+		e := s.Expression.(*FunctionExpression)
+		e.pos = pos
+		e.span.Start = pos
+		e.Async = true
 	}
+	return s
 }
 
 // params = LPAREN RPAREN |
@@ -287,13 +398,14 @@ func (p *parser) params() ([]string, bool) {
 }
 
 func (p *parser) binary(parseFunc func() Expression, operators ...Token) Expression {
+	start := p.pos
 	expr := parseFunc()
 	for p.matches(operators...) {
 		op := p.tok
 		pos := p.pos
 		p.next()
 		right := parseFunc()
-		expr = &Binary{pos, expr, op, right}
+		expr = &Binary{pos, p.span(start), expr, op, right}
 	}
 	return expr
 }
@@ -314,7 +426,7 @@ func (p *parser) not() Expression {
 		pos := p.pos
 		p.next()
 		operand := p.not()
-		return &Unary{pos, NOT, operand}
+		return &Unary{pos, p.span(pos), NOT, operand}
 	}
 	return p.equality()
 }
@@ -339,13 +451,19 @@ func (p *parser) multiply() Expression {
 	return p.binary(p.negative, TIMES, DIVIDE, MODULO)
 }
 
-// negative = MINUS negative | call
+// negative = MINUS negative | AWAIT negative | call
 func (p *parser) negative() Expression {
 	if p.tok == MINUS {
 		pos := p.pos
 		p.next()
 		operand := p.negative()
-		return &Unary{pos, MINUS, operand}
+		return &Unary{pos, p.span(pos), MINUS, operand}
+	}
+	if p.tok == AWAIT {
+		pos := p.pos
+		p.next()
+		value := p.negative()
+		return &Await{pos, p.span(pos), value}
 	}
 	return p.call()
 }
@@ -358,6 +476,7 @@ func (p *parser) negative() Expression {
 // subscript = LBRACKET expression RBRACKET
 // dot       = DOT NAME
 func (p *parser) call() Expression {
+	start := p.pos
 	expr := p.primary()
 	for p.matches(LPAREN, LBRACKET, DOT) {
 		if p.tok == LPAREN {
@@ -388,18 +507,18 @@ func (p *parser) call() Expression {
 			}
 			p.expect(RPAREN, "call")
 
-			expr = &Call{pos, expr, args, gotEllipsis}
+			expr = &Call{pos, p.span(start), expr, args, gotEllipsis}
 		} else if p.tok == LBRACKET {
 			pos := p.pos
 			p.next()
 			subscript := p.expression()
 			p.expect(RBRACKET, "call")
 			//p.expect(SEMI)
-			expr = &Subscript{pos, expr, subscript}
+			expr = &Subscript{pos, p.span(start), expr, subscript}
 		} else {
 			pos := p.pos
 			p.next()
-			subscript := &Literal{p.pos, p.val}
+			subscript := &Literal{p.pos, Span{p.pos, p.end}, p.val}
 			if p.tok == STR {
 				p.next()
 				if p.tok == DOT {
@@ -408,7 +527,7 @@ func (p *parser) call() Expression {
 				p.primary()
 			} else {
 				p.expect(NAME, "call-name")
-				expr = &Subscript{pos, expr, subscript}
+				expr = &Subscript{pos, p.span(start), expr, subscript}
 			}
 		}
 	}
@@ -418,6 +537,7 @@ func (p *parser) call() Expression {
 // primary = NAME | INT | STR | TRUE | FALSE | NIL | list | map |
 //
 //	FUNCTION params block |
+//	ASYNC FUNCTION params block |
 //	LPAREN expression RPAREN
 func (p *parser) primary() Expression {
 	switch p.tok {
@@ -433,13 +553,14 @@ func (p *parser) primary() Expression {
 		//	return &Call{pos, function, args, false}
 		//}
 
-		return &Variable{pos, name}
+		return &Variable{pos, p.span(pos), name, Slot{}}
 	case DOLLAR:
+		start := p.pos
 		p.expect(DOLLAR, "primary")
 		name := p.val
 		pos := p.pos
 		p.next()
-		return &Variable{pos, name}
+		return &Variable{pos, p.span(start), name, Slot{}}
 	case INT:
 		val := p.val
 		pos := p.pos
@@ -449,24 +570,24 @@ func (p *parser) primary() Expression {
 			// Tokenizer should never give us this
 			panic(fmt.Sprintf("tokenizer gave INT token that isn't an int: %s", val))
 		}
-		return &Literal{pos, n}
+		return &Literal{pos, p.span(pos), n}
 	case STR:
 		val := p.val
 		pos := p.pos
 		p.next()
-		return &Literal{pos, val}
+		return &Literal{pos, p.span(pos), val}
 	case TRUE:
 		pos := p.pos
 		p.next()
-		return &Literal{pos, true}
+		return &Literal{pos, p.span(pos), true}
 	case FALSE:
 		pos := p.pos
 		p.next()
-		return &Literal{pos, false}
+		return &Literal{pos, p.span(pos), false}
 	case NIL:
 		pos := p.pos
 		p.next()
-		return &Literal{pos, nil}
+		return &Literal{pos, p.span(pos), nil}
 	case LBRACKET:
 		return p.list()
 	case LBRACE:
@@ -476,7 +597,14 @@ func (p *parser) primary() Expression {
 		p.next()
 		args, ellipsis := p.params()
 		body := p.block()
-		return &FunctionExpression{pos, args, ellipsis, body}
+		return &FunctionExpression{pos, p.span(pos), args, ellipsis, body, false, nil}
+	case ASYNC:
+		pos := p.pos
+		p.next()
+		p.expect(FUNCTION, "async")
+		args, ellipsis := p.params()
+		body := p.block()
+		return &FunctionExpression{pos, p.span(pos), args, ellipsis, body, true, nil}
 	case LPAREN:
 		p.next()
 		expr := p.expression()
@@ -484,9 +612,10 @@ func (p *parser) primary() Expression {
 		//p.expect(SEMI)
 		return expr
 	case SEMI:
+		start := p.pos
 		p.next()
 		pos := p.pos
-		return &SemiTag{pos}
+		return &SemiTag{pos, p.span(start)}
 	case NEW:
 		pos := p.pos
 		p.expect(NEW, "new") // Move past the 'NEW' token
@@ -500,7 +629,7 @@ func (p *parser) primary() Expression {
 		p.expect(NAME, "new")
 		args, _ := p.params()
 
-		return &NewExpression{pos, className, args}
+		return &NewExpression{pos, p.span(pos), className, args}
 	//case OBJECT_OPERATOR:
 	//	pos := p.pos
 	//	p.next() // Move past the OBJECT_OPERATOR token
@@ -524,10 +653,7 @@ func (p *parser) primary() Expression {
 	//	return &PropertyAccess{pos, nil, methodName}
 
 	default:
-		formatter := prettyjson.NewFormatter()
-		output, _ := formatter.Marshal(p.val)
-		fmt.Println(string(output))
-		p.error("expected expression, ___%s___ ", p.tok)
+		p.error("expected expression, not %s", p.tok)
 		return nil
 	}
 }
@@ -555,7 +681,7 @@ func (p *parser) list() Expression {
 	}
 	p.expect(RBRACKET, "list")
 	//p.expect(SEMI)
-	return &List{pos, values}
+	return &List{pos, p.span(pos), values}
 }
 
 // map = LBRACE RBRACE |
@@ -583,7 +709,7 @@ func (p *parser) map_() Expression {
 		}
 	}
 	p.expect(RBRACE, "map_")
-	return &Map{pos, items}
+	return &Map{pos, p.span(pos), items}
 }
 
 // ParseExpression parses a single expression into an Expression interface
@@ -600,7 +726,9 @@ func ParseExpression(input []byte) (e Expression, err error) {
 	l := NewLexer(input)
 	p := parser{lexer: l}
 	p.next()
-	return p.expression(), nil
+	e = p.expression()
+	ResolveExpression(e)
+	return e, nil
 }
 
 // ParseProgram parses an entire program and returns a *Program (which is
@@ -608,6 +736,13 @@ func ParseExpression(input []byte) (e Expression, err error) {
 // a *Program and nil. If there's a syntax error, return nil and a
 // parser.Error value.
 func ParseProgram(input []byte) (prog *Program, err error) {
+	return ParseFile("", input)
+}
+
+// ParseFile parses a program like ParseProgram, recording the name of the
+// file it came from in the positions of its nodes and errors, so that
+// errors in programs made of several files say which file they're in.
+func ParseFile(file string, input []byte) (prog *Program, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			// Convert to parser.Error or re-panic
@@ -615,7 +750,28 @@ func ParseProgram(input []byte) (prog *Program, err error) {
 		}
 	}()
 	l := NewLexer(input)
+	l.SetFile(file)
 	p := parser{lexer: l}
 	p.next()
-	return p.program(), nil
+	prog = p.program()
+	Resolve(prog)
+	return prog, nil
+}
+
+// StripTags returns a copy of src with the <?davi tag at the start and the
+// ?> tag at the end of a script replaced by spaces, so that positions in
+// the result are the same as in src.
+func StripTags(src []byte) []byte {
+	code := append([]byte{}, src...)
+	trimmed := bytes.TrimSpace(code)
+	if bytes.HasPrefix(trimmed, []byte("<?davi")) {
+		i := bytes.Index(code, []byte("<?davi"))
+		copy(code[i:], "      ")
+		trimmed = bytes.TrimSpace(code)
+	}
+	if bytes.HasSuffix(trimmed, []byte("?>")) {
+		i := bytes.LastIndex(code, []byte("?>"))
+		copy(code[i:], "  ")
+	}
+	return code
 }
@@ -0,0 +1,217 @@
+// DaVinci Script
+
+package parser
+
+// Slot says where a variable is stored, as worked out by Resolve. Variables
+// assigned at the top level of a program are globals, looked up by name.
+// Variables assigned in a function (including its parameters) are local to
+// it and stored in a slot of its call's frame.
+type Slot struct {
+	Local bool // false for a global
+	Depth int  // how many functions out from the reference the variable is
+	Index int  // index of the variable in that function's Locals
+}
+
+// function is the scope of a function being resolved.
+type function struct {
+	locals []string
+	slots  map[string]int
+}
+
+type resolver struct {
+	functions []*function // innermost last, empty at the top level
+}
+
+// Resolve annotates the variables of prog with their Slot, and functions
+// with their Locals. ParseProgram calls it, so it's only needed for
+// programs built or changed by hand.
+func Resolve(prog *Program) {
+	r := &resolver{}
+	r.block(prog.Statements)
+}
+
+// ResolveExpression annotates the variables of e like Resolve, as if e were
+// in a function nested in functions with the given Locals (innermost
+// first). With no locals e is resolved as if it were at the top level.
+func ResolveExpression(e Expression, locals ...[]string) {
+	r := &resolver{}
+	for i := len(locals) - 1; i >= 0; i-- {
+		f := &function{locals: locals[i], slots: map[string]int{}}
+		for index, name := range locals[i] {
+			f.slots[name] = index
+		}
+		r.functions = append(r.functions, f)
+	}
+	r.expression(e)
+}
+
+// declare gives a local variable a slot, if it doesn't have one.
+func (f *function) declare(name string) {
+	if _, ok := f.slots[name]; !ok {
+		f.slots[name] = len(f.locals)
+		f.locals = append(f.locals, name)
+	}
+}
+
+// declareBlock declares the variables a block assigns to, which are local
+// to the function (but not the nested functions) the block is in.
+func (f *function) declareBlock(block Block) {
+	for _, s := range block {
+		switch s := s.(type) {
+		case *Assign:
+			if v, ok := s.Target.(*Variable); ok {
+				f.declare(v.Name)
+			}
+		case *If:
+			f.declareBlock(s.Body)
+			f.declareBlock(s.Else)
+		case *While:
+			f.declareBlock(s.Body)
+		case *For:
+			f.declare(s.Name)
+			f.declareBlock(s.Body)
+		case *FunctionDefinition:
+			f.declare(s.Name)
+		case *ClassDefinition:
+			f.declare(s.ClassName)
+		case *Select:
+			for _, c := range s.Cases {
+				if v, ok := c.Target.(*Variable); ok {
+					f.declare(v.Name)
+				}
+				f.declareBlock(c.Body)
+			}
+			f.declareBlock(s.Default)
+		}
+	}
+}
+
+// function resolves the body of a function and returns its Locals. The
+// parameters come first, one slot each, so a call can copy its arguments
+// straight into the frame.
+func (r *resolver) function(parameters []string, body Block) []string {
+	f := &function{slots: map[string]int{}}
+	for _, p := range parameters {
+		// A repeated parameter gets its own slot, but the name refers to
+		// the last one, as the last argument wins
+		f.slots[p] = len(f.locals)
+		f.locals = append(f.locals, p)
+	}
+	f.declareBlock(body)
+	r.functions = append(r.functions, f)
+	r.block(body)
+	r.functions = r.functions[:len(r.functions)-1]
+	return f.locals
+}
+
+// slot returns where name is stored.
+func (r *resolver) slot(name string) Slot {
+	for depth := 0; depth < len(r.functions); depth++ {
+		f := r.functions[len(r.functions)-1-depth]
+		if index, ok := f.slots[name]; ok {
+			return Slot{true, depth, index}
+		}
+	}
+	return Slot{}
+}
+
+func (r *resolver) block(block Block) {
+	for _, s := range block {
+		r.statement(s)
+	}
+}
+
+func (r *resolver) statement(s Statement) {
+	switch s := s.(type) {
+	case *Assign:
+		r.expression(s.Target)
+		r.expression(s.Value)
+	case *OuterAssign:
+		r.expression(s.Value)
+	case *If:
+		r.expression(s.Condition)
+		r.block(s.Body)
+		r.block(s.Else)
+	case *While:
+		r.expression(s.Condition)
+		r.block(s.Body)
+	case *For:
+		s.Slot = r.slot(s.Name)
+		r.expression(s.Iterable)
+		r.block(s.Body)
+	case *Return:
+		r.expression(s.Result)
+	case *ExpressionStatement:
+		r.expression(s.Expression)
+	case *FunctionDefinition:
+		s.Slot = r.slot(s.Name)
+		s.Locals = r.function(s.Parameters, s.Body)
+	case *Spawn:
+		r.expression(s.Call)
+	case *Select:
+		for _, c := range s.Cases {
+			r.expression(c.Channel)
+			if c.Value != nil {
+				r.expression(c.Value)
+			}
+			if c.Target != nil {
+				r.expression(c.Target)
+			}
+			r.block(c.Body)
+		}
+		r.block(s.Default)
+	case *ClassDefinition:
+		s.Slot = r.slot(s.ClassName)
+		for _, stmt := range s.Body {
+			switch stmt := stmt.(type) {
+			case *FunctionDefinition:
+				stmt.Locals = r.function(stmt.Parameters, stmt.Body)
+			case *Assign:
+				// The target is a field, not a variable
+				r.expression(stmt.Value)
+			default:
+				r.statement(stmt)
+			}
+		}
+	}
+}
+
+func (r *resolver) expression(e Expression) {
+	switch e := e.(type) {
+	case *Variable:
+		e.Slot = r.slot(e.Name)
+	case *Binary:
+		r.expression(e.Left)
+		r.expression(e.Right)
+	case *Unary:
+		r.expression(e.Operand)
+	case *Call:
+		r.expression(e.Function)
+		for _, arg := range e.Arguments {
+			r.expression(arg)
+		}
+	case *List:
+		for _, v := range e.Values {
+			r.expression(v)
+		}
+	case *Map:
+		for _, item := range e.Items {
+			r.expression(item.Key)
+			r.expression(item.Value)
+		}
+	case *Subscript:
+		r.expression(e.Container)
+		r.expression(e.Subscript)
+	case *FunctionExpression:
+		e.Locals = r.function(e.Parameters, e.Body)
+	case *Await:
+		r.expression(e.Value)
+	case *MethodCall:
+		r.expression(e.Object)
+		for _, arg := range e.Arguments {
+			r.expression(arg)
+		}
+	case *PropertyAccess:
+		r.expression(e.Object)
+	}
+}
@@ -0,0 +1,57 @@
+// DaVinci Script
+
+package main
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/profile"
+	"os"
+	"time"
+)
+
+// startProfile sets config to profile the script, and returns a function
+// that writes the profile to the file given by --profile and prints the
+// summary to stderr. The profile is also written if the script calls
+// exit().
+func startProfile(config *interpreter.Config, options *runOptions) func() {
+	profiler := interpreter.NewProfiler()
+	config.Profiler = profiler
+	start := time.Now()
+	done := false
+	finish := func() {
+		if done {
+			return
+		}
+		done = true
+		duration := time.Since(start)
+		samples := profiler.Samples()
+		err := writeProfile(options.profile, samples, start, duration)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing profile %s: %s\n", options.profile, err)
+		}
+		fmt.Fprintln(os.Stderr)
+		profile.WriteTop(os.Stderr, samples, options.profileTop)
+	}
+	exit := config.Exit
+	if exit == nil {
+		exit = os.Exit
+	}
+	config.Exit = func(code int) {
+		finish()
+		exit(code)
+	}
+	return finish
+}
+
+func writeProfile(name string, samples []interpreter.ProfileSample, start time.Time, duration time.Duration) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = profile.WritePprof(f, samples, start, duration)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
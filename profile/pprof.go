@@ -0,0 +1,189 @@
+// DaVinci Script
+
+package profile
+
+import (
+	"compress/gzip"
+	"github.com/DavinciScript/Davi/interpreter"
+	"io"
+	"time"
+)
+
+// Field numbers of the messages in pprof's profile.proto
+const (
+	profileSampleType        = 1
+	profileSample            = 2
+	profileLocation          = 4
+	profileFunction          = 5
+	profileStringTable       = 6
+	profileTimeNanos         = 9
+	profileDurationNanos     = 10
+	profilePeriodType        = 11
+	profilePeriod            = 12
+	profileDefaultSampleType = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID        = 1
+	functionNameField = 2
+	functionFilename  = 4
+)
+
+// protoBuffer encodes protocol buffer messages.
+type protoBuffer []byte
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+// int writes an int field, which is left out if it's zero.
+func (b *protoBuffer) int(field int, x int64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(uint64(x))
+}
+
+// bytes writes a length-delimited field.
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+// packed writes a packed repeated int field.
+func (b *protoBuffer) packed(field int, xs []int64) {
+	var p protoBuffer
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytes(field, p)
+}
+
+// message writes a field holding the message encoded by f.
+func (b *protoBuffer) message(field int, f func(m *protoBuffer)) {
+	var m protoBuffer
+	f(&m)
+	b.bytes(field, m)
+}
+
+// pprofWriter builds a profile, numbering its strings, functions and
+// locations as they're first used.
+type pprofWriter struct {
+	buf       protoBuffer
+	strings   map[string]int64
+	functions map[interpreter.ProfileFrame]int64 // by function and file
+	locations map[interpreter.ProfileFrame]int64 // by function, file and line
+}
+
+func (p *pprofWriter) string(s string) int64 {
+	id, ok := p.strings[s]
+	if !ok {
+		id = int64(len(p.strings))
+		p.strings[s] = id
+	}
+	return id
+}
+
+func (p *pprofWriter) function(frame interpreter.ProfileFrame) int64 {
+	key := interpreter.ProfileFrame{Function: frame.Function}
+	key.Position.File = frame.Position.File
+	id, ok := p.functions[key]
+	if !ok {
+		id = int64(len(p.functions) + 1)
+		p.functions[key] = id
+		p.buf.message(profileFunction, func(m *protoBuffer) {
+			m.int(functionID, id)
+			// No system name, or pprof takes names like <anonymous> for
+			// C++ templates and strips them
+			m.int(functionNameField, p.string(frame.Function))
+			m.int(functionFilename, p.string(frame.Position.File))
+		})
+	}
+	return id
+}
+
+func (p *pprofWriter) location(frame interpreter.ProfileFrame) int64 {
+	key := interpreter.ProfileFrame{Function: frame.Function}
+	key.Position.File = frame.Position.File
+	key.Position.Line = frame.Position.Line
+	id, ok := p.locations[key]
+	if !ok {
+		function := p.function(frame)
+		id = int64(len(p.locations) + 1)
+		p.locations[key] = id
+		p.buf.message(profileLocation, func(m *protoBuffer) {
+			m.int(locationID, id)
+			m.message(locationLine, func(l *protoBuffer) {
+				l.int(lineFunctionID, function)
+				l.int(lineLine, int64(key.Position.Line))
+			})
+		})
+	}
+	return id
+}
+
+// WritePprof writes samples to w as a gzipped profile in the pprof format,
+// with two sample types: "ops" (a count) and "time" (in nanoseconds, the
+// default). Each frame in a sample's stack is a location in the profile.
+// start and duration are when profiling started and how long it ran.
+func WritePprof(w io.Writer, samples []interpreter.ProfileSample, start time.Time, duration time.Duration) error {
+	p := &pprofWriter{
+		strings:   map[string]int64{"": 0},
+		functions: make(map[interpreter.ProfileFrame]int64),
+		locations: make(map[interpreter.ProfileFrame]int64),
+	}
+	valueType := func(field int, typ, unit string) {
+		p.buf.message(field, func(m *protoBuffer) {
+			m.int(valueTypeType, p.string(typ))
+			m.int(valueTypeUnit, p.string(unit))
+		})
+	}
+	valueType(profileSampleType, "ops", "count")
+	valueType(profileSampleType, "time", "nanoseconds")
+	for _, s := range samples {
+		ids := make([]int64, len(s.Stack))
+		for i, frame := range s.Stack {
+			ids[i] = p.location(frame)
+		}
+		p.buf.message(profileSample, func(m *protoBuffer) {
+			m.packed(sampleLocationID, ids)
+			m.packed(sampleValue, []int64{int64(s.Ops), int64(s.Duration)})
+		})
+	}
+	p.buf.int(profileTimeNanos, start.UnixNano())
+	p.buf.int(profileDurationNanos, int64(duration))
+	valueType(profilePeriodType, "time", "nanoseconds")
+	p.buf.int(profilePeriod, 1)
+	p.buf.int(profileDefaultSampleType, p.string("time"))
+
+	// The string table goes last, once every string is numbered
+	table := make([]string, len(p.strings))
+	for s, id := range p.strings {
+		table[id] = s
+	}
+	for _, s := range table {
+		p.buf.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.buf); err != nil {
+		return err
+	}
+	return zw.Close()
+}
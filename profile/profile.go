@@ -0,0 +1,120 @@
+// DaVinci Script
+
+// Package profile writes the samples recorded by an interpreter.Profiler
+// as reports: a table of the functions and lines that took the most time,
+// and a profile in the pprof format, for "go tool pprof" and other tools
+// that read it.
+package profile
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// cost is the time and ops spent in a function or line.
+type cost struct {
+	name      string
+	flat, cum time.Duration
+	ops       int
+}
+
+// WriteTop writes a table of the n functions and the n lines that took the
+// most time to w. A function's flat time is the time spent running its own
+// lines, and its cumulative time includes the functions it called.
+func WriteTop(w io.Writer, samples []interpreter.ProfileSample, n int) error {
+	var total time.Duration
+	totalOps := 0
+	functions := make(map[string]*cost)
+	lines := make(map[string]*cost)
+	get := func(costs map[string]*cost, name string) *cost {
+		c := costs[name]
+		if c == nil {
+			c = &cost{name: name}
+			costs[name] = c
+		}
+		return c
+	}
+	for _, s := range samples {
+		total += s.Duration
+		totalOps += s.Ops
+		leaf := s.Stack[0]
+		f := get(functions, functionName(leaf))
+		f.flat += s.Duration
+		f.ops += s.Ops
+		l := get(lines, lineName(leaf))
+		l.flat += s.Duration
+		l.ops += s.Ops
+		seen := make(map[string]bool)
+		for _, frame := range s.Stack {
+			name := functionName(frame)
+			if !seen[name] {
+				seen[name] = true
+				get(functions, name).cum += s.Duration
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Total: %s, %d ops\n\n", seconds(total), totalOps)
+	fmt.Fprintf(tw, "flat\tflat%%\tcum\tcum%%\tops\t  \tfunction\n")
+	for _, c := range top(functions, n) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t  \t%s\n", seconds(c.flat), percent(c.flat, total),
+			seconds(c.cum), percent(c.cum, total), c.ops, c.name)
+	}
+	fmt.Fprintf(tw, "\nflat\tflat%%\tops\t  \tline\n")
+	for _, c := range top(lines, n) {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t  \t%s\n", seconds(c.flat), percent(c.flat, total), c.ops, c.name)
+	}
+	return tw.Flush()
+}
+
+// functionName returns the name of the function of frame as shown in
+// reports, with the file it's in.
+func functionName(frame interpreter.ProfileFrame) string {
+	if frame.Position.File == "" {
+		return frame.Function
+	}
+	return frame.Function + " (" + frame.Position.File + ")"
+}
+
+// lineName returns the line of frame as shown in reports.
+func lineName(frame interpreter.ProfileFrame) string {
+	if frame.Position.File == "" {
+		return fmt.Sprintf("line %d", frame.Position.Line)
+	}
+	return fmt.Sprintf("%s:%d", frame.Position.File, frame.Position.Line)
+}
+
+// top returns the n costs with the most flat time, ordered by flat time
+// and then by name.
+func top(costs map[string]*cost, n int) []*cost {
+	sorted := make([]*cost, 0, len(costs))
+	for _, c := range costs {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].flat != sorted[j].flat {
+			return sorted[i].flat > sorted[j].flat
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func percent(d, total time.Duration) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(d)/float64(total))
+}
@@ -0,0 +1,138 @@
+// DaVinci Script
+
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/DavinciScript/Davi/interpreter"
+	. "github.com/DavinciScript/Davi/lexer"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func frame(function string, line int) interpreter.ProfileFrame {
+	return interpreter.ProfileFrame{Function: function, Position: Position{File: "p.davi", Line: line}}
+}
+
+var samples = []interpreter.ProfileSample{
+	{Stack: []interpreter.ProfileFrame{frame("main", 1)}, Duration: 10 * time.Millisecond, Ops: 5},
+	{Stack: []interpreter.ProfileFrame{frame("f", 3), frame("main", 2)}, Duration: 60 * time.Millisecond, Ops: 30},
+	{Stack: []interpreter.ProfileFrame{frame("f", 4), frame("f", 3), frame("main", 2)}, Duration: 30 * time.Millisecond, Ops: 10},
+}
+
+func TestWriteTop(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteTop(&out, samples, 2); err != nil {
+		t.Fatal(err)
+	}
+	expected := `Total: 0.100s, 45 ops
+
+    flat  flat%     cum    cum%  ops    function
+  0.090s  90.0%  0.090s   90.0%   40    f (p.davi)
+  0.010s  10.0%  0.100s  100.0%    5    main (p.davi)
+
+    flat  flat%  ops    line
+  0.060s  60.0%   30    p.davi:3
+  0.030s  30.0%   10    p.davi:4
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+// fields decodes a protocol buffer message into its fields, with varints
+// as uint64s and length-delimited fields as byte slices.
+func fields(t *testing.T, data []byte) map[int][]interface{} {
+	m := make(map[int][]interface{})
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			x, n := binary.Uvarint(data)
+			data = data[n:]
+			m[field] = append(m[field], x)
+		case 2:
+			length, n := binary.Uvarint(data)
+			data = data[n:]
+			m[field] = append(m[field], data[:length])
+			data = data[length:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return m
+}
+
+func TestWritePprof(t *testing.T) {
+	var out bytes.Buffer
+	if err := WritePprof(&out, samples, time.Unix(100, 0), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := fields(t, data)
+
+	strings := []string{}
+	for _, s := range profile[profileStringTable] {
+		strings = append(strings, string(s.([]byte)))
+	}
+	str := func(x interface{}) string { return strings[x.(uint64)] }
+	if len(strings) == 0 || strings[0] != "" {
+		t.Fatalf("expected string table to start with \"\", got %q", strings)
+	}
+	if len(profile[profileSample]) != len(samples) {
+		t.Fatalf("expected %d samples, got %d", len(samples), len(profile[profileSample]))
+	}
+	if len(profile[profileLocation]) != 4 || len(profile[profileFunction]) != 2 {
+		t.Errorf("expected 4 locations and 2 functions, got %d and %d",
+			len(profile[profileLocation]), len(profile[profileFunction]))
+	}
+	if d := profile[profileDurationNanos][0].(uint64); d != uint64(time.Second) {
+		t.Errorf("expected duration 1s, got %dns", d)
+	}
+	if s := str(profile[profileDefaultSampleType][0]); s != "time" {
+		t.Errorf("expected default sample type time, got %s", s)
+	}
+
+	functions := make(map[uint64]string)
+	for _, f := range profile[profileFunction] {
+		f := fields(t, f.([]byte))
+		functions[f[functionID][0].(uint64)] = str(f[functionNameField][0]) + " " + str(f[functionFilename][0])
+	}
+	locations := make(map[uint64]string)
+	for _, l := range profile[profileLocation] {
+		l := fields(t, l.([]byte))
+		line := fields(t, l[locationLine][0].([]byte))
+		locations[l[locationID][0].(uint64)] = functions[line[lineFunctionID][0].(uint64)]
+	}
+
+	// The last sample is f:4 called from f:3 called from main:2
+	sample := fields(t, profile[profileSample][2].([]byte))
+	ids := sample[sampleLocationID][0].([]byte)
+	names := []string{}
+	for len(ids) > 0 {
+		id, n := binary.Uvarint(ids)
+		ids = ids[n:]
+		names = append(names, locations[id])
+	}
+	if len(names) != 3 || names[0] != "f p.davi" || names[1] != "f p.davi" || names[2] != "main p.davi" {
+		t.Errorf("expected stack [f f main], got %q", names)
+	}
+	values := sample[sampleValue][0].([]byte)
+	ops, n := binary.Uvarint(values)
+	nanos, _ := binary.Uvarint(values[n:])
+	if ops != 10 || nanos != uint64(30*time.Millisecond) {
+		t.Errorf("expected values 10 ops, 30ms, got %d ops, %dns", ops, nanos)
+	}
+}
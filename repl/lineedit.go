@@ -0,0 +1,230 @@
+// DaVinci Script
+
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// completer returns the possible completions of the word ending at pos in
+// line, and the index in line where that word starts.
+type completer func(line []rune, pos int) (start int, candidates []string)
+
+// lineEditor reads lines from a terminal in raw mode, with cursor movement,
+// history and tab completion.
+type lineEditor struct {
+	in       *os.File
+	reader   *bufio.Reader
+	out      io.Writer
+	history  []string
+	complete completer
+}
+
+func newLineEditor(in *os.File, out io.Writer, complete completer) *lineEditor {
+	return &lineEditor{in: in, reader: bufio.NewReader(in), out: out, complete: complete}
+}
+
+// addHistory adds a line to the history, skipping blanks and repeats.
+func (e *lineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+// lineState is the line being edited.
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+}
+
+func (e *lineEditor) refresh(s *lineState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", s.prompt, string(s.buf))
+	if column := len([]rune(s.prompt)) + s.pos; column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
+
+func (s *lineState) insert(runes ...rune) {
+	buf := make([]rune, 0, len(s.buf)+len(runes))
+	buf = append(buf, s.buf[:s.pos]...)
+	buf = append(buf, runes...)
+	buf = append(buf, s.buf[s.pos:]...)
+	s.buf = buf
+	s.pos += len(runes)
+}
+
+// ReadLine reads one line of input after printing prompt. It returns io.EOF
+// if the user presses Ctrl-D on an empty line.
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(int(e.in.Fd()))
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	s := &lineState{prompt: prompt}
+	historyIndex := len(e.history)
+	saved := ""
+	lastWasTab := false
+	e.refresh(s)
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		isTab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if s.pos < len(s.buf) {
+				s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if s.pos > 0 {
+				s.buf = append(s.buf[:s.pos-1], s.buf[s.pos:]...)
+				s.pos--
+			}
+		case 1: // Ctrl-A
+			s.pos = 0
+		case 5: // Ctrl-E
+			s.pos = len(s.buf)
+		case 2: // Ctrl-B
+			if s.pos > 0 {
+				s.pos--
+			}
+		case 6: // Ctrl-F
+			if s.pos < len(s.buf) {
+				s.pos++
+			}
+		case 11: // Ctrl-K
+			s.buf = s.buf[:s.pos]
+		case 21: // Ctrl-U
+			s.buf = s.buf[s.pos:]
+			s.pos = 0
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case '\t':
+			isTab = true
+			e.completeWord(s, lastWasTab)
+		case 27: // Escape sequence
+			switch e.readEscape() {
+			case "[A", "OA":
+				if historyIndex > 0 {
+					if historyIndex == len(e.history) {
+						saved = string(s.buf)
+					}
+					historyIndex--
+					s.buf = []rune(e.history[historyIndex])
+					s.pos = len(s.buf)
+				}
+			case "[B", "OB":
+				if historyIndex < len(e.history) {
+					historyIndex++
+					line := saved
+					if historyIndex < len(e.history) {
+						line = e.history[historyIndex]
+					}
+					s.buf = []rune(line)
+					s.pos = len(s.buf)
+				}
+			case "[C", "OC":
+				if s.pos < len(s.buf) {
+					s.pos++
+				}
+			case "[D", "OD":
+				if s.pos > 0 {
+					s.pos--
+				}
+			case "[H", "OH", "[1~", "[7~":
+				s.pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				s.pos = len(s.buf)
+			case "[3~":
+				if s.pos < len(s.buf) {
+					s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+				}
+			}
+		default:
+			if r >= 32 {
+				s.insert(r)
+			}
+		}
+		lastWasTab = isTab
+		e.refresh(s)
+	}
+}
+
+// readEscape reads the rest of an escape sequence after the ESC byte.
+func (e *lineEditor) readEscape() string {
+	first, _, err := e.reader.ReadRune()
+	if err != nil || (first != '[' && first != 'O') {
+		return ""
+	}
+	seq := []rune{first}
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, r)
+		if (r >= 'A' && r <= 'Z') || r == '~' {
+			return string(seq)
+		}
+	}
+}
+
+// completeWord completes the word before the cursor. A unique candidate is
+// inserted; otherwise the common prefix of the candidates is, and pressing
+// tab twice lists them.
+func (e *lineEditor) completeWord(s *lineState, list bool) {
+	if e.complete == nil {
+		return
+	}
+	start, candidates := e.complete(s.buf, s.pos)
+	if len(candidates) == 0 {
+		return
+	}
+	word := string(s.buf[start:s.pos])
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		s.insert([]rune(prefix[len(word):])...)
+		return
+	}
+	if len(candidates) > 1 && list {
+		sort.Strings(candidates)
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
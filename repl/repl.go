@@ -0,0 +1,194 @@
+// DaVinci Script
+
+// Package repl implements davi's interactive read-eval-print loop.
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/lexer"
+	"github.com/DavinciScript/Davi/parser"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	prompt             = ">>> "
+	continuationPrompt = "... "
+	historyFile        = ".davi_history"
+	maxHistory         = 1000
+)
+
+// Run reads statements and expressions from in, evaluates them against a
+// persistent global scope and prints the value of each expression to out.
+// When in is a terminal, lines can be edited, recalled from the history
+// (kept in ~/.davi_history) and completed with tab.
+func Run(config *interpreter.Config, in *os.File, out io.Writer) error {
+	r := interpreter.NewRepl(config)
+
+	var readLine func(prompt string) (string, error)
+	var addHistory func(line string)
+	if isTerminal(int(in.Fd())) {
+		editor := newLineEditor(in, out, func(line []rune, pos int) (int, []string) {
+			return complete(r, line, pos)
+		})
+		historyPath := ""
+		if home, err := os.UserHomeDir(); err == nil {
+			historyPath = filepath.Join(home, historyFile)
+			editor.history = loadHistory(historyPath)
+		}
+		readLine = editor.ReadLine
+		addHistory = func(line string) {
+			editor.addHistory(line)
+			if historyPath != "" {
+				saveHistory(historyPath, editor.history)
+			}
+		}
+		fmt.Fprintln(out, "DaVinci Script REPL. Press Ctrl-D to exit.")
+	} else {
+		scanner := bufio.NewScanner(in)
+		readLine = func(prompt string) (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}
+		addHistory = func(line string) {}
+	}
+
+	source := ""
+	for {
+		p := prompt
+		if source != "" {
+			p = continuationPrompt
+		}
+		line, err := readLine(p)
+		if err == errInterrupted {
+			source = ""
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		addHistory(line)
+
+		source += line + "\n"
+		if strings.TrimSpace(source) == "" {
+			source = ""
+			continue
+		}
+		if incomplete(source) {
+			continue
+		}
+		eval(r, []byte(source), out)
+		source = ""
+	}
+}
+
+// eval parses and executes one complete input and prints its result.
+func eval(r *interpreter.Repl, source []byte, out io.Writer) {
+	prog, err := parser.ParseProgram(source)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	value, err := r.Execute(prog)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	if value != nil {
+		fmt.Fprintln(out, interpreter.ToString(value, true))
+	}
+}
+
+// incomplete reports whether source needs more lines: it has unclosed
+// braces, brackets or parentheses, or fails to parse only because it ends
+// too early.
+func incomplete(source string) bool {
+	l := lexer.NewLexer([]byte(source))
+	depth := 0
+	var end lexer.Position
+	for {
+		pos, tok, _ := l.Next()
+		if tok == lexer.ILLEGAL {
+			return false
+		}
+		if tok == lexer.EOF {
+			end = pos
+			break
+		}
+		switch tok {
+		case lexer.LBRACE, lexer.LBRACKET, lexer.LPAREN:
+			depth++
+		case lexer.RBRACE, lexer.RBRACKET, lexer.RPAREN:
+			depth--
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+	_, err := parser.ParseProgram([]byte(source))
+	if e, ok := err.(parser.Error); ok {
+		return e.Position == end
+	}
+	return false
+}
+
+// complete returns the completions of the name before pos in line: global
+// variables for $-prefixed names, otherwise globals (including builtin
+// functions) and keywords.
+func complete(r *interpreter.Repl, line []rune, pos int) (int, []string) {
+	start := pos
+	for start > 0 && isNameRune(line[start-1]) {
+		start--
+	}
+	dollar := start > 0 && line[start-1] == '$'
+	prefix := string(line[start:pos])
+	if prefix == "" && !dollar {
+		return start, nil
+	}
+	names := r.Names()
+	if !dollar {
+		names = append(names, lexer.Keywords()...)
+	}
+	candidates := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	return start, candidates
+}
+
+func isNameRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func loadHistory(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	return lines
+}
+
+func saveHistory(path string, history []string) {
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	os.WriteFile(path, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
@@ -0,0 +1,114 @@
+// DaVinci Script
+
+package repl
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/interpreter"
+	"strings"
+	"testing"
+)
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		source     string
+		incomplete bool
+	}{
+		// Unclosed brackets
+		{"function f() {", true},
+		{"$x = [1,", true},
+		{"echo(1", true},
+		{"$x = {\"a\": (1", true},
+		// Input that ends too early
+		{"$x = 1 +", true},
+		{"$x =", true},
+		{"if (true) { echo(1) } else", true},
+		// Complete input, and errors more lines won't fix
+		{"function f() {\n}", false},
+		{"if (true) { echo(1) }", false},
+		{"$x = 1", false},
+		{"$x = )", false},
+		{"echo(1))", false},
+		{`"abc`, false},
+	}
+	for _, test := range tests {
+		if got := incomplete(test.source + "\n"); got != test.incomplete {
+			t.Errorf("incomplete(%q) = %v, expected %v", test.source, got, test.incomplete)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := interpreter.NewRepl(&interpreter.Config{})
+	eval(r, []byte("$name = 1\nfunction greet() { }\n"), &bytes.Buffer{})
+	tests := []struct {
+		line        string
+		pos         int // -1 for the end of the line
+		start       int
+		completions string
+	}{
+		{"$na", -1, 1, "name"},
+		{"1 + $n", -1, 5, "name"},
+		{"gr", -1, 0, "greet"},
+		{"whi", -1, 0, "while"},
+		{"ec", -1, 0, "echo"},
+		{"echo($na)", 8, 6, "name"},
+		{"camel", -1, 0, "camelCase"},
+		{"upp", -1, 0, "upper"},
+		{"", -1, 0, ""},
+		{"echo(", -1, 5, ""},
+		{"$x->", -1, 4, ""},
+		{"zzz", -1, 0, ""},
+	}
+	for _, test := range tests {
+		line := []rune(test.line)
+		pos := test.pos
+		if pos < 0 {
+			pos = len(line)
+		}
+		start, completions := complete(r, line, pos)
+		if start != test.start || strings.Join(completions, " ") != test.completions {
+			t.Errorf("complete(%q, %d) = %d, %q, expected %d, %q",
+				test.line, pos, start, completions, test.start, test.completions)
+		}
+	}
+
+	// A $ alone completes every variable, and no keywords
+	_, completions := complete(r, []rune("$"), 1)
+	found := map[string]bool{}
+	for _, c := range completions {
+		found[c] = true
+	}
+	if !found["name"] || !found["greet"] || !found["echo"] || found["while"] {
+		t.Errorf("complete(\"$\") = %q", completions)
+	}
+}
+
+func TestEval(t *testing.T) {
+	var out bytes.Buffer
+	r := interpreter.NewRepl(&interpreter.Config{Stdout: &out})
+	for _, source := range []string{
+		`$name = "World"`,
+		`"Hello, " + $name`,
+		"function greet() {\n    return 1\n}",
+		"greet()",
+		"echo(2)",
+		"$nope",
+		"return 3",
+		"1 +",
+		"[1, 2];",
+	} {
+		eval(r, []byte(source+"\n"), &out)
+	}
+	expected := `"Hello, World"
+1
+2
+name error at 1:2: name "nope" not found
+runtime error at 1:1: can't return at top level
+parse error at 2:1: expected expression, not EOF
+[1, 2]
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
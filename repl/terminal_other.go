@@ -0,0 +1,17 @@
+// DaVinci Script
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package repl
+
+import "errors"
+
+// isTerminal reports whether fd is a terminal. Line editing is only
+// supported on Unix-like systems, so this always returns false.
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("raw terminal mode not supported")
+}
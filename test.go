@@ -0,0 +1,291 @@
+// DaVinci Script
+
+package main
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/cache"
+	"github.com/DavinciScript/Davi/cover"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"github.com/DavinciScript/Davi/tester"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const testUsage = `Usage: davi test [-v] [--run <regexp>] [--junit <file>] [--coverage] [--examples] [path...]
+
+Runs the tests in Davi test files. Directories are searched for files
+ending in _test.davi; with no paths, the current directory is searched
+(unless --examples is given).
+
+A test is a top-level function whose name starts with "test". Each test
+runs in a fresh interpreter: the file's top-level code runs first, then
+the test function is called. Tests use these builtins:
+
+  assert($condition[, $message])             fail unless condition is true
+  assertEquals($expected, $actual[, $message])
+                                             fail unless the values are ==
+  assertThrows($function[, $substring])      fail unless calling function
+                                             raises an error containing
+                                             substring; returns the message
+
+Options:
+  -v               list passing tests too, with their output
+  --run <regexp>   only run tests whose names match regexp
+  --junit <file>   also write the results to file as JUnit XML
+  --coverage       record which lines and branches the tests run, and write
+                   coverage.lcov and coverage.html to the current directory
+  --examples       check that the example of each builtin function in its
+                   documentation outputs what the documentation says
+
+Exits with status 1 if any test fails, or 3 if a file can't be parsed.
+`
+
+// runTests implements the "davi test" command.
+func runTests(args []string) int {
+	verbose := false
+	var filter *regexp.Regexp
+	junit := ""
+	examples := false
+	coverage := false
+	paths := []string{}
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "-v":
+			verbose = true
+		case "--examples":
+			examples = true
+		case "--coverage":
+			coverage = true
+		case "--run", "--junit":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "davi test: %s requires an argument\n\n%s", arg, testUsage)
+				return exitUsageError
+			}
+			i++
+			if arg == "--junit" {
+				junit = args[i]
+				break
+			}
+			var err error
+			filter, err = regexp.Compile(args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "davi test: invalid --run pattern: %s\n", err)
+				return exitUsageError
+			}
+		case "-h", "--help":
+			fmt.Print(testUsage)
+			return exitOK
+		case "--":
+			paths = append(paths, args[i+1:]...)
+			i = len(args)
+		default:
+			if strings.HasPrefix(arg, "-") {
+				fmt.Fprintf(os.Stderr, "davi test: unknown option %s\n\n%s", arg, testUsage)
+				return exitUsageError
+			}
+			paths = append(paths, arg)
+		}
+	}
+	if len(paths) == 0 && !examples {
+		paths = []string{"."}
+	}
+	files, err := testFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsageError
+	}
+
+	config := interpreter.Config{}
+	if coverage {
+		config.Coverage = interpreter.NewCoverage()
+	}
+	programs := cache.Default(version)
+	suites := []*tester.Suite{}
+	passed, failed, skipped, parseErrors := 0, 0, 0, 0
+	report := func(suite *tester.Suite, summary string) {
+		suites = append(suites, suite)
+		for _, r := range suite.Results {
+			switch r.Status {
+			case tester.Pass:
+				passed++
+				if !verbose {
+					continue
+				}
+			case tester.Skip:
+				skipped++
+				if !verbose {
+					continue
+				}
+			default:
+				failed++
+			}
+			printResult(r)
+		}
+		status := "ok"
+		if failures, errors := suite.Counts(); failures+errors > 0 {
+			status = "FAIL"
+		}
+		fmt.Printf("%s\t%s\t%s%s\n", status, suite.File, durationString(suite.Duration), summary)
+	}
+	if examples {
+		report(tester.Examples(), "")
+	}
+	for _, file := range files {
+		input, err := ioutil.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsageError
+		}
+		prog, err := programs.Parse(file, parser.StripTags(input))
+		if err != nil {
+			fmt.Printf("FAIL\t%s [parse error]\n    %s\n", file, err)
+			suites = append(suites, &tester.Suite{File: file, Err: err})
+			parseErrors++
+			continue
+		}
+		names := []string{}
+		for _, name := range tester.Tests(prog) {
+			if filter == nil || filter.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Printf("?\t%s\t[no tests]\n", file)
+			continue
+		}
+		config.Args = []string{file}
+		suite := tester.Run(file, prog, names, config)
+		summary := ""
+		if coverage {
+			lines := cover.Summarize(cover.Lines(config.Coverage, file))
+			summary = fmt.Sprintf("\tcoverage: %.1f%% of lines", lines.Percent())
+		}
+		report(suite, summary)
+	}
+
+	if coverage {
+		if code := writeCoverage(config.Coverage); code != exitOK {
+			return code
+		}
+	}
+	if junit != "" {
+		if code := writeJUnit(junit, suites); code != exitOK {
+			return code
+		}
+	}
+	fmt.Printf("\n%d passed, %d failed", passed, failed)
+	if skipped > 0 {
+		fmt.Printf(", %d skipped", skipped)
+	}
+	if parseErrors > 0 {
+		plural := "s"
+		if parseErrors == 1 {
+			plural = ""
+		}
+		fmt.Printf(", %d file%s with parse errors", parseErrors, plural)
+	}
+	fmt.Println()
+	switch {
+	case parseErrors > 0:
+		return exitParseError
+	case failed > 0:
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+// printResult prints the result of a test, with its error and output
+// indented below it.
+func printResult(r tester.Result) {
+	fmt.Printf("--- %s: %s (%s)\n", r.Status, r.Name, durationString(r.Duration))
+	if r.Message != "" {
+		fmt.Printf("    %s\n", r.Message)
+	}
+	output := strings.TrimSuffix(r.Output, "\n")
+	if output != "" {
+		fmt.Printf("    %s\n", strings.Replace(output, "\n", "\n    ", -1))
+	}
+}
+
+func durationString(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+// writeJUnit writes the JUnit XML report of suites to file.
+func writeJUnit(file string, suites []*tester.Suite) int {
+	f, err := os.Create(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsageError
+	}
+	err = tester.WriteJUnit(f, suites)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", file, err)
+		return exitUsageError
+	}
+	return exitOK
+}
+
+// writeCoverage writes the coverage reports for the --coverage option.
+func writeCoverage(coverage *interpreter.Coverage) int {
+	write := func(file string, w func(io.Writer) error) int {
+		f, err := os.Create(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsageError
+		}
+		err = w(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %s\n", file, err)
+			return exitUsageError
+		}
+		return exitOK
+	}
+	code := write("coverage.lcov", func(w io.Writer) error {
+		return cover.WriteLCOV(w, coverage)
+	})
+	if code != exitOK {
+		return code
+	}
+	return write("coverage.html", func(w io.Writer) error {
+		return cover.WriteHTML(w, coverage, ioutil.ReadFile)
+	})
+}
+
+// testFiles returns the test files in paths: files given by name, and the
+// files ending in _test.davi under directories.
+func testFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := daviFiles([]string{path})
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range found {
+			if strings.HasSuffix(file, "_test.davi") {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
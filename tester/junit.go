@@ -0,0 +1,109 @@
+// DaVinci Script
+
+package tester
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The JUnit XML elements written by WriteJUnit, as read by CI systems.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// seconds formats d as JUnit does, in seconds with millisecond precision.
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// WriteJUnit writes the results of suites to w as JUnit XML, with a
+// testsuite for each file and a testcase for each test. A file that
+// couldn't be parsed is written as a testcase named after the file with
+// the parse error as its error.
+func WriteJUnit(w io.Writer, suites []*Suite) error {
+	report := junitTestSuites{Suites: []junitTestSuite{}}
+	var total time.Duration
+	for _, s := range suites {
+		suite := junitTestSuite{Name: s.File, Time: seconds(s.Duration)}
+		if s.Err != nil {
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      s.File,
+				ClassName: s.File,
+				Time:      seconds(0),
+				Error:     &junitProblem{"parse error", s.Err.Error()},
+			})
+			suite.Errors++
+		}
+		for _, r := range s.Results {
+			c := junitTestCase{
+				Name:      r.Name,
+				ClassName: s.File,
+				Time:      seconds(r.Duration),
+				SystemOut: r.Output,
+			}
+			switch r.Status {
+			case Fail:
+				c.Failure = &junitProblem{r.Message, r.Message}
+				suite.Failures++
+			case Error:
+				c.Error = &junitProblem{r.Message, r.Message}
+				suite.Errors++
+			case Skip:
+				c.Skipped = &struct{}{}
+				suite.Skipped++
+			}
+			suite.Cases = append(suite.Cases, c)
+		}
+		suite.Tests = len(suite.Cases)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		total += s.Duration
+		report.Suites = append(report.Suites, suite)
+	}
+	report.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,167 @@
+// DaVinci Script
+
+// Package tester runs the tests in Davi test files, for the "davi test"
+// command.
+//
+// A test is a function defined at the top level of a test file whose name
+// starts with "test". Each test runs in a fresh interpreter: the file is
+// executed from the start, so its top-level code can set up globals, and
+// then the test function is called (and awaited if it's async). A test
+// passes if it returns, fails if it raises an interpreter.AssertionError
+// (from assert(), assertEquals() or assertThrows()), and is an error if it
+// raises any other error.
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a test.
+type Status int
+
+const (
+	Pass  Status = iota // the test returned
+	Fail                // an assertion failed
+	Error               // the test raised another error
+	Skip                // the test wasn't run
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	default:
+		return "ERROR"
+	}
+}
+
+// Result is the result of running one test.
+type Result struct {
+	Name     string
+	Status   Status
+	Message  string // the error, if the test didn't pass
+	Output   string // what the test printed
+	Duration time.Duration
+}
+
+// Suite is the results of the tests in one file.
+type Suite struct {
+	File     string
+	Results  []Result
+	Err      error // the parse error, if the file couldn't be parsed
+	Duration time.Duration
+}
+
+// Counts returns the number of tests in the suite that failed, and that
+// had errors.
+func (s *Suite) Counts() (failures, errors int) {
+	for _, r := range s.Results {
+		switch r.Status {
+		case Fail:
+			failures++
+		case Error:
+			errors++
+		}
+	}
+	return failures, errors
+}
+
+// Tests returns the names of the tests in prog, in the order they're
+// defined.
+func Tests(prog *parser.Program) []string {
+	names := []string{}
+	for _, s := range prog.Statements {
+		if f, ok := s.(*parser.FunctionDefinition); ok && strings.HasPrefix(f.Name, "test") {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// Run runs the named tests in prog, which was parsed from file, each in a
+// fresh interpreter configured by config. The config's Stdout is replaced
+// to capture each test's output, and its Exit by one that stops the test
+// with an error.
+func Run(file string, prog *parser.Program, names []string, config interpreter.Config) *Suite {
+	suite := &Suite{File: file}
+	start := time.Now()
+	for _, name := range names {
+		suite.Results = append(suite.Results, runTest(prog, name, config))
+	}
+	suite.Duration = time.Since(start)
+	return suite
+}
+
+// exited is panicked with by a test's exit() builtin.
+type exited int
+
+func runTest(prog *parser.Program, name string, config interpreter.Config) (result Result) {
+	var output bytes.Buffer
+	config.Stdout = &output
+	config.Exit = func(code int) { panic(exited(code)) }
+	start := time.Now()
+	result.Name = name
+	defer func() {
+		if r := recover(); r != nil {
+			code, ok := r.(exited)
+			if !ok {
+				panic(r)
+			}
+			result.Status = Error
+			result.Message = fmt.Sprintf("exit(%d) called", int(code))
+		}
+		result.Output = output.String()
+		result.Duration = time.Since(start)
+	}()
+
+	session := interpreter.New(&config).NewSession()
+	err := session.Execute(prog)
+	if err == nil {
+		_, err = session.Call(name)
+	}
+	switch err.(type) {
+	case nil:
+		result.Status = Pass
+	case interpreter.AssertionError:
+		result.Status = Fail
+		result.Message = err.Error()
+	default:
+		result.Status = Error
+		result.Message = err.Error()
+	}
+	return result
+}
+
+// Examples checks the examples in the documentation of the builtin
+// functions with interpreter.CheckExamples, returning a suite named
+// "builtin examples" with a test for each function. An example fails if
+// its output isn't the documented output.
+func Examples() *Suite {
+	suite := &Suite{File: "builtin examples"}
+	start := time.Now()
+	for _, r := range interpreter.CheckExamples() {
+		result := Result{Name: r.Doc.Name}
+		switch {
+		case r.Skipped:
+			result.Status = Skip
+		case r.Err != nil:
+			result.Status = Error
+			result.Message = fmt.Sprintf("%s: %v", r.Doc.Example, r.Err)
+		case !r.Passed():
+			result.Status = Fail
+			result.Message = fmt.Sprintf("%s: documented output %s, got %s", r.Doc.Example, r.Doc.Output, r.Output)
+		}
+		suite.Results = append(suite.Results, result)
+	}
+	suite.Duration = time.Since(start)
+	return suite
+}
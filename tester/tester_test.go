@@ -0,0 +1,83 @@
+// DaVinci Script
+
+package tester
+
+import (
+	"bytes"
+	"errors"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const source = `
+$list = [];
+append($list, 1);
+
+function testPass() { assertEquals([1], $list); append($list, 2); }
+function testIsolated() { echo($list); assertEquals([1], $list); }
+function testFail() { echo("out"); assert(false, "oops"); }
+function testError() { nil + 1 }
+function testExit() { exit(2) }
+function helper() { assert(false) }
+$testNotAFunction = 1;
+`
+
+func TestRun(t *testing.T) {
+	prog, err := parser.ParseFile("a_test.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := Tests(prog)
+	expected := []string{"testPass", "testIsolated", "testFail", "testError", "testExit"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected tests %v, got %v", expected, names)
+	}
+
+	suite := Run("a_test.davi", prog, names, interpreter.Config{})
+	results := []struct {
+		status  Status
+		message string
+		output  string
+	}{
+		{Pass, "", ""},
+		{Pass, "", "[1]\n"},
+		{Fail, "assertion failed at a_test.davi:7:36: oops: condition is false", "out\n"},
+		{Error, "type error at a_test.davi:8:28: + requires", ""},
+		{Error, "exit(2) called", ""},
+	}
+	if len(suite.Results) != len(results) {
+		t.Fatalf("expected %d results, got %d", len(results), len(suite.Results))
+	}
+	for i, r := range suite.Results {
+		want := results[i]
+		if r.Name != names[i] || r.Status != want.status || !strings.HasPrefix(r.Message, want.message) || r.Output != want.output {
+			t.Errorf("%s: expected %v %q %q, got %v %q %q", names[i], want.status, want.message, want.output, r.Status, r.Message, r.Output)
+		}
+	}
+	if failures, errors := suite.Counts(); failures != 1 || errors != 2 {
+		t.Errorf("expected 1 failure and 2 errors, got %d and %d", failures, errors)
+	}
+
+	var out bytes.Buffer
+	suites := []*Suite{suite, {File: "b_test.davi", Err: errors.New("parse error")}}
+	if err := WriteJUnit(&out, suites); err != nil {
+		t.Fatal(err)
+	}
+	report := out.String()
+	for _, s := range []string{
+		`<testsuites tests="6" failures="1" errors="3"`,
+		`<testsuite name="a_test.davi" tests="5" failures="1" errors="2"`,
+		`<testcase name="testFail" classname="a_test.davi"`,
+		`<failure message="assertion failed at a_test.davi:7:36: oops: condition is false">`,
+		`<system-out>out&#xA;</system-out>`,
+		`<testcase name="b_test.davi" classname="b_test.davi" time="0.000">`,
+		`<error message="parse error">parse error</error>`,
+	} {
+		if !strings.Contains(report, s) {
+			t.Errorf("JUnit report doesn't contain %s:\n%s", s, report)
+		}
+	}
+}
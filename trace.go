@@ -0,0 +1,45 @@
+// DaVinci Script
+
+package main
+
+import (
+	"fmt"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/trace"
+	"os"
+)
+
+// startTrace creates the file given by --trace and sets config to trace
+// the script to it, returning a function that flushes and closes it. The
+// trace is also flushed if the script calls exit().
+func startTrace(config *interpreter.Config, options *runOptions) (func(), error) {
+	f, err := os.Create(options.trace)
+	if err != nil {
+		return nil, err
+	}
+	w := trace.NewWriter(f, options.traceFilter)
+	config.Tracer = w.Trace
+	done := false
+	finish := func() {
+		if done {
+			return
+		}
+		done = true
+		err := w.Flush()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing trace %s: %s\n", options.trace, err)
+		}
+	}
+	exit := config.Exit
+	if exit == nil {
+		exit = os.Exit
+	}
+	config.Exit = func(code int) {
+		finish()
+		exit(code)
+	}
+	return finish, nil
+}
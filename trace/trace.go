@@ -0,0 +1,158 @@
+// DaVinci Script
+
+// Package trace logs the events reported to an interpreter.Config.Tracer
+// as JSON lines, one object per event, for "davi run --trace". Each object
+// has the fields:
+//
+//	time      when the event happened, in RFC 3339 format
+//	event     "statement", "call", "return" or "builtin"
+//	file      the file of the statement or call
+//	line      the line of the statement or call
+//	column    the column of the statement or call
+//	function  the function the event happened in ("main" at the top level)
+//	depth     the number of user function calls on the call stack
+//	callee    the function called, for calls, returns and builtin calls
+//	args      the arguments of a call or builtin call, as Davi source
+//	result    the value a call returned, as Davi source
+//	error     the error a call raised instead of returning
+//
+// Fields that don't apply to an event are left out.
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/DavinciScript/Davi/interpreter"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Filter picks the events to log. An event matches if its file matches
+// one of Files and its function or callee is one of Functions. An empty
+// list matches every event.
+type Filter struct {
+	// Files are file names or glob patterns (see filepath.Match), matched
+	// against the whole file name and against its last element.
+	Files []string
+
+	// Functions are function names.
+	Functions []string
+}
+
+// Match reports whether event matches the filter.
+func (f *Filter) Match(event *interpreter.TraceEvent) bool {
+	return f.matchFile(event.Position.File) && f.matchFunction(event)
+}
+
+func (f *Filter) matchFile(file string) bool {
+	if len(f.Files) == 0 {
+		return true
+	}
+	for _, pattern := range f.Files {
+		if ok, _ := filepath.Match(pattern, file); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchFunction(event *interpreter.TraceEvent) bool {
+	if len(f.Functions) == 0 {
+		return true
+	}
+	for _, name := range f.Functions {
+		if name == event.Function || name == event.Callee {
+			return true
+		}
+	}
+	return false
+}
+
+// record is the JSON object logged for an event.
+type record struct {
+	Time     string   `json:"time"`
+	Event    string   `json:"event"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Function string   `json:"function"`
+	Depth    int      `json:"depth"`
+	Callee   string   `json:"callee,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	Result   *string  `json:"result,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Writer writes the events that match its filter to an io.Writer as JSON
+// lines. Its Trace method can be used as a Config.Tracer.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	enc    *json.Encoder
+	filter Filter
+	err    error
+	now    func() time.Time
+}
+
+// NewWriter returns a Writer that writes the events matching filter to w.
+// Call Flush when the program has finished.
+func NewWriter(w io.Writer, filter Filter) *Writer {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &Writer{w: bw, enc: enc, filter: filter, now: time.Now}
+}
+
+// Trace writes event if it matches the filter. It's safe to call from
+// several goroutines at once.
+func (w *Writer) Trace(event *interpreter.TraceEvent) {
+	if !w.filter.Match(event) {
+		return
+	}
+	r := record{
+		Event:    event.Kind.String(),
+		File:     event.Position.File,
+		Line:     event.Position.Line,
+		Column:   event.Position.Column,
+		Function: event.Function,
+		Depth:    event.Depth,
+		Callee:   event.Callee,
+	}
+	if event.Kind == interpreter.TraceCall || event.Kind == interpreter.TraceBuiltin {
+		r.Args = make([]string, len(event.Args))
+		for i, arg := range event.Args {
+			r.Args[i] = interpreter.ToString(arg, true)
+		}
+	}
+	if event.Kind == interpreter.TraceReturn {
+		if event.Error != nil {
+			r.Error = event.Error.Error()
+		} else {
+			result := interpreter.ToString(event.Result, true)
+			r.Result = &result
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r.Time = w.now().Format(time.RFC3339Nano)
+	if w.err == nil {
+		w.err = w.enc.Encode(r)
+	}
+}
+
+// Flush writes any buffered events, returning the first error writing
+// them, if any.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.w.Flush(); w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
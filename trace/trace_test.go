@@ -0,0 +1,68 @@
+// DaVinci Script
+
+package trace
+
+import (
+	"bytes"
+	"github.com/DavinciScript/Davi/interpreter"
+	"github.com/DavinciScript/Davi/parser"
+	"testing"
+	"time"
+)
+
+const source = `function add($a, $b) {
+    return $a + $b;
+}
+echo(add(1, 2));
+`
+
+func run(t *testing.T, filter Filter) string {
+	prog, err := parser.ParseFile("dir/a.davi", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := NewWriter(&out, filter)
+	w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	_, err = interpreter.Execute(prog, &interpreter.Config{Stdout: &bytes.Buffer{}, Tracer: w.Trace})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestWriter(t *testing.T) {
+	expected := `{"time":"2024-01-02T03:04:05Z","event":"statement","file":"dir/a.davi","line":1,"column":1,"function":"main","depth":0}
+{"time":"2024-01-02T03:04:05Z","event":"statement","file":"dir/a.davi","line":4,"column":1,"function":"main","depth":0}
+{"time":"2024-01-02T03:04:05Z","event":"call","file":"dir/a.davi","line":4,"column":6,"function":"main","depth":0,"callee":"add","args":["1","2"]}
+{"time":"2024-01-02T03:04:05Z","event":"statement","file":"dir/a.davi","line":2,"column":5,"function":"add","depth":1}
+{"time":"2024-01-02T03:04:05Z","event":"return","file":"dir/a.davi","line":4,"column":6,"function":"main","depth":0,"callee":"add","result":"3"}
+{"time":"2024-01-02T03:04:05Z","event":"builtin","file":"dir/a.davi","line":4,"column":1,"function":"main","depth":0,"callee":"echo","args":["3"]}
+`
+	if got := run(t, Filter{}); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		filter Filter
+		lines  int
+	}{
+		{Filter{Files: []string{"a.davi"}}, 6},
+		{Filter{Files: []string{"dir/*.davi"}}, 6},
+		{Filter{Files: []string{"b.davi"}}, 0},
+		{Filter{Functions: []string{"add"}}, 3},  // the call, its statement and the return
+		{Filter{Functions: []string{"echo"}}, 1}, // the builtin call
+		{Filter{Files: []string{"b.davi"}, Functions: []string{"add"}}, 0},
+	}
+	for _, test := range tests {
+		out := run(t, test.filter)
+		if lines := bytes.Count([]byte(out), []byte("\n")); lines != test.lines {
+			t.Errorf("%+v: expected %d events, got %d:\n%s", test.filter, test.lines, lines, out)
+		}
+	}
+}